@@ -2,12 +2,18 @@ package main
 
 import (
 	"crossform.io/pkg/RepoManager"
+	"crossform.io/pkg/api"
+	"crossform.io/pkg/cli"
 	"crossform.io/pkg/crossplane"
+	"crossform.io/pkg/executor"
 	"crossform.io/pkg/logger"
 	"crossform.io/pkg/repo"
 	"fmt"
+	"github.com/alecthomas/kong"
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
 	"github.com/rs/zerolog"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/runtime"
@@ -16,6 +22,7 @@ import (
 	"k8s.io/client-go/tools/cache"
 	"net/http"
 	"os"
+	"path/filepath"
 	"pkg.icikowski.pl/kubeprobes"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"time"
@@ -54,7 +61,12 @@ func makeModulesInformer(stopper chan struct{}, repoManager *RepoManager.RepoMan
 				log.Error().Err(err).Msg("Unable to unmarshal module")
 				return
 			}
+			if configOld.SameSettings(configNew) {
+				return
+			}
+			// the module keeps the repository, only its update settings changed
 			if configOld.Hash == configNew.Hash {
+				repoManager.ConfigUpdates <- configNew
 				return
 			}
 			repoManager.ConfigDeletes <- configOld
@@ -79,15 +91,68 @@ func makeModulesInformer(stopper chan struct{}, repoManager *RepoManager.RepoMan
 	return modulesInformer, nil
 }
 
-func main() {
+// CLI of the repository server.
+type CLI struct {
+	Serve  ServeCmd      `cmd:"" default:"1" help:"Run the repository server."`
+	Run    cli.RunCmd    `cmd:"" help:"Execute a module locally, without a cluster, and print the function response."`
+	Diff   cli.DiffCmd   `cmd:"" help:"Show how desired resources and outputs of a module change between two git revisions."`
+	Schema cli.SchemaCmd `cmd:"" help:"Print the OpenAPI v3 schema of the module inputs or a CompositeResourceDefinition of the module."`
+
+	Evaluate EvaluateCmd `cmd:"" hidden:"" help:"Serve one isolated evaluation of the repository server on stdin and stdout."`
+}
+
+type ServeCmd struct {
+	EvalTimeout         time.Duration `default:"1m" env:"EVAL_TIMEOUT" help:"Wall clock budget of one module evaluation, 0 disables it. XRs override it by spec.limits.timeout."`
+	EvalMaxMemory       string        `env:"EVAL_MAX_MEMORY" help:"Memory budget of the child process of one module evaluation, like 512Mi, it applies only with --eval-isolation process or sandbox. XRs override it by spec.limits.maxMemory."`
+	EvalMaxSteps        uint64        `default:"10000000" env:"EVAL_MAX_STEPS" help:"Execution steps of every starlark file and function, 0 disables it, other engines do not count steps. XRs override it by spec.limits.maxSteps."`
+	EvalIsolation       string        `default:"none" enum:"none,process,sandbox" env:"EVAL_ISOLATION" help:"Evaluate modules in the server process (none), in child processes (process) or in sandboxed child processes without network and with a read only file system (sandbox)."`
+	CueRegistry         string        `env:"CUE_REGISTRY" help:"Registry of dependencies of CUE modules, like registry.example.com/cue."`
+	CueCacheDir         string        `default:"repos/cue" env:"CUE_CACHE_DIR" help:"Cache of CUE modules fetched from the registry."`
+	UpdatePeriod        time.Duration `env:"REPO_UPDATE_PERIOD" help:"Period of polling repositories for updates, 3m with a webhook secret and 30s without it. Push webhooks refresh them immediately."`
+	WebhookSecret       string        `env:"WEBHOOK_SECRET" help:"Secret of push webhooks, /webhook is disabled without it."`
+	KnownHostsConfigMap string        `default:"crossform-known-hosts" env:"KNOWN_HOSTS_CONFIGMAP" help:"ConfigMap with known hosts of ssh repositories in the key ssh_known_hosts."`
+	ApiAddress          string        `env:"API_ADDRESS" help:"Address of the diff and graph API, like :8081. The API has no authentication, it is disabled without an address."`
+}
+
+func (c *ServeCmd) Run() error {
 	logger.InitLog()
 	log := logger.GetLogger("controller")
 
+	executor.DefaultLimits.Timeout = c.EvalTimeout
+	executor.DefaultLimits.MaxSteps = c.EvalMaxSteps
+	if c.EvalMaxMemory != "" {
+		q, err := resource.ParseQuantity(c.EvalMaxMemory)
+		if err != nil {
+			return errors.Wrap(err, "invalid --eval-max-memory")
+		}
+		executor.DefaultLimits.MaxMemory = q.Value()
+	}
+	executor.Isolation.Mode = executor.IsolationMode(c.EvalIsolation)
+	self, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "unable to find the executable for isolated evaluations")
+	}
+	executor.Isolation.Command = []string{self, "evaluate"}
+
+	// push webhooks make frequent polling unnecessary
+	if c.UpdatePeriod != 0 {
+		repo.DefaultUpdatePeriod = c.UpdatePeriod
+	} else if c.WebhookSecret != "" {
+		repo.DefaultUpdatePeriod = repo.WebhookUpdatePeriod
+	}
+	repo.KnownHostsConfigMap = c.KnownHostsConfigMap
+
 	repoManager, err := RepoManager.NewRepoManager()
 	if err != nil {
 		log.Panic().Err(err).Msg("unable to start repoManager")
 		os.Exit(3)
 	}
+	executor.Imports = repoManager
+	executor.CueRegistry = c.CueRegistry
+	executor.CueCacheDir, err = filepath.Abs(c.CueCacheDir)
+	if err != nil {
+		return errors.Wrap(err, "invalid --cue-cache-dir")
+	}
 
 	stopper := make(chan struct{})
 	defer close(stopper)
@@ -95,8 +160,8 @@ func main() {
 
 	_, _ = makeModulesInformer(stopper, repoManager, log)
 
+	f := crossplane.NewFunction(repoManager)
 	functionStart := func() {
-		f := crossplane.NewFunction(repoManager)
 		err := f.Run()
 		if err != nil {
 			log.Panic().Err(err).Msg("unable to start crossplane function")
@@ -110,14 +175,14 @@ func main() {
 	}, 0)
 	if err != nil {
 		log.Panic().Err(err).Msg("unable to create probe")
-		return
+		return err
 	}
 	ready, err := kubeprobes.NewProbeFunction("ready", func() error {
 		return nil
 	}, 0)
 	if err != nil {
 		log.Panic().Err(err).Msg("unable to create probe")
-		return
+		return err
 	}
 
 	kp, err := kubeprobes.New(
@@ -126,18 +191,45 @@ func main() {
 	)
 	if err != nil {
 		log.Panic().Err(err).Msg("unable to create probe")
-		return
+		return err
+	}
+	server := api.NewServer(repoManager, f, kp, c.WebhookSecret)
+	if c.ApiAddress != "" {
+		apiServer := &http.Server{
+			Addr:    c.ApiAddress,
+			Handler: server.Api(),
+		}
+		go func() {
+			log.Info().Str("address", c.ApiAddress).Msg("Listening diff and graph API")
+			if err := apiServer.ListenAndServe(); err != nil {
+				log.Panic().Err(err).Msg("unable to start the diff and graph API")
+				os.Exit(1)
+			}
+		}()
 	}
 	probes := &http.Server{
 		Addr:    ":8080",
-		Handler: kp,
+		Handler: server,
 	}
 	err = probes.ListenAndServe()
 	if err != nil {
 		log.Panic().Err(err).Msg("unable to create probe")
-		return
+		return err
 	}
 
 	//<-stopper
 
+	return nil
+}
+
+type EvaluateCmd struct{}
+
+func (c *EvaluateCmd) Run() error {
+	logger.InitLogWriter(os.Stderr)
+	return executor.ServeIsolated(os.Stdin, os.Stdout)
+}
+
+func main() {
+	ctx := kong.Parse(&CLI{}, kong.Name("crossform"), kong.Description("Crossform repository server."))
+	ctx.FatalIfErrorf(ctx.Run())
 }
@@ -1,17 +1,31 @@
 package RepoManager
 
 import (
+	"crossform.io/pkg/diff"
 	"crossform.io/pkg/executor"
 	"crossform.io/pkg/logger"
 	"crossform.io/pkg/repo"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/rs/zerolog"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
+// importsPath keeps exported trees of imported repositories by commit shas.
+const importsPath = "repos/imports"
+
+// frozenPath keeps exported trees of commits of paused modules by commit shas.
+const frozenPath = "repos/frozen"
+
+// importCloneTimeout limits the wait for the first clone of an imported repository, evaluations importing
+// a repository which is still being cloned after it fail and are retried by the next reconcile.
+var importCloneTimeout = time.Minute * 2
+
 type RepoManager struct {
 	repos         map[string]*repo.Repo
 	locker        sync.RWMutex
@@ -20,6 +34,15 @@ type RepoManager struct {
 	stop          chan bool
 	log           zerolog.Logger
 	uses          map[string]int
+	// imports keeps directories of the last exports of repositories imported by modules by hashes of imported
+	// repositories and hashes of repositories of the modules, every imported repository holds a use of each importing
+	// repository, it is released when the importing one is removed. Exports no import references are removed.
+	imports map[string]map[string]string
+	// modules keeps configs of modules by hashes of their repositories and module names
+	modules map[string]map[string]*repo.Config
+	// commits keeps commit shas of the last evaluations of modules by hashes of their repositories and module names,
+	// paused modules stay at theirs while the checkout follows the revision for the other modules
+	commits map[string]map[string]string
 }
 
 func NewRepoManager() (*RepoManager, error) {
@@ -29,6 +52,9 @@ func NewRepoManager() (*RepoManager, error) {
 		ConfigDeletes: make(chan *repo.Config, 10000),
 		log:           logger.GetLogger("RepoManager").With().Logger(),
 		uses:          make(map[string]int),
+		imports:       make(map[string]map[string]string),
+		modules:       make(map[string]map[string]*repo.Config),
+		commits:       make(map[string]map[string]string),
 	}
 
 	if _, err := os.Stat("./repos"); os.IsNotExist(err) {
@@ -63,37 +89,166 @@ func (m *RepoManager) worker() {
 			select {
 			case config := <-m.ConfigUpdates:
 				m.log.Debug().Str("config", config.Url).Msg("config update received")
-				r, err := m.GetRepoByHash(config.Hash)
-				if err != nil {
+				m.locker.Lock()
+				r, exist := m.repos[config.Hash]
+				if !exist {
 					m.log.Debug().Str("config", config.Url).Msg("repository not found, creating a new one")
 					r = repo.NewRepo(config)
 					m.repos[config.Hash] = r
-					m.uses[config.Hash] = 1
-				} else {
+				}
+				if m.addModule(config) {
 					m.uses[config.Hash] = m.uses[config.Hash] + 1
 				}
+				m.configure(r, config.Hash)
+				m.locker.Unlock()
 			case config := <-m.ConfigDeletes:
 				m.log.Debug().Str("name", config.Url).Msg("config delete received")
-				r, err := m.GetRepoByHash(config.Hash)
+				_, err := m.GetRepoByHash(config.Hash)
 				if err != nil {
 					m.log.Warn().Str("name", config.Url).Msg("repository not found")
 					continue
 				}
-				if m.uses[config.Hash] > 1 {
-					m.uses[config.Hash] = m.uses[config.Hash] - 1
+				m.locker.Lock()
+				if !m.removeModule(config) {
+					m.locker.Unlock()
 					continue
 				}
-				err = r.Destroy()
-				if err != nil {
-					m.log.Error().Err(err).Str("name", config.Url).Msg("repository destroy failed")
+				removed := make(map[string]*repo.Repo)
+				m.release(config.Hash, removed)
+				m.locker.Unlock()
+				for hash, r := range removed {
+					err = r.Destroy()
+					if err != nil {
+						m.log.Error().Err(err).Str("hash", hash).Msg("repository destroy failed")
+					}
 				}
-				delete(m.repos, config.Hash)
-				delete(m.uses, config.Hash)
 			}
 		}
 	}
 }
 
+// release drops a use of the repository. The last use removes the repository and releases the uses of repositories
+// imported by its modules. Removed repositories are collected to be destroyed without holding the lock.
+func (m *RepoManager) release(hash string, removed map[string]*repo.Repo) {
+	r, exist := m.repos[hash]
+	if !exist {
+		return
+	}
+	if m.uses[hash] > 1 {
+		m.uses[hash] = m.uses[hash] - 1
+		m.configure(r, hash)
+		return
+	}
+	delete(m.repos, hash)
+	delete(m.uses, hash)
+	removed[hash] = r
+	imports := m.imports[hash]
+	delete(m.imports, hash)
+	for imported, dir := range imports {
+		m.removeExport(dir)
+		m.release(imported, removed)
+	}
+}
+
+// removeExport removes the exported tree of an import unless another import references it.
+func (m *RepoManager) removeExport(dir string) {
+	if dir == "" {
+		return
+	}
+	for _, imports := range m.imports {
+		for _, d := range imports {
+			if d == dir {
+				return
+			}
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		m.log.Error().Err(err).Str("directory", dir).Msg("unable to remove unused export")
+		return
+	}
+	m.log.Debug().Str("directory", dir).Msg("unused export removed")
+}
+
+// addModule registers the config of a module, it reports whether the module is new to the repository.
+// Configs without modules are always new.
+func (m *RepoManager) addModule(config *repo.Config) bool {
+	if config.Module == "" {
+		return true
+	}
+	modules, ok := m.modules[config.Hash]
+	if !ok {
+		modules = make(map[string]*repo.Config)
+		m.modules[config.Hash] = modules
+	}
+	prev, exist := modules[config.Module]
+	modules[config.Module] = config
+	if exist && prev.Paused && !config.Paused {
+		m.removeFrozen(m.commits[config.Hash][config.Module])
+	}
+	return !exist
+}
+
+// removeModule forgets the config of a module, it reports whether the module used the repository.
+func (m *RepoManager) removeModule(config *repo.Config) bool {
+	if config.Module == "" {
+		return true
+	}
+	modules := m.modules[config.Hash]
+	prev, exist := modules[config.Module]
+	if !exist {
+		return false
+	}
+	delete(modules, config.Module)
+	if len(modules) == 0 {
+		delete(m.modules, config.Hash)
+	}
+	sha := m.commits[config.Hash][config.Module]
+	delete(m.commits[config.Hash], config.Module)
+	if len(m.commits[config.Hash]) == 0 {
+		delete(m.commits, config.Hash)
+	}
+	if prev.Paused {
+		m.removeFrozen(sha)
+	}
+	return true
+}
+
+// removeFrozen removes the exported tree of the commit unless a paused module stays at it.
+func (m *RepoManager) removeFrozen(sha string) {
+	if sha == "" {
+		return
+	}
+	for hash, modules := range m.modules {
+		for name, config := range modules {
+			if config.Paused && m.commits[hash][name] == sha {
+				return
+			}
+		}
+	}
+	dir := filepath.Join(frozenPath, sha)
+	if err := os.RemoveAll(dir); err != nil {
+		m.log.Error().Err(err).Str("directory", dir).Msg("unable to remove unused export")
+	}
+}
+
+// configure applies update settings of modules sharing the repository: it is polled at the shortest update period
+// of them and paused while all of them pause it. A paused module sharing the checkout with modules following
+// the revision stays at its commit, see Execute.
+func (m *RepoManager) configure(r *repo.Repo, hash string) {
+	period := time.Duration(0)
+	paused := len(m.modules[hash]) > 0
+	for _, config := range m.modules[hash] {
+		if period == 0 || config.UpdatePeriod < period {
+			period = config.UpdatePeriod
+		}
+		paused = paused && config.Paused
+	}
+	if period == 0 {
+		period = repo.DefaultUpdatePeriod
+	}
+	r.Configure(period, paused)
+}
+
 func (m *RepoManager) GetRepoByHash(hash string) (*repo.Repo, error) {
 	m.locker.RLock()
 	defer m.locker.RUnlock()
@@ -118,13 +273,145 @@ func (m *RepoManager) GetRepo(url string, revision string) (*repo.Repo, error) {
 	return prev, nil
 }
 
+// ResolveImport checks out the revision of a repository imported by a module and returns the directory of its tree
+// and the commit sha, it implements executor.ImportResolver. Imported repositories are cloned with the credentials
+// of repository secrets and kept updated like repositories of modules while the repository of the importing module
+// is used. The first resolution waits for the clone of the repository.
+func (m *RepoManager) ResolveImport(importer *executor.ExecCommand, url, revision string) (string, string, error) {
+	config := repo.NewRevisionConfig(url, revision)
+	importerHash := repo.NewRevisionConfig(importer.RepositoryUrl, importer.RepositoryRevision).Hash
+	m.locker.Lock()
+	if _, exist := m.repos[importerHash]; !exist {
+		m.locker.Unlock()
+		return "", "", errors.New("Repository not found: " + importerHash)
+	}
+	r, exist := m.repos[config.Hash]
+	if !exist {
+		m.log.Debug().Str("url", url).Str("revision", revision).Msg("imported repository not found, creating a new one")
+		r = repo.NewRepo(config)
+		m.repos[config.Hash] = r
+	}
+	// a repository importing itself would never be released
+	if _, exist := m.imports[importerHash][config.Hash]; importerHash != config.Hash && !exist {
+		if m.imports[importerHash] == nil {
+			m.imports[importerHash] = make(map[string]string)
+		}
+		m.imports[importerHash][config.Hash] = ""
+		m.uses[config.Hash] = m.uses[config.Hash] + 1
+	}
+	m.locker.Unlock()
+	if !r.WaitInitialization(importCloneTimeout) {
+		return "", "", fmt.Errorf("repository %s@%s is still being cloned, the import is retried by the next reconcile", url, revision)
+	}
+	for {
+		dir, sha, err := r.Export(importsPath)
+		if err != nil {
+			return "", "", err
+		}
+		// the previous export of the import is removed once the import moves to another commit
+		m.locker.Lock()
+		if prev, exist := m.imports[importerHash][config.Hash]; exist && prev != dir {
+			m.imports[importerHash][config.Hash] = dir
+			m.removeExport(prev)
+		}
+		// a concurrent resolution may have removed the export before it was referenced, it is exported again
+		_, err = os.Stat(dir)
+		m.locker.Unlock()
+		if err == nil {
+			return dir, sha, nil
+		}
+	}
+}
+
+// Refresh makes repositories whose revision is changed by a push of the refs to one of the urls check for updates
+// now, it returns the number of refreshed repositories.
+func (m *RepoManager) Refresh(urls []string, refs []string) int {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	n := 0
+	for _, r := range m.repos {
+		for _, ref := range refs {
+			if r.Matches(urls, ref) {
+				r.Refresh()
+				n++
+				break
+			}
+		}
+	}
+	m.log.Debug().Strs("urls", urls).Strs("refs", refs).Int("repositories", n).Msg("refresh requested")
+	return n
+}
+
+// Execute evaluates the module at the checkout of its repository. A paused module is evaluated at the commit of
+// its last evaluation, which is exported when the checkout has moved on for other modules.
 func (m *RepoManager) Execute(execute *executor.ExecCommand) (*executor.ExecResult, error) {
 	prev, err := m.GetRepo(execute.RepositoryUrl, execute.RepositoryRevision)
 	if err != nil {
 		m.log.Error().Str("url", execute.RepositoryUrl).Str("revision", execute.RepositoryRevision).Msg("repository not found")
 		return nil, err
 	}
-	return prev.Execute(execute)
+	hash := repo.NewRevisionConfig(execute.RepositoryUrl, execute.RepositoryRevision).Hash
+	if sha := m.FrozenSha(execute.RepositoryUrl, execute.RepositoryRevision, execute.ModuleName); sha != "" {
+		dir, err := prev.ExportCommit(frozenPath, sha)
+		if err != nil {
+			return nil, err
+		}
+		return executor.Execute(dir, execute)
+	}
+	res, sha, err := prev.ExecuteCheckout(execute)
+	if sha != "" {
+		m.locker.Lock()
+		if _, exist := m.modules[hash][execute.ModuleName]; exist {
+			if m.commits[hash] == nil {
+				m.commits[hash] = make(map[string]string)
+			}
+			m.commits[hash][execute.ModuleName] = sha
+		}
+		m.locker.Unlock()
+	}
+	return res, err
+}
+
+// FrozenSha returns the commit sha a paused module is evaluated at, it is empty for modules following the revision
+// and for paused modules which are not evaluated yet.
+func (m *RepoManager) FrozenSha(url, revision, module string) string {
+	hash := repo.NewRevisionConfig(url, revision).Hash
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	config, exist := m.modules[hash][module]
+	if !exist || !config.Paused {
+		return ""
+	}
+	return m.commits[hash][module]
+}
+
+// Diff evaluates the module at the base revision and at the head revision against the same observed state.
+// An empty head means the currently checked out revision.
+func (m *RepoManager) Diff(execute *executor.ExecCommand, base, head string) (*diff.Result, error) {
+	prev, err := m.GetRepo(execute.RepositoryUrl, execute.RepositoryRevision)
+	if err != nil {
+		m.log.Error().Str("url", execute.RepositoryUrl).Str("revision", execute.RepositoryRevision).Msg("repository not found")
+		return nil, err
+	}
+	// evaluations change the command, every revision gets its own copy
+	baseResult, baseSha, err := prev.ExecuteRevision(base, execute.DeepCopy())
+	if err != nil {
+		return nil, errors.Join(errors.New("base revision execution failed"), err)
+	}
+	var headResult *executor.ExecResult
+	var headSha string
+	if head == "" {
+		headResult, headSha, err = prev.ExecuteCheckout(execute.DeepCopy())
+	} else {
+		headResult, headSha, err = prev.ExecuteRevision(head, execute.DeepCopy())
+	}
+	if err != nil {
+		return nil, errors.Join(errors.New("head revision execution failed"), err)
+	}
+	result := diff.Compare(baseResult, headResult)
+	result.BaseRevision = baseSha
+	result.HeadRevision = headSha
+	return result, nil
 }
 
 func (m *RepoManager) Destroy() {
@@ -0,0 +1,225 @@
+package RepoManager
+
+import (
+	"crossform.io/pkg/executor"
+	"crossform.io/pkg/logger"
+	"crossform.io/pkg/repo"
+	"fmt"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// initRemote creates a repository with a commit of the file.
+func initRemote(t *testing.T, dir, name, content string) {
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Add(name); err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Commit("init", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitRepos(t *testing.T, m *RepoManager, expected int) {
+	deadline := time.Now().Add(time.Second * 30)
+	for time.Now().Before(deadline) {
+		m.locker.RLock()
+		n := len(m.repos)
+		m.locker.RUnlock()
+		if n == expected {
+			return
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	t.Fatalf("expected %d repositories", expected)
+}
+
+func TestResolveImport(t *testing.T) {
+	logger.InitLog()
+	repo.KnownHostsConfigMap = ""
+	tmp := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(tmp); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	modules := filepath.Join(tmp, "modules")
+	libs := filepath.Join(tmp, "libs")
+	initRemote(t, modules, "main.jsonnet", "{}")
+	initRemote(t, libs, "k8s.libsonnet", "{}")
+
+	repo.DefaultUpdatePeriod = time.Hour
+	m, err := NewRepoManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	importer := &executor.ExecCommand{RepositoryUrl: modules, RepositoryRevision: "master"}
+	if _, _, err := m.ResolveImport(importer, libs, "master"); err == nil {
+		t.Fatal("a repository which is not managed imports")
+	}
+
+	config := repo.NewRevisionConfig(modules, "master")
+	m.ConfigUpdates <- config
+	waitRepos(t, m, 1)
+
+	// the first resolution waits for the clone
+	dir, sha, err := m.ResolveImport(importer, libs, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "k8s.libsonnet")); err != nil || sha == "" {
+		t.Fatalf("unexpected import %s of %s: %v", dir, sha, err)
+	}
+	if _, _, err := m.ResolveImport(importer, libs, "master"); err != nil {
+		t.Fatal(err)
+	}
+	if m.uses[repo.NewRevisionConfig(libs, "master").Hash] != 1 {
+		t.Fatal("every resolution holds a use of the imported repository")
+	}
+
+	// removing the importing repository releases the imported one
+	m.ConfigDeletes <- config
+	waitRepos(t, m, 0)
+	if len(m.uses) != 0 || len(m.imports) != 0 {
+		t.Fatalf("uses %v and imports %v are left", m.uses, m.imports)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("export %s of the released import is left", dir)
+	}
+}
+
+// commitFile commits the content of the file to the repository.
+func commitFile(t *testing.T, dir, name, content string) {
+	r, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Add(name); err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Commit("update", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPausedModule(t *testing.T) {
+	logger.InitLog()
+	repo.KnownHostsConfigMap = ""
+	tmp := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(tmp); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	module := "{ test1: std.extVar('crossform').resource('test1', { spec: { v: %d } }) }\n"
+	remote := filepath.Join(tmp, "modules")
+	initRemote(t, remote, "main.jsonnet", fmt.Sprintf(module, 1))
+
+	repo.DefaultUpdatePeriod = time.Hour
+	m, err := NewRepoManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs := make(map[string]*repo.Config)
+	for _, name := range []string{"paused", "auto"} {
+		configs[name] = repo.NewRevisionConfig(remote, "master")
+		configs[name].Module = name
+		m.ConfigUpdates <- configs[name]
+	}
+	waitRepos(t, m, 1)
+	r, err := m.GetRepo(remote, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.WaitInitialization(time.Second * 30) {
+		t.Fatal("repository is not cloned")
+	}
+	execute := func(name string) int64 {
+		cmd := &executor.ExecCommand{RepositoryUrl: remote, RepositoryRevision: "master", Path: ".", ModuleName: name, Context: "{}",
+			XR: &resource.Composite{Resource: composite.New()}}
+		cmd.XR.Resource.Object["spec"] = map[string]interface{}{}
+		res, err := m.Execute(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, _ := res.Desired["test1"].Resource.GetInteger("spec.v")
+		return v
+	}
+	commitSha := func() string {
+		r.Locker.RLock()
+		defer r.Locker.RUnlock()
+		return r.Status.CommitSha
+	}
+	execute("paused")
+	sha := commitSha()
+
+	paused := *configs["paused"]
+	paused.Paused = true
+	m.ConfigUpdates <- &paused
+	commitFile(t, remote, "main.jsonnet", fmt.Sprintf(module, 2))
+	deadline := time.Now().Add(time.Second * 30)
+	for m.FrozenSha(remote, "master", "paused") == "" || commitSha() == sha {
+		if time.Now().After(deadline) {
+			t.Fatal("the checkout does not follow the revision")
+		}
+		r.Refresh()
+		time.Sleep(time.Millisecond * 50)
+	}
+
+	// the paused module stays at its commit, the other one follows the revision
+	if v := execute("paused"); v != 1 || m.FrozenSha(remote, "master", "paused") != sha {
+		t.Fatalf("expected the paused module at %s, got spec.v %d", sha, v)
+	}
+	if v := execute("auto"); v != 2 || m.FrozenSha(remote, "master", "auto") != "" {
+		t.Fatalf("expected the module following the revision, got spec.v %d", v)
+	}
+
+	// resuming the module removes its export
+	m.ConfigUpdates <- configs["paused"]
+	for m.FrozenSha(remote, "master", "paused") != "" {
+		time.Sleep(time.Millisecond * 50)
+	}
+	if _, err := os.Stat(filepath.Join(frozenPath, sha)); !os.IsNotExist(err) {
+		t.Fatal("export of the resumed module is left")
+	}
+	if v := execute("paused"); v != 2 {
+		t.Fatalf("expected the resumed module to follow the revision, got spec.v %d", v)
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"crossform.io/pkg/RepoManager"
+	"crossform.io/pkg/executor"
+	"crossform.io/pkg/logger"
+	"encoding/json"
+	"errors"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+)
+
+var errCommandWithoutXR = errors.New("command has no xr")
+
+// maxCommandSize limits bodies of diff requests, commands carry the observed state of one module.
+const maxCommandSize = 16 << 20
+
+// GraphSource provides dependency graphs of the last evaluations of modules by XR uids.
+type GraphSource interface {
+	Graph(uid string) (*executor.Graph, bool)
+}
+
+// Server serves push webhooks next to the health probes, webhooks are served with a secret only. The diff and graph
+// API evaluates modules and shows their values, it is served by Api on a separate listener which is enabled
+// explicitly.
+type Server struct {
+	log         zerolog.Logger
+	repoManager *RepoManager.RepoManager
+	graphs      GraphSource
+	mux         *http.ServeMux
+	// webhookSecret signs push webhooks, /webhook is not served when it is empty
+	webhookSecret string
+}
+
+func NewServer(repoManager *RepoManager.RepoManager, graphs GraphSource, probes http.Handler, webhookSecret string) *Server {
+	s := &Server{
+		log:           logger.GetLogger("api").With().Logger(),
+		repoManager:   repoManager,
+		graphs:        graphs,
+		mux:           http.NewServeMux(),
+		webhookSecret: webhookSecret,
+	}
+	if webhookSecret != "" {
+		s.mux.HandleFunc("/webhook", s.webhook)
+	} else {
+		s.log.Info().Msg("webhook secret is not set, push webhooks are disabled")
+	}
+	s.mux.Handle("/", probes)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Api returns the handler of /diff and /graph. It has no authentication, so it is served on a listener reachable
+// by trusted clients only.
+func (s *Server) Api() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/diff", s.diff)
+	mux.HandleFunc("/graph", s.graph)
+	return mux
+}
+
+// diff evaluates a module at two revisions. The body is an ExecCommand YAML describing the module and its
+// observed state, the base and head query parameters select the revisions. An empty head means the checkout.
+func (s *Server) diff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	base := r.URL.Query().Get("base")
+	if base == "" {
+		http.Error(w, "base revision is required", http.StatusBadRequest)
+		return
+	}
+	cmd, err := readCommand(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log := s.log.With().Str("url", cmd.RepositoryUrl).Str("path", cmd.Path).Str("base", base).Logger()
+	res, err := s.repoManager.Diff(cmd, base, r.URL.Query().Get("head"))
+	if err != nil {
+		log.Error().Err(err).Msg("diff failed")
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, res.String())
+		return
+	}
+	writeJson(w, res)
+}
+
+// graph returns the dependency graph of the last evaluation of the module selected by the XR uid,
+// format=dot renders it for graphviz.
+func (s *Server) graph(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	uid := r.URL.Query().Get("uid")
+	if uid == "" {
+		http.Error(w, "uid of the XR is required", http.StatusBadRequest)
+		return
+	}
+	g, ok := s.graphs.Graph(uid)
+	if !ok {
+		http.Error(w, "module has not been evaluated yet", http.StatusNotFound)
+		return
+	}
+	if r.URL.Query().Get("format") == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		_, _ = io.WriteString(w, g.DOT())
+		return
+	}
+	writeJson(w, g)
+}
+
+func readCommand(w http.ResponseWriter, r *http.Request) (*executor.ExecCommand, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCommandSize))
+	if err != nil {
+		return nil, err
+	}
+	var cmd executor.ExecCommand
+	if err := yaml.Unmarshal(body, &cmd); err != nil {
+		return nil, err
+	}
+	if cmd.XR == nil {
+		return nil, errCommandWithoutXR
+	}
+	return &cmd, nil
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
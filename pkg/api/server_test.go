@@ -0,0 +1,34 @@
+package api
+
+import (
+	"crossform.io/pkg/executor"
+	"crossform.io/pkg/logger"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type graphs map[string]*executor.Graph
+
+func (g graphs) Graph(uid string) (*executor.Graph, bool) {
+	graph, ok := g[uid]
+	return graph, ok
+}
+
+func TestApiListener(t *testing.T) {
+	logger.InitLog()
+	s := NewServer(nil, graphs{"0b1c6a4e-vpc": {Module: "vpc"}}, http.NotFoundHandler(), testWebhookSecret)
+	for _, path := range []string{"/graph?uid=0b1c6a4e-vpc", "/diff?base=main"} {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("%s is served next to the probes with status %d", path, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.Api().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/graph?uid=0b1c6a4e-vpc", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d of the API, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+}
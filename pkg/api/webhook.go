@@ -0,0 +1,207 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxWebhookPayload limits bodies of webhook deliveries, GitHub caps payloads at 25MB.
+const maxWebhookPayload = 25 << 20
+
+var (
+	errWebhookSignature = errors.New("webhook signature mismatch")
+	errWebhookProvider  = errors.New("unknown webhook provider")
+)
+
+// push is a push of refs to a repository reachable by any of the urls.
+type push struct {
+	urls []string
+	refs []string
+}
+
+type githubPush struct {
+	Ref        string `json:"ref"`
+	Repository struct {
+		CloneUrl string `json:"clone_url"`
+		SshUrl   string `json:"ssh_url"`
+		HtmlUrl  string `json:"html_url"`
+		GitUrl   string `json:"git_url"`
+	} `json:"repository"`
+}
+
+type gitlabPush struct {
+	Ref     string `json:"ref"`
+	Project struct {
+		GitHttpUrl string `json:"git_http_url"`
+		GitSshUrl  string `json:"git_ssh_url"`
+		WebUrl     string `json:"web_url"`
+	} `json:"project"`
+}
+
+// bitbucketPush covers repo:push of Bitbucket Cloud and repo:refs_changed of Bitbucket Server.
+type bitbucketPush struct {
+	Repository struct {
+		Links struct {
+			Html struct {
+				Href string `json:"href"`
+			} `json:"html"`
+			Clone []struct {
+				Href string `json:"href"`
+			} `json:"clone"`
+		} `json:"links"`
+	} `json:"repository"`
+	Push struct {
+		Changes []struct {
+			New *struct {
+				Type string `json:"type"`
+				Name string `json:"name"`
+			} `json:"new"`
+		} `json:"changes"`
+	} `json:"push"`
+	Changes []struct {
+		Ref struct {
+			Id string `json:"id"`
+		} `json:"ref"`
+	} `json:"changes"`
+}
+
+// webhook refreshes repositories changed by a push. It accepts push events of GitHub, GitLab, Gitea and Bitbucket,
+// other events are acknowledged and ignored. Deliveries must be signed by the secret.
+func (s *Server) webhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayload))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := s.parsePush(r.Header, body)
+	if errors.Is(err, errWebhookSignature) {
+		s.log.Warn().Str("remote", r.RemoteAddr).Msg("webhook signature mismatch")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	refreshed := 0
+	if p != nil {
+		refreshed = s.repoManager.Refresh(p.urls, p.refs)
+		s.log.Info().Strs("urls", p.urls).Strs("refs", p.refs).Int("repositories", refreshed).Msg("push received")
+	}
+	writeJson(w, map[string]int{"refreshed": refreshed})
+}
+
+// parsePush verifies the delivery and returns the push it describes, nil for events other than pushes.
+func (s *Server) parsePush(h http.Header, body []byte) (*push, error) {
+	switch {
+	case h.Get("X-Gitea-Event") != "":
+		// Gitea sends X-GitHub-Event too, its signature header goes without the algorithm prefix
+		if !s.validHmac(body, h.Get("X-Gitea-Signature"), "") {
+			return nil, errWebhookSignature
+		}
+		if h.Get("X-Gitea-Event") != "push" {
+			return nil, nil
+		}
+		return parseGithubPush(body)
+	case h.Get("X-GitHub-Event") != "":
+		if !s.validHmac(body, h.Get("X-Hub-Signature-256"), "sha256=") {
+			return nil, errWebhookSignature
+		}
+		if h.Get("X-GitHub-Event") != "push" {
+			return nil, nil
+		}
+		return parseGithubPush(body)
+	case h.Get("X-Gitlab-Event") != "":
+		// GitLab sends the secret token as is
+		if s.webhookSecret == "" || subtle.ConstantTimeCompare([]byte(h.Get("X-Gitlab-Token")), []byte(s.webhookSecret)) != 1 {
+			return nil, errWebhookSignature
+		}
+		if e := h.Get("X-Gitlab-Event"); e != "Push Hook" && e != "Tag Push Hook" {
+			return nil, nil
+		}
+		var event gitlabPush
+		if err := json.Unmarshal(body, &event); err != nil {
+			return nil, err
+		}
+		return &push{
+			urls: []string{event.Project.GitHttpUrl, event.Project.GitSshUrl, event.Project.WebUrl},
+			refs: []string{event.Ref},
+		}, nil
+	case h.Get("X-Event-Key") != "":
+		if !s.validHmac(body, h.Get("X-Hub-Signature"), "sha256=") {
+			return nil, errWebhookSignature
+		}
+		if e := h.Get("X-Event-Key"); e != "repo:push" && e != "repo:refs_changed" {
+			return nil, nil
+		}
+		return parseBitbucketPush(body)
+	default:
+		return nil, errWebhookProvider
+	}
+}
+
+// validHmac checks the hex encoded HMAC-SHA256 of the body, no delivery is valid without a secret.
+func (s *Server) validHmac(body []byte, signature, prefix string) bool {
+	if s.webhookSecret == "" {
+		return false
+	}
+	if !strings.HasPrefix(signature, prefix) {
+		return false
+	}
+	sum, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.webhookSecret))
+	mac.Write(body)
+	return hmac.Equal(sum, mac.Sum(nil))
+}
+
+func parseGithubPush(body []byte) (*push, error) {
+	var event githubPush
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	repo := event.Repository
+	return &push{
+		urls: []string{repo.CloneUrl, repo.SshUrl, repo.HtmlUrl, repo.GitUrl},
+		refs: []string{event.Ref},
+	}, nil
+}
+
+func parseBitbucketPush(body []byte) (*push, error) {
+	var event bitbucketPush
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	p := &push{urls: []string{event.Repository.Links.Html.Href}}
+	for _, c := range event.Repository.Links.Clone {
+		p.urls = append(p.urls, c.Href)
+	}
+	for _, c := range event.Push.Changes {
+		// deleted refs have no new state
+		if c.New == nil {
+			continue
+		}
+		if c.New.Type == "tag" {
+			p.refs = append(p.refs, "refs/tags/"+c.New.Name)
+		} else {
+			p.refs = append(p.refs, "refs/heads/"+c.New.Name)
+		}
+	}
+	for _, c := range event.Changes {
+		p.refs = append(p.refs, c.Ref.Id)
+	}
+	return p, nil
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"bytes"
+	"crossform.io/pkg/RepoManager"
+	"crossform.io/pkg/logger"
+	"crossform.io/pkg/repo"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "webhook-secret"
+
+type delivery struct {
+	name      string
+	headers   map[string]string
+	body      string
+	code      int
+	refreshed int
+}
+
+func commitAndPush(t *testing.T, r *git.Repository, dir, content string) string {
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.jsonnet"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Add("main.jsonnet"); err != nil {
+		t.Fatal(err)
+	}
+	sha, err := w.Commit("update", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Push(&git.PushOptions{RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha.String()
+}
+
+func waitCommit(t *testing.T, r *RepoManager.RepoManager, url, sha string) {
+	deadline := time.Now().Add(time.Second * 30)
+	for time.Now().Before(deadline) {
+		if rp, err := r.GetRepo(url, "master"); err == nil {
+			rp.Locker.RLock()
+			current := rp.Status.CommitSha
+			rp.Locker.RUnlock()
+			if current == sha {
+				return
+			}
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	t.Fatalf("repository has not been updated to %s", sha)
+}
+
+func sign(body, prefix string) string {
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write([]byte(body))
+	return prefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhook(t *testing.T) {
+	logger.InitLog()
+	tmp := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(tmp); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	remote := filepath.Join(tmp, "remote.git")
+	if _, err := git.PlainInit(remote, true); err != nil {
+		t.Fatal(err)
+	}
+	work := filepath.Join(tmp, "work")
+	w, err := git.PlainInit(work, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}}); err != nil {
+		t.Fatal(err)
+	}
+	first := commitAndPush(t, w, work, "{}")
+
+	// polling must not update the repository during the test
+	repo.DefaultUpdatePeriod = time.Hour
+	m, err := RepoManager.NewRepoManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ConfigUpdates <- repo.NewRevisionConfig(remote, "master")
+	waitCommit(t, m, remote, first)
+	second := commitAndPush(t, w, work, "{a: 1}")
+
+	s := NewServer(m, nil, http.NotFoundHandler(), testWebhookSecret)
+	github := fmt.Sprintf(`{"ref":"refs/heads/master","repository":{"clone_url":"file://%s"}}`, remote)
+	otherBranch := fmt.Sprintf(`{"ref":"refs/heads/feature","repository":{"clone_url":"%s"}}`, remote)
+	gitlab := fmt.Sprintf(`{"ref":"refs/heads/master","project":{"git_http_url":"%s"}}`, remote)
+	bitbucket := fmt.Sprintf(`{"repository":{"links":{"clone":[{"href":"%s/"}]}},"push":{"changes":[{"new":{"type":"tag","name":"master"}},{"new":{"type":"branch","name":"master"}},{"new":null}]}}`, remote)
+	deliveries := []delivery{
+		{
+			name:    "unknown provider",
+			headers: map[string]string{},
+			body:    github,
+			code:    http.StatusBadRequest,
+		},
+		{
+			name:    "wrong signature",
+			headers: map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign(otherBranch, "sha256=")},
+			body:    github,
+			code:    http.StatusUnauthorized,
+		},
+		{
+			name:    "wrong gitlab token",
+			headers: map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"},
+			body:    gitlab,
+			code:    http.StatusUnauthorized,
+		},
+		{
+			name:    "ping",
+			headers: map[string]string{"X-GitHub-Event": "ping", "X-Hub-Signature-256": sign("{}", "sha256=")},
+			body:    "{}",
+			code:    http.StatusOK,
+		},
+		{
+			name:    "other branch",
+			headers: map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign(otherBranch, "sha256=")},
+			body:    otherBranch,
+			code:    http.StatusOK,
+		},
+		{
+			name:      "gitea",
+			headers:   map[string]string{"X-Gitea-Event": "push", "X-GitHub-Event": "push", "X-Gitea-Signature": sign(github, "")},
+			body:      github,
+			code:      http.StatusOK,
+			refreshed: 1,
+		},
+		{
+			name:      "gitlab",
+			headers:   map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": testWebhookSecret},
+			body:      gitlab,
+			code:      http.StatusOK,
+			refreshed: 1,
+		},
+		{
+			name:      "bitbucket",
+			headers:   map[string]string{"X-Event-Key": "repo:push", "X-Hub-Signature": sign(bitbucket, "sha256=")},
+			body:      bitbucket,
+			code:      http.StatusOK,
+			refreshed: 1,
+		},
+		{
+			name:      "github",
+			headers:   map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign(github, "sha256=")},
+			body:      github,
+			code:      http.StatusOK,
+			refreshed: 1,
+		},
+	}
+	for _, d := range deliveries {
+		t.Run(d.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(d.body))
+			for k, v := range d.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			if rec.Code != d.code {
+				t.Fatalf("expected status %d, got %d: %s", d.code, rec.Code, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var res map[string]int
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res["refreshed"] != d.refreshed {
+				t.Fatalf("expected %d refreshed repositories, got %d", d.refreshed, res["refreshed"])
+			}
+		})
+	}
+	waitCommit(t, m, remote, second)
+
+	// the checkout of paused modules stays at its commit until they are resumed
+	paused := repo.NewRevisionConfig(remote, "master")
+	paused.Module = "vpc"
+	paused.Paused = true
+	m.ConfigUpdates <- paused
+	r, err := m.GetRepo(remote, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second * 30)
+	for !isPaused(r) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 50)
+	}
+	if !isPaused(r) {
+		t.Fatal("repository has not been paused")
+	}
+	third := commitAndPush(t, w, work, "{a: 2}")
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(github))
+	req.Header.Set("X-GitHub-Event", "push")
+	req.Header.Set("X-Hub-Signature-256", sign(github, "sha256="))
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	time.Sleep(time.Millisecond * 500)
+	waitCommit(t, m, remote, second)
+
+	resumed := repo.NewRevisionConfig(remote, "master")
+	resumed.Module = "vpc"
+	m.ConfigUpdates <- resumed
+	waitCommit(t, m, remote, third)
+}
+
+func isPaused(r *repo.Repo) bool {
+	r.Locker.RLock()
+	defer r.Locker.RUnlock()
+	return r.Status.Paused
+}
+
+func TestWebhookWithoutSecret(t *testing.T) {
+	logger.InitLog()
+	s := NewServer(nil, nil, http.NotFoundHandler(), "")
+	body := `{"ref":"refs/heads/master","repository":{"clone_url":"https://example.com/modules.git"}}`
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(body))
+	req.Header.Set("X-GitHub-Event", "push")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("webhooks are served without a secret, got status %d", rec.Code)
+	}
+	if s.validHmac([]byte(body), "", "") {
+		t.Fatal("an unsigned delivery is valid without a secret")
+	}
+}
@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"crossform.io/pkg/diff"
+	"crossform.io/pkg/executor"
+	"crossform.io/pkg/repo"
+	"encoding/json"
+	"fmt"
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	"github.com/go-git/go-git/v5"
+	"os"
+	"path/filepath"
+)
+
+// DiffCmd evaluates a module at two revisions of its git repository against the same state
+// and prints the difference of the desired resources and outputs.
+type DiffCmd struct {
+	Module string `arg:"" help:"Module directory inside a git repository." type:"existingdir"`
+	Base   string `required:"" help:"Base revision: commit sha, tag or branch."`
+	Head   string `help:"Head revision, the working tree is used when empty."`
+	StateFlags
+	Output string `short:"o" help:"Output format." enum:"text,json" default:"text"`
+	Debug  bool   `short:"d" help:"Emit debug logs."`
+}
+
+func (c *DiffCmd) Run() error {
+	initLog(c.Debug)
+
+	cmd, err := c.StateFlags.Load()
+	if err != nil {
+		return err
+	}
+	setLocalSpec(cmd.XR)
+
+	r, err := git.PlainOpenWithOptions(c.Module, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return errors.Wrapf(err, "unable to open git repository of %s", c.Module)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	root := w.Filesystem.Root()
+	module, err := filepath.Abs(c.Module)
+	if err != nil {
+		return err
+	}
+	cmd.Path, err = filepath.Rel(root, module)
+	if err != nil {
+		return err
+	}
+
+	baseResult, baseSha, err := executeRevision(r, c.Base, cmd)
+	if err != nil {
+		return errors.Wrap(err, "base revision execution failed")
+	}
+	var headResult *executor.ExecResult
+	headSha := ""
+	if c.Head == "" {
+		headResult, err = executor.Execute(root, cmd)
+	} else {
+		headResult, headSha, err = executeRevision(r, c.Head, cmd)
+	}
+	if err != nil {
+		return errors.Wrap(err, "head revision execution failed")
+	}
+
+	res := diff.Compare(baseResult, headResult)
+	res.BaseRevision = baseSha
+	res.HeadRevision = headSha
+	if c.Output == "json" {
+		j, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(stdout, string(j))
+		return err
+	}
+	_, err = fmt.Fprint(stdout, res.String())
+	return err
+}
+
+func executeRevision(r *git.Repository, revision string, cmd *executor.ExecCommand) (*executor.ExecResult, string, error) {
+	dir, err := os.MkdirTemp("", "crossform-revision-")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(dir)
+	sha, err := repo.ExportRevision(r, revision, dir)
+	if err != nil {
+		return nil, "", err
+	}
+	res, err := executor.Execute(dir, cmd)
+	return res, sha, err
+}
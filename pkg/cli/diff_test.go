@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"crossform.io/pkg/diff"
+	"encoding/json"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const diffModule = `apiVersion: crossform.io/v1alpha1
+kind: Input
+metadata:
+  name: cidr
+spec:
+  type: string
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ${xr.metadata.name}
+  annotations:
+    crossform.io/id: config
+data:
+  cidr: ${inputs.cidr}
+  tier: %s
+`
+
+func TestDiffCmd(t *testing.T) {
+	out := captureStdout(t)
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "network/main.yaml", fmt.Sprintf(diffModule, "standard"))
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Add("network/main.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	sha, err := w.Commit("init", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "network/main.yaml", fmt.Sprintf(diffModule, "premium"))
+
+	c := &DiffCmd{
+		Module:     filepath.Join(dir, "network"),
+		Base:       "master",
+		StateFlags: StateFlags{XR: writeFile(t, t.TempDir(), "xr.yaml", testXR)},
+		Output:     "json",
+	}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	var res diff.Result
+	if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.BaseRevision != sha.String() || res.HeadRevision != "" {
+		t.Fatalf("unexpected revisions %s..%s", res.BaseRevision, res.HeadRevision)
+	}
+	if len(res.Resources) != 1 || res.Resources[0].Id != "config" || res.Resources[0].Action != diff.Changed {
+		t.Fatalf("unexpected resources:\n%s", out.String())
+	}
+	changes := res.Resources[0].Changes
+	if len(changes) != 1 || changes[0].Path != "data.tier" || changes[0].Before != "standard" || changes[0].After != "premium" {
+		t.Fatalf("unexpected changes:\n%s", out.String())
+	}
+
+	out.Reset()
+	c.Head = sha.String()
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.HeadRevision != sha.String() || res.HasChanges() {
+		t.Fatalf("a revision differs from itself:\n%s", out.String())
+	}
+}
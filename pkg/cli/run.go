@@ -0,0 +1,323 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crossform.io/pkg/crossplane"
+	"crossform.io/pkg/executor"
+	"crossform.io/pkg/logger"
+	"encoding/json"
+	"fmt"
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	fnv1beta1 "github.com/crossplane/function-sdk-go/proto/v1beta1"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composed"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+	yaml3 "gopkg.in/yaml.v3"
+	"io"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"os"
+	"sigs.k8s.io/yaml"
+)
+
+const compositionResourceNameAnnotation = "crossplane.io/composition-resource-name"
+
+// stdout receives the output of commands.
+var stdout io.Writer = os.Stdout
+
+// StateFlags describe the state a module is evaluated against, either as one ExecCommand YAML
+// file or as separate files for the XR, observed and requested resources and the context.
+type StateFlags struct {
+	Command   string `help:"ExecCommand YAML file, as written by the executor test data writer." type:"existingfile" xor:"command,xr"`
+	XR        string `name:"xr" help:"Composite resource YAML file." type:"existingfile" xor:"command,xr"`
+	Observed  string `help:"Observed composed resources, multi-document YAML. Ids are taken from the crossplane.io/composition-resource-name annotation." type:"existingfile"`
+	Requested string `help:"Requested extra resources, YAML map of request id to a resource or a list of resources." type:"existingfile"`
+	Context   string `help:"Function pipeline context YAML file." type:"existingfile"`
+}
+
+// RunCmd executes a module locally, without a cluster, and prints the function response.
+type RunCmd struct {
+	Module string `arg:"" help:"Module directory." type:"existingdir"`
+	StateFlags
+	Output string `short:"o" help:"Output format." enum:"yaml,json" default:"yaml"`
+	Graph  string `help:"Print the dependency graph of the module instead of the response." enum:",dot,json" default:""`
+	Debug  bool   `short:"d" help:"Emit debug logs."`
+}
+
+func (c *RunCmd) Run() error {
+	initLog(c.Debug)
+
+	cmd, err := c.StateFlags.Load()
+	if err != nil {
+		return err
+	}
+	setLocalSpec(cmd.XR)
+	req, err := NewRequest(cmd)
+	if err != nil {
+		return err
+	}
+	f := crossplane.NewLocalFunction(c.Module)
+	rsp, err := f.RunFunction(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	if c.Graph != "" {
+		g, ok := f.Graph(string(cmd.XR.Resource.GetUID()))
+		if !ok {
+			return printMessage(stdout, rsp, c.Output)
+		}
+		if c.Graph == "dot" {
+			_, err = io.WriteString(stdout, g.DOT())
+			return err
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(g)
+	}
+	return printMessage(stdout, rsp, c.Output)
+}
+
+// setLocalSpec fills in the repository fields required by RunFunction, a local module does not need them.
+func setLocalSpec(xr *resource.Composite) {
+	for _, field := range []string{"repository", "revision", "path"} {
+		_, found, _ := unstructured.NestedFieldNoCopy(xr.Resource.Object, "spec", field)
+		if !found {
+			_ = unstructured.SetNestedField(xr.Resource.Object, "", "spec", field)
+		}
+	}
+}
+
+func initLog(debug bool) {
+	logger.InitLogWriter(os.Stderr)
+	if debug {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+}
+
+func printMessage(out io.Writer, rsp *fnv1beta1.RunFunctionResponse, format string) error {
+	j, err := protojson.Marshal(rsp)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal function response")
+	}
+	if format == "json" {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, j, "", "  "); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, buf.String())
+		return err
+	}
+	y, err := yaml.JSONToYAML(j)
+	if err != nil {
+		return errors.Wrap(err, "unable to convert function response to yaml")
+	}
+	_, err = out.Write(y)
+	return err
+}
+
+// Load builds the ExecCommand described by the flags.
+func (f *StateFlags) Load() (*executor.ExecCommand, error) {
+	if f.Command != "" {
+		data, err := os.ReadFile(f.Command)
+		if err != nil {
+			return nil, err
+		}
+		var cmd executor.ExecCommand
+		if err := yaml3.Unmarshal(data, &cmd); err != nil {
+			return nil, errors.Wrapf(err, "unable to unmarshal command %s", f.Command)
+		}
+		if cmd.XR == nil {
+			return nil, errors.Errorf("command %s has no xr", f.Command)
+		}
+		return &cmd, nil
+	}
+	if f.XR == "" {
+		return nil, errors.New("either --command or --xr is required")
+	}
+
+	cmd := &executor.ExecCommand{
+		Observed:  make(map[resource.Name]resource.ObservedComposed),
+		Requested: make(map[string][]resource.Extra),
+		Context:   "{}",
+	}
+
+	xr, err := readObjects(f.XR)
+	if err != nil {
+		return nil, err
+	}
+	if len(xr) != 1 {
+		return nil, errors.Errorf("%s should contain exactly one resource", f.XR)
+	}
+	cmd.XR = &resource.Composite{
+		Resource:          &composite.Unstructured{Unstructured: unstructured.Unstructured{Object: xr[0]}},
+		ConnectionDetails: make(resource.ConnectionDetails),
+	}
+	cmd.ModuleName = cmd.XR.Resource.GetName()
+
+	if f.Observed != "" {
+		observed, err := readObjects(f.Observed)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range observed {
+			u := composed.Unstructured{Unstructured: unstructured.Unstructured{Object: o}}
+			id, ok := u.GetAnnotations()[compositionResourceNameAnnotation]
+			if !ok {
+				return nil, errors.Errorf("observed resource %s/%s has no %s annotation", u.GetKind(), u.GetName(), compositionResourceNameAnnotation)
+			}
+			cmd.Observed[resource.Name(id)] = resource.ObservedComposed{
+				Resource:          &u,
+				ConnectionDetails: make(resource.ConnectionDetails),
+			}
+		}
+	}
+
+	if f.Requested != "" {
+		data, err := os.ReadFile(f.Requested)
+		if err != nil {
+			return nil, err
+		}
+		requested := make(map[string]interface{})
+		if err := yaml.Unmarshal(data, &requested); err != nil {
+			return nil, errors.Wrapf(err, "unable to unmarshal %s", f.Requested)
+		}
+		for id, v := range requested {
+			items, ok := v.([]interface{})
+			if !ok {
+				items = []interface{}{v}
+			}
+			cmd.Requested[id] = make([]resource.Extra, 0, len(items))
+			for _, item := range items {
+				obj, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, errors.Errorf("requested resource %s should be an object or a list of objects", id)
+				}
+				cmd.Requested[id] = append(cmd.Requested[id], resource.Extra{Resource: &unstructured.Unstructured{Object: obj}})
+			}
+		}
+	}
+
+	if f.Context != "" {
+		data, err := os.ReadFile(f.Context)
+		if err != nil {
+			return nil, err
+		}
+		j, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to unmarshal %s", f.Context)
+		}
+		cmd.Context = string(j)
+	}
+	return cmd, nil
+}
+
+// readObjects reads a multi-document YAML file, expanding List kinds into their items.
+func readObjects(path string) ([]map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]map[string]interface{}, 0)
+	decoder := yaml3.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc interface{}
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to unmarshal %s", path)
+		}
+		if doc == nil {
+			continue
+		}
+		obj, err := normalize(doc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to unmarshal %s", path)
+		}
+		if items, ok := obj["items"].([]interface{}); ok && obj["kind"] == "List" {
+			for _, item := range items {
+				i, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, errors.Errorf("%s: list items should be objects", path)
+				}
+				objects = append(objects, i)
+			}
+			continue
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+// normalize converts a decoded YAML document into JSON compatible values.
+func normalize(doc interface{}) (map[string]interface{}, error) {
+	j, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	obj := make(map[string]interface{})
+	err = json.Unmarshal(j, &obj)
+	return obj, err
+}
+
+// NewRequest converts an ExecCommand into the RunFunctionRequest crossplane would send for the same state.
+func NewRequest(cmd *executor.ExecCommand) (*fnv1beta1.RunFunctionRequest, error) {
+	xr, err := toStruct(cmd.XR.Resource.Object)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to convert xr")
+	}
+	req := &fnv1beta1.RunFunctionRequest{
+		Observed: &fnv1beta1.State{
+			Composite: &fnv1beta1.Resource{
+				Resource:          xr,
+				ConnectionDetails: cmd.XR.ConnectionDetails,
+			},
+			Resources: make(map[string]*fnv1beta1.Resource),
+		},
+		ExtraResources: make(map[string]*fnv1beta1.Resources),
+		Context:        &structpb.Struct{},
+	}
+	for k, v := range cmd.Observed {
+		s, err := toStruct(v.Resource.Object)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to convert observed resource %s", k)
+		}
+		req.Observed.Resources[string(k)] = &fnv1beta1.Resource{
+			Resource:          s,
+			ConnectionDetails: v.ConnectionDetails,
+		}
+	}
+	for k, v := range cmd.Requested {
+		items := make([]*fnv1beta1.Resource, 0, len(v))
+		for _, vv := range v {
+			s, err := toStruct(vv.Resource.Object)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to convert requested resource %s", k)
+			}
+			items = append(items, &fnv1beta1.Resource{Resource: s})
+		}
+		req.ExtraResources[k] = &fnv1beta1.Resources{Items: items}
+	}
+	if cmd.Context != "" {
+		if err := protojson.Unmarshal([]byte(cmd.Context), req.Context); err != nil {
+			return nil, errors.Wrap(err, "unable to convert context")
+		}
+	}
+	return req, nil
+}
+
+func toStruct(obj map[string]interface{}) (*structpb.Struct, error) {
+	j, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	s := &structpb.Struct{}
+	err = protojson.Unmarshal(j, s)
+	return s, err
+}
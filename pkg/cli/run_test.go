@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"bytes"
+	"crossform.io/pkg/executor"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"testing"
+)
+
+// captureStdout redirects the output of commands into a buffer for the duration of the test.
+func captureStdout(t *testing.T) *bytes.Buffer {
+	var out bytes.Buffer
+	stdout = &out
+	t.Cleanup(func() { stdout = os.Stdout })
+	return &out
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testXR = `apiVersion: crossform.io/v1alpha1
+kind: xModule
+metadata:
+  name: network
+spec:
+  inputs:
+    cidr: 10.0.0.0/16
+`
+
+func TestStateFlagsLoad(t *testing.T) {
+	dir := t.TempDir()
+	flags := StateFlags{
+		XR: writeFile(t, dir, "xr.yaml", testXR),
+		Observed: writeFile(t, dir, "observed.yaml", `apiVersion: v1
+kind: List
+items:
+  - apiVersion: ec2.aws.upbound.io/v1beta1
+    kind: VPC
+    metadata:
+      name: network-vpc
+      annotations:
+        crossplane.io/composition-resource-name: vpc
+---
+apiVersion: ec2.aws.upbound.io/v1beta1
+kind: Subnet
+metadata:
+  name: network-subnet
+  annotations:
+    crossplane.io/composition-resource-name: subnet
+`),
+		Requested: writeFile(t, dir, "requested.yaml", `config:
+  apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: cluster
+secrets:
+  - apiVersion: v1
+    kind: Secret
+    metadata:
+      name: first
+  - apiVersion: v1
+    kind: Secret
+    metadata:
+      name: second
+`),
+		Context: writeFile(t, dir, "context.yaml", "apiextensions.crossplane.io/environment:\n  region: eu-west-1\n"),
+	}
+	cmd, err := flags.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.ModuleName != "network" || cmd.XR.Resource.GetName() != "network" {
+		t.Fatalf("unexpected module %s of the xr %s", cmd.ModuleName, cmd.XR.Resource.GetName())
+	}
+	if len(cmd.Observed) != 2 || cmd.Observed["vpc"].Resource.GetKind() != "VPC" || cmd.Observed["subnet"].Resource.GetKind() != "Subnet" {
+		t.Fatalf("unexpected observed resources %v", cmd.Observed)
+	}
+	if len(cmd.Requested["config"]) != 1 || len(cmd.Requested["secrets"]) != 2 {
+		t.Fatalf("unexpected requested resources %v", cmd.Requested)
+	}
+	if cmd.Context != `{"apiextensions.crossplane.io/environment":{"region":"eu-west-1"}}` {
+		t.Fatalf("unexpected context %s", cmd.Context)
+	}
+
+	invalid := map[string]StateFlags{
+		"either --command or --xr": {},
+		"exactly one resource":     {XR: writeFile(t, dir, "two.yaml", testXR+"---\n"+testXR)},
+		"has no crossplane.io/composition-resource-name annotation": {
+			XR:       flags.XR,
+			Observed: writeFile(t, dir, "unnamed.yaml", "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: unnamed\n"),
+		},
+		"should be an object or a list of objects": {
+			XR:        flags.XR,
+			Requested: writeFile(t, dir, "scalar.yaml", "config: [cluster]\n"),
+		},
+		"has no xr": {Command: writeFile(t, dir, "command.yaml", "path: .\n")},
+	}
+	for expected, f := range invalid {
+		if _, err := f.Load(); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected an error containing %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestRunCmd(t *testing.T) {
+	out := captureStdout(t)
+	// the jsonnet library is embedded, the command does not depend on the working directory
+	c := &RunCmd{
+		Module:     "../executor/testdata/jsonnet/new/src",
+		StateFlags: StateFlags{Command: "../executor/testdata/jsonnet/new/command.yaml"},
+		Output:     "yaml",
+	}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	var rsp map[string]interface{}
+	if err := yaml.Unmarshal(out.Bytes(), &rsp); err != nil {
+		t.Fatal(err)
+	}
+	desired, _ := rsp["desired"].(map[string]interface{})
+	resources, _ := desired["resources"].(map[string]interface{})
+	if _, ok := resources["test1"]; !ok {
+		t.Fatalf("resource test1 is not desired:\n%s", out.String())
+	}
+
+	out.Reset()
+	c.Graph = "json"
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	var g executor.Graph
+	if err := json.Unmarshal(out.Bytes(), &g); err != nil {
+		t.Fatal(err)
+	}
+	if g.Module == "" || len(g.Nodes) == 0 {
+		t.Fatalf("unexpected graph:\n%s", out.String())
+	}
+}
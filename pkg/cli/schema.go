@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"crossform.io/pkg/executor"
+	"encoding/json"
+	"fmt"
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// SchemaCmd prints the OpenAPI v3 schema of the inputs of a module, or a CompositeResourceDefinition of the module
+// typing its inputs and outputs.
+type SchemaCmd struct {
+	Module string `arg:"" help:"Module directory." type:"existingdir"`
+	Output string `short:"o" help:"Output format." enum:"yaml,json" default:"yaml"`
+	Debug  bool   `short:"d" help:"Emit debug logs."`
+
+	XRD         bool   `name:"xrd" help:"Print a CompositeResourceDefinition of the module instead of the inputs schema."`
+	Group       string `help:"API group of the XRD."`
+	Kind        string `help:"Kind of the composite resource."`
+	Plural      string `help:"Plural name of the composite resource, lowercase kind with an s suffix by default."`
+	ClaimKind   string `help:"Kind of the claim, the XRD has no claim if empty."`
+	ClaimPlural string `help:"Plural name of the claim, lowercase claim kind with an s suffix by default."`
+	Version     string `help:"Version of the XRD." default:"v1alpha1"`
+	Repository  string `help:"Default of spec.repository."`
+	Revision    string `help:"Default of spec.revision."`
+	Path        string `help:"Default of spec.path."`
+	RepoServer  string `help:"Default of spec.repoServer, the repository server address used by the proxy function."`
+}
+
+func (c *SchemaCmd) Run() error {
+	initLog(c.Debug)
+
+	xr := &resource.Composite{
+		Resource: &composite.Unstructured{Unstructured: unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "crossform.io/v1alpha1",
+			"kind":       "xModule",
+			"metadata": map[string]interface{}{
+				"name": "schema",
+			},
+			"spec": map[string]interface{}{
+				"inputs": map[string]interface{}{},
+			},
+		}}},
+		ConnectionDetails: make(resource.ConnectionDetails),
+	}
+	cmd := &executor.ExecCommand{
+		Path:       ".",
+		Observed:   make(map[resource.Name]resource.ObservedComposed),
+		Requested:  make(map[string][]resource.Extra),
+		ModuleName: "schema",
+		XR:         xr,
+		Context:    "{}",
+	}
+	schema, err := executor.InputsSchema(c.Module, cmd)
+	if err != nil {
+		return errors.Wrap(err, "unable to evaluate module inputs")
+	}
+
+	var out interface{} = schema
+	if c.XRD {
+		if c.Group == "" || c.Kind == "" {
+			return errors.New("--group and --kind are required for --xrd")
+		}
+		outputs, err := executor.OutputsSchema(c.Module, cmd)
+		if err != nil {
+			return errors.Wrap(err, "unable to evaluate module outputs")
+		}
+		out = c.makeXRD(schema, outputs)
+	}
+
+	if c.Output == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	y, err := yaml.Marshal(out)
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(y)
+	return err
+}
+
+// makeXRD makes a CompositeResourceDefinition with the spec of xModule, where inputs are typed by the module.
+// The status is written by the function, so only outputs of the module are typed.
+func (c *SchemaCmd) makeXRD(inputs, outputs map[string]interface{}) map[string]interface{} {
+	plural := c.Plural
+	if plural == "" {
+		plural = strings.ToLower(c.Kind) + "s"
+	}
+
+	specProperties := map[string]interface{}{
+		"inputs": inputs,
+	}
+	required := make([]string, 0)
+	if r, ok := inputs["required"].([]string); ok && len(r) > 0 {
+		required = append(required, "inputs")
+	}
+	for _, field := range []struct{ name, value string }{
+		{"repository", c.Repository},
+		{"revision", c.Revision},
+		{"path", c.Path},
+	} {
+		property := map[string]interface{}{"type": "string"}
+		if field.value != "" {
+			property["default"] = field.value
+		} else {
+			required = append(required, field.name)
+		}
+		specProperties[field.name] = property
+	}
+	repoServer := map[string]interface{}{"type": "string"}
+	if c.RepoServer != "" {
+		repoServer["default"] = c.RepoServer
+	}
+	specProperties["repoServer"] = repoServer
+	specProperties["limits"] = executor.LimitsSchema
+	spec := map[string]interface{}{
+		"type":       "object",
+		"properties": specProperties,
+	}
+	if len(required) > 0 {
+		spec["required"] = required
+	}
+
+	xrdSpec := map[string]interface{}{
+		"group": c.Group,
+		"names": map[string]interface{}{
+			"kind":   c.Kind,
+			"plural": plural,
+		},
+		"versions": []interface{}{
+			map[string]interface{}{
+				"name":          c.Version,
+				"served":        true,
+				"referenceable": true,
+				"schema": map[string]interface{}{
+					"openAPIV3Schema": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"spec": spec,
+							"status": map[string]interface{}{
+								"type":                                 "object",
+								"x-kubernetes-preserve-unknown-fields": true,
+								"properties": map[string]interface{}{
+									"outputs": outputs,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if c.ClaimKind != "" {
+		claimPlural := c.ClaimPlural
+		if claimPlural == "" {
+			claimPlural = strings.ToLower(c.ClaimKind) + "s"
+		}
+		xrdSpec["claimNames"] = map[string]interface{}{
+			"kind":   c.ClaimKind,
+			"plural": claimPlural,
+		}
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "apiextensions.crossplane.io/v1",
+		"kind":       "CompositeResourceDefinition",
+		"metadata": map[string]interface{}{
+			"name": fmt.Sprintf("%s.%s", plural, c.Group),
+		},
+		"spec": xrdSpec,
+	}
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"sigs.k8s.io/yaml"
+	"testing"
+)
+
+func TestSchemaCmd(t *testing.T) {
+	out := captureStdout(t)
+	c := &SchemaCmd{Module: "../executor/testdata/cue/inputs/src", Output: "yaml"}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := os.ReadFile("../executor/testdata/cue/inputs/schema.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want, got interface{}
+	if err := yaml.Unmarshal(expected, &want); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected inputs schema:\n%s", out.String())
+	}
+
+	c.XRD = true
+	if err := c.Run(); err == nil {
+		t.Fatal("--xrd without --group and --kind is accepted")
+	}
+
+	out.Reset()
+	c.Output = "json"
+	c.Group = "example.org"
+	c.Kind = "XNetwork"
+	c.ClaimKind = "Network"
+	c.Repository = "https://github.com/example/modules.git"
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	var xrd struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			ClaimNames map[string]string `json:"claimNames"`
+			Versions   []struct {
+				Schema struct {
+					OpenAPIV3Schema struct {
+						Properties struct {
+							Spec struct {
+								Required   []string                          `json:"required"`
+								Properties map[string]map[string]interface{} `json:"properties"`
+							} `json:"spec"`
+						} `json:"properties"`
+					} `json:"openAPIV3Schema"`
+				} `json:"schema"`
+			} `json:"versions"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &xrd); err != nil {
+		t.Fatal(err)
+	}
+	if xrd.Metadata.Name != "xnetworks.example.org" || xrd.Spec.ClaimNames["plural"] != "networks" {
+		t.Fatalf("unexpected names of the XRD:\n%s", out.String())
+	}
+	spec := xrd.Spec.Versions[0].Schema.OpenAPIV3Schema.Properties.Spec
+	if !reflect.DeepEqual(spec.Required, []string{"inputs", "revision", "path"}) {
+		t.Fatalf("unexpected required fields %v", spec.Required)
+	}
+	if spec.Properties["repository"]["default"] != c.Repository || spec.Properties["inputs"]["properties"] == nil {
+		t.Fatalf("unexpected spec properties %v", spec.Properties)
+	}
+}
@@ -13,21 +13,77 @@ import (
 	"github.com/crossplane/function-sdk-go/response"
 	"github.com/rs/zerolog"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
 	"sigs.k8s.io/yaml"
 	"strings"
+	"sync"
+	"time"
 )
 
+// graphTTL evicts graphs of XRs which have not been evaluated for a while, like deleted ones. Crossplane
+// runs functions of every XR at least once a minute.
+const graphTTL = time.Hour
+
+type graphEntry struct {
+	graph     *executor.Graph
+	evaluated time.Time
+}
+
 type Function struct {
 	fnv1beta1.UnimplementedFunctionRunnerServiceServer
 	log         zerolog.Logger
 	repoManager *RepoManager.RepoManager
+	execute     func(cmd *executor.ExecCommand) (*executor.ExecResult, error)
+	graphsMu    sync.RWMutex
+	// graphs keeps graphs of the last evaluations by XR uids
+	graphs map[string]*graphEntry
 }
 
 func NewFunction(repoManager *RepoManager.RepoManager) *Function {
 	return &Function{
 		log:         logger.GetLogger("crossplane").With().Logger(),
 		repoManager: repoManager,
+		execute:     repoManager.Execute,
+		graphs:      make(map[string]*graphEntry),
+	}
+}
+
+// NewLocalFunction returns a Function that executes the module located in the given directory
+// instead of the repository checkout referenced by the XR.
+func NewLocalFunction(path string) *Function {
+	return &Function{
+		log: logger.GetLogger("crossplane").With().Logger(),
+		execute: func(cmd *executor.ExecCommand) (*executor.ExecResult, error) {
+			cmd.Path = "."
+			return executor.Execute(path, cmd)
+		},
+		graphs: make(map[string]*graphEntry),
+	}
+}
+
+// Graph returns the dependency graph of the last evaluation of a module, modules are identified by the XR uid.
+func (f *Function) Graph(uid string) (*executor.Graph, bool) {
+	f.graphsMu.RLock()
+	defer f.graphsMu.RUnlock()
+	e, ok := f.graphs[uid]
+	if !ok || time.Since(e.evaluated) > graphTTL {
+		return nil, false
 	}
+	return e.graph, true
+}
+
+// setGraph keeps the graph of the XR and evicts expired graphs.
+func (f *Function) setGraph(uid string, g *executor.Graph) {
+	f.graphsMu.Lock()
+	defer f.graphsMu.Unlock()
+	now := time.Now()
+	for k, e := range f.graphs {
+		if now.Sub(e.evaluated) > graphTTL {
+			delete(f.graphs, k)
+		}
+	}
+	f.graphs[uid] = &graphEntry{graph: g, evaluated: now}
 }
 
 func (f *Function) Run() error {
@@ -40,10 +96,6 @@ func (f *Function) Run() error {
 }
 
 func (f *Function) RunFunction(_ context.Context, req *fnv1beta1.RunFunctionRequest) (*fnv1beta1.RunFunctionResponse, error) {
-	jsonString := protojson.Format(req)
-	y, _ := yaml.JSONToYAML([]byte(jsonString))
-	f.log.Debug().Str("request", string(y)).Msg("Received crossplane grpc request")
-
 	rsp := response.To(req, response.DefaultTTL)
 
 	_, err := request.GetDesiredComposedResources(req)
@@ -82,7 +134,7 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1beta1.RunFunctionRequ
 	}
 
 	spec := xr.Resource.Object["spec"].(map[string]interface{})
-	result, err := f.repoManager.Execute(&executor.ExecCommand{
+	result, err := f.execute(&executor.ExecCommand{
 		RepositoryUrl:      spec["repository"].(string),
 		RepositoryRevision: spec["revision"].(string),
 		Path:               spec["path"].(string),
@@ -92,6 +144,17 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1beta1.RunFunctionRequ
 		XR:                 xr,
 		Context:            string(ctxJson),
 	})
+	// the request is logged after the evaluation, inputs are redacted by declarations of the module
+	declared := result
+	if err != nil {
+		declared = nil
+	}
+	jsonString := protojson.Format(redactRequest(req, declared))
+	y, _ := yaml.JSONToYAML([]byte(jsonString))
+	f.log.Debug().Str("request", string(y)).Msg("Received crossplane grpc request")
+	if err == nil && result.Graph != nil {
+		f.setGraph(string(xr.Resource.GetUID()), result.Graph)
+	}
 	fatal := false
 	criticalError := ""
 	if err != nil || len(result.InputsErrors) > 0 || len(result.RequestErrors) > 0 || result.InputsValidationError != nil {
@@ -207,7 +270,8 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1beta1.RunFunctionRequ
 		status = make(map[string]interface{})
 		xr.Resource.Object["status"] = status
 	}
-	status["hasErrors"] = len(result.DesiredErrors) > 0 || len(result.OutputsErrors) > 0 || fatal || len(result.Deferred) > 0
+	status["hasErrors"] = len(result.DesiredErrors) > 0 || len(result.OutputsErrors) > 0 || len(result.ModulesErrors) > 0 ||
+		fatal || len(result.Deferred) > 0
 	report := newReport(result, criticalError)
 	status["report"], err = report.Map()
 	if err != nil {
@@ -216,20 +280,44 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1beta1.RunFunctionRequ
 		return rsp, nil
 	}
 
-	repo, err := f.repoManager.GetRepo(spec["repository"].(string), spec["revision"].(string))
-	if err == nil {
-		repository, ok := status["repository"]
-		if !ok {
-			repository = make(map[string]interface{})
-			status["repository"] = repository
+	if f.repoManager != nil {
+		repo, err := f.repoManager.GetRepo(spec["repository"].(string), spec["revision"].(string))
+		if err == nil {
+			repository, ok := status["repository"]
+			if !ok {
+				repository = make(map[string]interface{})
+				status["repository"] = repository
+			}
+			rr := repository.(map[string]interface{})
+			rr["message"] = repo.Status.Message
+			rr["commitSha"] = repo.Status.CommitSha
+			rr["ok"] = repo.Status.IsInitialized && repo.Status.IsUpdateSuccess
+			if repo.Status.ResolvedTag != "" {
+				rr["resolvedTag"] = repo.Status.ResolvedTag
+			} else {
+				delete(rr, "resolvedTag")
+			}
+			if sha := f.repoManager.FrozenSha(spec["repository"].(string), spec["revision"].(string), xr.Resource.GetName()); sha != "" {
+				rr["frozenSha"] = sha
+			} else {
+				delete(rr, "frozenSha")
+			}
 		}
-		rr := repository.(map[string]interface{})
-		rr["message"] = repo.Status.Message
-		rr["commitSha"] = repo.Status.CommitSha
-		rr["ok"] = repo.Status.IsInitialized && repo.Status.IsUpdateSuccess
 	}
 	if !fatal {
 		status["outputs"] = result.Outputs
+		if result.OutputsSchema != nil {
+			status["outputsSchema"] = result.OutputsSchema
+		}
+		// connection details desired by previous functions of the pipeline are kept
+		details := make(resource.ConnectionDetails)
+		for k, v := range req.GetDesired().GetComposite().GetConnectionDetails() {
+			details[k] = v
+		}
+		for k, v := range result.ConnectionDetails {
+			details[k] = []byte(v)
+		}
+		xr.ConnectionDetails = details
 	}
 
 	err = response.SetDesiredCompositeResource(rsp, xr)
@@ -259,3 +347,52 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1beta1.RunFunctionRequ
 
 	return rsp, nil
 }
+
+// redactRequest returns a copy of the request without values of connection details and of requested Secrets
+// for logging, they are secrets of observed resources, sensitive outputs of the XR and secrets of inputs.
+// Values of inputs in the XR spec are redacted too, unless the result of the evaluation declares them as inputs
+// which are not sensitive; without a result every input is redacted.
+func redactRequest(req *fnv1beta1.RunFunctionRequest, result *executor.ExecResult) *fnv1beta1.RunFunctionRequest {
+	res := proto.Clone(req).(*fnv1beta1.RunFunctionRequest)
+	redact := func(r *fnv1beta1.Resource) {
+		for k := range r.GetConnectionDetails() {
+			r.ConnectionDetails[k] = []byte("REDACTED")
+		}
+	}
+	sensitive := make(map[string]bool)
+	if result != nil {
+		for _, id := range result.SensitiveInputs {
+			sensitive[id] = true
+		}
+	}
+	redactInputs := func(r *fnv1beta1.Resource) {
+		spec := r.GetResource().GetFields()["spec"].GetStructValue()
+		inputs := spec.GetFields()["inputs"].GetStructValue().GetFields()
+		for k := range inputs {
+			if result != nil && result.Inputs[k] != "" && result.InputsErrors[k] == nil && !sensitive[k] {
+				continue
+			}
+			inputs[k] = structpb.NewStringValue("REDACTED")
+		}
+	}
+	redactInputs(res.GetObserved().GetComposite())
+	redactInputs(res.GetDesired().GetComposite())
+	redact(res.GetObserved().GetComposite())
+	for _, r := range res.GetObserved().GetResources() {
+		redact(r)
+	}
+	redact(res.GetDesired().GetComposite())
+	for _, r := range res.GetDesired().GetResources() {
+		redact(r)
+	}
+	for _, extras := range res.GetExtraResources() {
+		for _, r := range extras.GetItems() {
+			fields := r.GetResource().GetFields()
+			if fields["kind"].GetStringValue() == "Secret" {
+				delete(fields, "data")
+				delete(fields, "stringData")
+			}
+		}
+	}
+	return res
+}
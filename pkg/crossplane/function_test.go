@@ -0,0 +1,149 @@
+package crossplane
+
+import (
+	"bytes"
+	"context"
+	"crossform.io/pkg/logger"
+	fnv1beta1 "github.com/crossplane/function-sdk-go/proto/v1beta1"
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/types/known/structpb"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const redactModule = `apiVersion: crossform.io/v1alpha1
+kind: Input
+metadata:
+  name: password
+  annotations:
+    crossform.io/sensitive: "true"
+spec:
+  type: string
+---
+apiVersion: crossform.io/v1alpha1
+kind: Input
+metadata:
+  name: user
+spec:
+  type: string
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+  annotations:
+    crossform.io/id: credentials
+stringData:
+  user: ${inputs.user}
+  password: ${inputs.password}
+`
+
+// moduleRequest writes the module to a directory and returns the request of an XR setting its inputs.
+func moduleRequest(t *testing.T, module string, inputs map[string]interface{}) (string, *fnv1beta1.RunFunctionRequest) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.yaml"), []byte(module), 0644); err != nil {
+		t.Fatal(err)
+	}
+	xr, err := structpb.NewStruct(map[string]interface{}{
+		"apiVersion": "crossform.io/v1alpha1",
+		"kind":       "xModule",
+		"metadata":   map[string]interface{}{"name": "redact", "uid": "5f0c8d2e-0001"},
+		"spec": map[string]interface{}{
+			"repository": "https://example.com/modules.git",
+			"revision":   "main",
+			"path":       ".",
+			"inputs":     inputs,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, &fnv1beta1.RunFunctionRequest{
+		Observed: &fnv1beta1.State{Composite: &fnv1beta1.Resource{Resource: xr}},
+	}
+}
+
+func TestRunFunctionRedactsInputs(t *testing.T) {
+	var out bytes.Buffer
+	logger.InitLogWriter(&out)
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	t.Cleanup(func() { zerolog.SetGlobalLevel(zerolog.InfoLevel) })
+
+	dir, req := moduleRequest(t, redactModule, map[string]interface{}{"password": "s3cret-password", "user": "visible-user"})
+	rsp, err := NewLocalFunction(dir).RunFunction(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := rsp.GetDesired().GetResources()["credentials"].GetResource().AsMap()
+	if data, _ := secret["stringData"].(map[string]interface{}); data["password"] != "s3cret-password" {
+		t.Fatalf("the sensitive input is not evaluated: %v", secret)
+	}
+
+	log := out.String()
+	if !strings.Contains(log, "Received crossplane grpc request") || !strings.Contains(log, "desired resource OK") {
+		t.Fatalf("debug logs are missing:\n%s", log)
+	}
+	if strings.Contains(log, "s3cret-password") {
+		t.Fatalf("the value of the sensitive input is logged:\n%s", log)
+	}
+	if !strings.Contains(log, "visible-user") {
+		t.Fatalf("the value of the input which is not sensitive is redacted:\n%s", log)
+	}
+}
+
+func TestRunFunctionKeepsConnectionDetails(t *testing.T) {
+	logger.InitLog()
+	dir, req := moduleRequest(t, redactModule, map[string]interface{}{"password": "s3cret-password", "user": "admin"})
+	req.Desired = &fnv1beta1.State{Composite: &fnv1beta1.Resource{
+		ConnectionDetails: map[string][]byte{"endpoint": []byte("db.example.com"), "password": []byte("previous")},
+	}}
+
+	rsp, err := NewLocalFunction(dir).RunFunction(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	details := rsp.GetDesired().GetComposite().GetConnectionDetails()
+	if string(details["endpoint"]) != "db.example.com" {
+		t.Fatalf("connection details of previous functions are dropped: %v", details)
+	}
+	if string(details["password"]) != "s3cret-password" {
+		t.Fatalf("the sensitive input is not published: %v", details)
+	}
+}
+
+func TestRunFunctionGraphs(t *testing.T) {
+	logger.InitLog()
+	dir, req := moduleRequest(t, redactModule, map[string]interface{}{"password": "s3cret-password", "user": "admin"})
+	f := NewLocalFunction(dir)
+	rsp, err := f.RunFunction(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// XRs of the same name in other namespaces have their own graphs
+	metadata := req.GetObserved().GetComposite().GetResource().GetFields()["metadata"].GetStructValue()
+	metadata.GetFields()["uid"] = structpb.NewStringValue("5f0c8d2e-0002")
+	if _, err := f.RunFunction(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	for _, uid := range []string{"5f0c8d2e-0001", "5f0c8d2e-0002"} {
+		if g, ok := f.Graph(uid); !ok || g.Module != "redact" {
+			t.Fatalf("the graph of %s is missing", uid)
+		}
+	}
+	report := rsp.GetDesired().GetComposite().GetResource().AsMap()["status"].(map[string]interface{})["report"].(map[string]interface{})
+	if _, ok := report["graph"]; ok {
+		t.Fatal("the graph is written into the report")
+	}
+
+	f.graphs["5f0c8d2e-0001"].evaluated = time.Now().Add(-graphTTL * 2)
+	if _, ok := f.Graph("5f0c8d2e-0001"); ok {
+		t.Fatal("an expired graph is served")
+	}
+	f.setGraph("5f0c8d2e-0003", nil)
+	if _, ok := f.graphs["5f0c8d2e-0001"]; ok {
+		t.Fatal("an expired graph is not evicted")
+	}
+}
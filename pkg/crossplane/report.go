@@ -8,10 +8,11 @@ import (
 )
 
 type reportItem struct {
-	typ      string
-	id       string
-	deferred bool
-	Error    error
+	typ        string
+	id         string
+	deferred   bool
+	waitingFor []string
+	Error      error
 }
 
 func newReportItem(typ, id string, err error, deferred bool) *reportItem {
@@ -32,6 +33,9 @@ func (i *reportItem) Status() string {
 		return fmt.Sprintf("ERROR:\n%s", i.Error)
 	}
 	if i.deferred {
+		if len(i.waitingFor) > 0 {
+			return fmt.Sprintf("DEFERRED: waiting for %s", strings.Join(i.waitingFor, ", "))
+		}
 		return "DEFERRED"
 	}
 	return "OK"
@@ -42,6 +46,8 @@ type report struct {
 	Requests         map[string]string `json:"requests,omitempty" structs:"requests,omitempty"`
 	Outputs          map[string]string `json:"outputs,omitempty" structs:"outputs,omitempty"`
 	Inputs           map[string]string `json:"inputs,omitempty" structs:"inputs,omitempty"`
+	Modules          map[string]string `json:"modules,omitempty" structs:"modules,omitempty"`
+	Imports          map[string]string `json:"imports,omitempty" structs:"imports,omitempty"`
 	InputsValidation string            `json:"inputsValidation,omitempty" structs:"inputsValidation,omitempty"`
 	CriticalError    string            `json:"criticalError,omitempty" structs:"criticalError,omitempty"`
 	items            []*reportItem
@@ -69,6 +75,7 @@ func newReport(result *executor.ExecResult, criticalError string) *report {
 		Resources:        make(map[string]string),
 		Outputs:          make(map[string]string),
 		Inputs:           make(map[string]string),
+		Modules:          make(map[string]string),
 		InputsValidation: "OK",
 		items:            make([]*reportItem, 0),
 	}
@@ -87,6 +94,7 @@ func newReport(result *executor.ExecResult, criticalError string) *report {
 	}
 	for _, k := range result.Deferred {
 		i := newReportItem("Resource", k, nil, true)
+		i.waitingFor = result.DeferredBy[k]
 		r.items = append(r.items, i)
 		r.Resources[k] = i.Status()
 	}
@@ -105,6 +113,11 @@ func newReport(result *executor.ExecResult, criticalError string) *report {
 		r.items = append(r.items, i)
 		r.Outputs[k] = i.Status()
 	}
+	for _, k := range result.SensitiveOutputs {
+		i := newReportItem("Output", k, nil, false)
+		r.items = append(r.items, i)
+		r.Outputs[k] = i.Status()
+	}
 	for k, v := range result.OutputsErrors {
 		i := newReportItem("Output", k, v, false)
 		r.items = append(r.items, i)
@@ -120,6 +133,18 @@ func newReport(result *executor.ExecResult, criticalError string) *report {
 		r.items = append(r.items, i)
 		r.Inputs[k] = i.Status()
 	}
+	for k, v := range result.InputsDeferredBy {
+		i := newReportItem("Input", k, nil, true)
+		i.waitingFor = []string{v}
+		r.items = append(r.items, i)
+		r.Inputs[k] = i.Status()
+	}
+	for k, v := range result.ModulesErrors {
+		i := newReportItem("Module", k, v, false)
+		r.items = append(r.items, i)
+		r.Modules[k] = i.Status()
+	}
+	r.Imports = result.Imports
 	r.CriticalError = criticalError
 	return r
 }
@@ -0,0 +1,284 @@
+package diff
+
+import (
+	"crossform.io/pkg/executor"
+	"encoding/json"
+	"fmt"
+	"github.com/crossplane/function-sdk-go/resource"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Action string
+
+const (
+	Added   Action = "added"
+	Removed Action = "removed"
+	Changed Action = "changed"
+)
+
+func (a Action) symbol() string {
+	switch a {
+	case Added:
+		return "+"
+	case Removed:
+		return "-"
+	default:
+		return "~"
+	}
+}
+
+// FieldChange is a change of a single value addressed by its path inside a resource or output.
+type FieldChange struct {
+	Path   string      `json:"path"`
+	Action Action      `json:"action"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+type ResourceDiff struct {
+	Id      string         `json:"id"`
+	Action  Action         `json:"action"`
+	Changes []*FieldChange `json:"changes,omitempty"`
+}
+
+type OutputDiff struct {
+	Id     string      `json:"id"`
+	Action Action      `json:"action"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Result describes how the desired state of a module changes between two evaluations.
+type Result struct {
+	BaseRevision string            `json:"baseRevision,omitempty"`
+	HeadRevision string            `json:"headRevision,omitempty"`
+	Resources    []*ResourceDiff   `json:"resources"`
+	Outputs      []*OutputDiff     `json:"outputs"`
+	BaseErrors   map[string]string `json:"baseErrors,omitempty"`
+	HeadErrors   map[string]string `json:"headErrors,omitempty"`
+}
+
+// Compare evaluates the difference between the desired resources and outputs of two execution results.
+func Compare(base, head *executor.ExecResult) *Result {
+	r := &Result{
+		Resources:  make([]*ResourceDiff, 0),
+		Outputs:    make([]*OutputDiff, 0),
+		BaseErrors: collectErrors(base),
+		HeadErrors: collectErrors(head),
+	}
+
+	for _, id := range unionKeys(desiredIds(base), desiredIds(head)) {
+		b, inBase := base.Desired[resource.Name(id)]
+		h, inHead := head.Desired[resource.Name(id)]
+		switch {
+		case !inBase:
+			r.Resources = append(r.Resources, &ResourceDiff{Id: id, Action: Added})
+		case !inHead:
+			r.Resources = append(r.Resources, &ResourceDiff{Id: id, Action: Removed})
+		default:
+			changes := compareValues("", b.Resource.Object, h.Resource.Object)
+			if b.Ready != h.Ready {
+				changes = append(changes, &FieldChange{Path: "(ready)", Action: Changed, Before: b.Ready, After: h.Ready})
+			}
+			if len(changes) > 0 {
+				r.Resources = append(r.Resources, &ResourceDiff{Id: id, Action: Changed, Changes: changes})
+			}
+		}
+	}
+
+	for _, id := range unionKeys(base.Outputs, head.Outputs) {
+		b, inBase := base.Outputs[id]
+		h, inHead := head.Outputs[id]
+		switch {
+		case !inBase:
+			r.Outputs = append(r.Outputs, &OutputDiff{Id: id, Action: Added, After: h})
+		case !inHead:
+			r.Outputs = append(r.Outputs, &OutputDiff{Id: id, Action: Removed, Before: b})
+		case !reflect.DeepEqual(normalize(b), normalize(h)):
+			r.Outputs = append(r.Outputs, &OutputDiff{Id: id, Action: Changed, Before: b, After: h})
+		}
+	}
+	return r
+}
+
+// HasChanges reports whether any resource or output differs.
+func (r *Result) HasChanges() bool {
+	return len(r.Resources) > 0 || len(r.Outputs) > 0
+}
+
+func (r *Result) String() string {
+	b := strings.Builder{}
+	if r.BaseRevision != "" || r.HeadRevision != "" {
+		b.WriteString(fmt.Sprintf("base: %s\nhead: %s\n\n", orDefault(r.BaseRevision), orDefault(r.HeadRevision)))
+	}
+	if !r.HasChanges() {
+		b.WriteString("No changes in desired resources and outputs\n")
+	}
+	for _, v := range r.Resources {
+		b.WriteString(fmt.Sprintf("%s resource %s\n", v.Action.symbol(), v.Id))
+		for _, c := range v.Changes {
+			switch c.Action {
+			case Added:
+				b.WriteString(fmt.Sprintf("    + %s: %s\n", c.Path, format(c.After)))
+			case Removed:
+				b.WriteString(fmt.Sprintf("    - %s: %s\n", c.Path, format(c.Before)))
+			default:
+				b.WriteString(fmt.Sprintf("    ~ %s: %s => %s\n", c.Path, format(c.Before), format(c.After)))
+			}
+		}
+	}
+	for _, v := range r.Outputs {
+		switch v.Action {
+		case Added:
+			b.WriteString(fmt.Sprintf("+ output %s: %s\n", v.Id, format(v.After)))
+		case Removed:
+			b.WriteString(fmt.Sprintf("- output %s: %s\n", v.Id, format(v.Before)))
+		default:
+			b.WriteString(fmt.Sprintf("~ output %s: %s => %s\n", v.Id, format(v.Before), format(v.After)))
+		}
+	}
+	writeErrors := func(title string, errs map[string]string) {
+		if len(errs) == 0 {
+			return
+		}
+		b.WriteString(fmt.Sprintf("\n%s:\n", title))
+		for _, k := range sortedKeys(errs) {
+			b.WriteString(fmt.Sprintf("  %s: %s\n", k, errs[k]))
+		}
+	}
+	writeErrors("Base errors", r.BaseErrors)
+	writeErrors("Head errors", r.HeadErrors)
+	return b.String()
+}
+
+func orDefault(revision string) string {
+	if revision == "" {
+		return "(working tree)"
+	}
+	return revision
+}
+
+func collectErrors(r *executor.ExecResult) map[string]string {
+	errs := make(map[string]string)
+	for k, v := range r.DesiredErrors {
+		errs["resource "+k] = v.Error()
+	}
+	for k, v := range r.OutputsErrors {
+		errs["output "+k] = v.Error()
+	}
+	for k, v := range r.RequestErrors {
+		errs["request "+k] = v.Error()
+	}
+	for k, v := range r.InputsErrors {
+		errs["input "+k] = v.Error()
+	}
+	if r.InputsValidationError != nil {
+		errs["inputs validation"] = r.InputsValidationError.Error()
+	}
+	return errs
+}
+
+func desiredIds(r *executor.ExecResult) map[string]bool {
+	ids := make(map[string]bool)
+	for k := range r.Desired {
+		ids[string(k)] = true
+	}
+	return ids
+}
+
+func compareValues(path string, before, after interface{}) []*FieldChange {
+	changes := make([]*FieldChange, 0)
+	b, bIsMap := before.(map[string]interface{})
+	a, aIsMap := after.(map[string]interface{})
+	if bIsMap && aIsMap {
+		for _, k := range unionKeys(b, a) {
+			bv, inBefore := b[k]
+			av, inAfter := a[k]
+			p := joinPath(path, k)
+			switch {
+			case !inBefore:
+				changes = append(changes, &FieldChange{Path: p, Action: Added, After: av})
+			case !inAfter:
+				changes = append(changes, &FieldChange{Path: p, Action: Removed, Before: bv})
+			default:
+				changes = append(changes, compareValues(p, bv, av)...)
+			}
+		}
+		return changes
+	}
+	bl, bIsList := before.([]interface{})
+	al, aIsList := after.([]interface{})
+	if bIsList && aIsList {
+		for i := 0; i < len(bl) || i < len(al); i++ {
+			p := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(bl):
+				changes = append(changes, &FieldChange{Path: p, Action: Added, After: al[i]})
+			case i >= len(al):
+				changes = append(changes, &FieldChange{Path: p, Action: Removed, Before: bl[i]})
+			default:
+				changes = append(changes, compareValues(p, bl[i], al[i])...)
+			}
+		}
+		return changes
+	}
+	if !reflect.DeepEqual(normalize(before), normalize(after)) {
+		changes = append(changes, &FieldChange{Path: path, Action: Changed, Before: before, After: after})
+	}
+	return changes
+}
+
+func joinPath(path, key string) string {
+	if strings.ContainsAny(key, ".[]") {
+		key = "[" + strconv.Quote(key) + "]"
+		return path + key
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// normalize makes values decoded by different serializers comparable, e.g. int64 and float64 numbers.
+func normalize(v interface{}) interface{} {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var res interface{}
+	if err := json.Unmarshal(j, &res); err != nil {
+		return v
+	}
+	return res
+}
+
+func format(v interface{}) string {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(j)
+}
+
+func unionKeys[V1 any, V2 any](a map[string]V1, b map[string]V2) []string {
+	keys := make(map[string]bool)
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	return sortedKeys(keys)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,52 @@
+package diff
+
+import (
+	"crossform.io/pkg/executor"
+	"errors"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composed"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"testing"
+)
+
+func desired(obj map[string]interface{}) *resource.DesiredComposed {
+	return &resource.DesiredComposed{Resource: &composed.Unstructured{Unstructured: unstructured.Unstructured{Object: obj}}}
+}
+
+func TestCompare(t *testing.T) {
+	base := executor.NewExecResult()
+	base.Desired["vpc"] = desired(map[string]interface{}{
+		"spec": map[string]interface{}{"cidr": "10.0.0.0/16", "tags": []interface{}{"a"}},
+	})
+	base.Desired["old"] = desired(map[string]interface{}{})
+	base.Outputs["vpcId"] = "vpc-1"
+	base.Outputs["removed"] = 1
+
+	head := executor.NewExecResult()
+	head.Desired["vpc"] = desired(map[string]interface{}{
+		"spec": map[string]interface{}{"cidr": "10.1.0.0/16", "tags": []interface{}{"a", "b"}},
+	})
+	head.Desired["new"] = desired(map[string]interface{}{})
+	head.Outputs["vpcId"] = "vpc-2"
+	head.DesiredErrors["broken"] = errors.New("field does not exist")
+
+	res := Compare(base, head)
+	expected := `+ resource new
+- resource old
+~ resource vpc
+    ~ spec.cidr: "10.0.0.0/16" => "10.1.0.0/16"
+    + spec.tags[1]: "b"
+- output removed: 1
+~ output vpcId: "vpc-1" => "vpc-2"
+
+Head errors:
+  resource broken: field does not exist
+`
+	if res.String() != expected {
+		t.Fatalf("unexpected diff:\n%s", res.String())
+	}
+
+	if Compare(base, base).HasChanges() {
+		t.Fatalf("identical results should have no changes")
+	}
+}
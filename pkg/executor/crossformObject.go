@@ -2,23 +2,28 @@ package executor
 
 import "github.com/guregu/null/v5"
 
-type metadata struct {
+// Metadata identifies a field of a module, the type is resource, request, input or output.
+type Metadata struct {
 	Id   string `json:"id"`
 	Type string `json:"type"`
 }
 
-type request struct {
+// Request selects resources of the cluster by name or labels.
+type Request struct {
 	ApiVersion string            `json:"apiVersion"`
 	Kind       string            `json:"kind"`
 	Name       string            `json:"name,omitempty"`
 	Labels     map[string]string `json:"labels,omitempty"`
 }
 
-type crossform struct {
-	Metadata metadata               `json:"metadata"`
+// Crossform is the crossform object of a field, it declares the field and carries its value for the function.
+type Crossform struct {
+	Metadata Metadata               `json:"metadata"`
 	Ready    null.Bool              `json:"ready,omitempty"`
-	Request  *request               `json:"request,omitempty"`
+	Request  *Request               `json:"request,omitempty"`
 	Output   interface{}            `json:"output,omitempty"`
 	Schema   map[string]interface{} `json:"schema,omitempty"`
 	Deferred bool                   `json:"deferred,omitempty"`
+	// Sensitive values of outputs and inputs are published as connection details of the XR only
+	Sensitive bool `json:"sensitive,omitempty"`
 }
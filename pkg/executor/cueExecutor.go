@@ -3,30 +3,46 @@ package executor
 import (
 	"crossform.io/pkg/logger"
 	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
 	"cuelang.org/go/cue/cuecontext"
 	cueErrors "cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/literal"
 	"cuelang.org/go/cue/load"
+	_ "embed"
 	"encoding/json"
 	"fmt"
 	"github.com/crossplane/function-sdk-go/resource"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"golang.org/x/exp/maps"
-	"os"
 	"path/filepath"
 	"strings"
 )
 
+// cueLib is the crossform library of CUE modules, it is prepended to every file of a module.
+//
+//go:embed lib.cue
+var cueLib string
+
+func init() {
+	Register(&engine{
+		name:   "cue",
+		detect: detectByGlob("*.cue"),
+		new:    newCueExecutor,
+	})
+}
+
 type cueExecutor struct {
 	ctx       *cue.Context
 	instances map[string]*cue.Value
 	path      string
 	log       zerolog.Logger
-	metadata  map[string]*metadata
+	metadata  map[string]*Metadata
 	fields    map[string][]string
+	syntax    []*ast.File
 }
 
-func newCueExecutor(path, observed, requested, xr, context string) (genericExecutor, error) {
+func newCueExecutor(path, observed, requested, xr, context string, env *Environment) (ModuleExecutor, error) {
 	e := &cueExecutor{
 		ctx:  cuecontext.New(),
 		path: path,
@@ -34,18 +50,20 @@ func newCueExecutor(path, observed, requested, xr, context string) (genericExecu
 			Str("directory", path).
 			Logger(),
 		instances: make(map[string]*cue.Value),
-		metadata:  make(map[string]*metadata),
+		metadata:  make(map[string]*Metadata),
 		fields:    make(map[string][]string),
 	}
+	registry, err := cueRegistry()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid CUE registry")
+	}
+	// the module root is the nearest cue.mod of the checkout, so modules import packages of other directories
+	// of the repository, vendored packages of cue.mod/pkg and dependencies of the registry
 	config := &load.Config{
-		//Stdin:      strings.NewReader(""),
 		Dir:        path,
-		ModuleRoot: path,
-		//Package:    "test",
-		//Overlay: map[string]load.Source{
-		//	"/home/user/GolandProjects/cue-test/crossform.cue": load.FromString(val),
-		//},
-		Tags: []string{},
+		ModuleRoot: findCueModuleRoot(path, env.root),
+		Registry:   registry,
+		Tags:       []string{},
 	}
 	files, err := filepath.Glob(e.path + "/*.cue")
 	if err != nil {
@@ -54,8 +72,9 @@ func newCueExecutor(path, observed, requested, xr, context string) (genericExecu
 	if len(files) == 0 {
 		return nil, nil
 	}
+	// files are loaded relative to the directory, Glob cleans paths like module/. of the command line
 	for i, v := range files {
-		files[i] = strings.TrimPrefix(v, e.path+"/")
+		files[i] = filepath.Base(v)
 	}
 	builds := load.Instances(files, config)
 	if len(builds) < 1 {
@@ -64,18 +83,16 @@ func newCueExecutor(path, observed, requested, xr, context string) (genericExecu
 		e.log.Error().Err(err).Msg("cannot load instances")
 		return nil, err
 	}
+	e.syntax = builds[0].Files
 
-	l, err := os.ReadFile("lib.cue")
-	if err != nil {
-		return nil, err
-	}
-	imports := `
+	// values follow lib.cue, so that imports of lib.cue stay on top of the file
+	values := fmt.Sprintf(`
 _observed:%s
 _requested:%s
 _xr:%s
 _context:%s
-` + string(l)
-	lib := fmt.Sprintf(imports, observed, requested, xr, context)
+`, observed, requested, xr, context)
+	lib := cueLib + values
 	//lib := fmt.Sprintf(imports, "{}", "{}", "{}", "{}")
 	if builds[0].PkgName != "" {
 		lib = fmt.Sprintf("package %s\n%s", builds[0].PkgName, lib)
@@ -85,26 +102,28 @@ _context:%s
 		return nil, err
 	}
 
-	for i := range files {
-		instance := e.ctx.BuildInstance(builds[i], cue.Scope(libValue))
-		if err := instance.Err(); err != nil {
-			msg := cueErrors.Details(instance.Err(), nil)
-			return nil, errors.WithMessage(err, msg)
-		}
-		e.instances[files[i]] = &instance
+	// files of the module are one package, so they are loaded as one instance shared by the files
+	instance := e.ctx.BuildInstance(builds[0], cue.Scope(libValue))
+	if err := instance.Err(); err != nil && !inFields(err) {
+		msg := cueErrors.Details(instance.Err(), nil)
+		return nil, errors.WithMessage(err, msg)
 	}
-
 	for _, f := range files {
-		fields, err := e.getFields(f)
-		if err != nil {
-			return nil, err
-		}
-		if _, exist := e.fields[f]; !exist {
-			e.fields[f] = make([]string, 0)
-		}
-		for _, field := range fields {
-			e.fields[f] = append(e.fields[f], field)
+		e.instances[f] = &instance
+		e.fields[f] = make([]string, 0)
+	}
+
+	fields, err := e.getFields(files[0])
+	if err != nil {
+		return nil, err
+	}
+	owners := e.fieldOwners()
+	for _, field := range fields {
+		owner, ok := owners[field]
+		if !ok {
+			owner = files[0]
 		}
+		e.fields[owner] = append(e.fields[owner], field)
 	}
 
 	for file, fields := range e.fields {
@@ -120,6 +139,46 @@ _context:%s
 	return e, nil
 }
 
+// inFields reports whether every error is in a regular top level field, like an invalid argument of #cidrSubnet.
+// Such errors are bottom values of their fields, they fail the fields only and the module is evaluated.
+func inFields(err error) bool {
+	for _, e := range cueErrors.Errors(err) {
+		path := e.Path()
+		if len(path) < 2 || strings.HasPrefix(path[0], "_") || strings.HasPrefix(path[0], "#") {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldOwners maps top level fields to the files declaring them, a field declared by several files belongs
+// to the first one. Fields not declared at the top level, e.g. by comprehensions, have no owner.
+func (e *cueExecutor) fieldOwners() map[string]string {
+	owners := make(map[string]string)
+	for _, f := range e.syntax {
+		file := filepath.Base(f.Filename)
+		if _, ok := e.instances[file]; !ok {
+			continue
+		}
+		for _, decl := range f.Decls {
+			field, ok := decl.(*ast.Field)
+			if !ok {
+				continue
+			}
+			name, _, err := ast.LabelName(field.Label)
+			if err != nil {
+				continue
+			}
+			// selectors are formatted like fields iterated by getFields
+			label := cue.Str(name).String()
+			if _, exist := owners[label]; !exist {
+				owners[label] = file
+			}
+		}
+	}
+	return owners
+}
+
 func (e *cueExecutor) getFieldPath(fileName, field string) string {
 	return fmt.Sprintf("%s/%s", fileName, field)
 }
@@ -160,6 +219,12 @@ func (e *cueExecutor) getFields(fileName string) ([]string, error) {
 }
 
 func (e *cueExecutor) getCrossformValue(val cue.Value) (*cue.Value, error) {
+	return e.getHiddenValue(val, "_crossform")
+}
+
+// getHiddenValue looks up a hidden field by its name, hidden fields of lib.cue definitions can not be
+// looked up by a path as they belong to the lib package.
+func (e *cueExecutor) getHiddenValue(val cue.Value, name string) (*cue.Value, error) {
 	var options = []cue.Option{
 		cue.Attributes(false),
 		cue.Concrete(false),
@@ -179,15 +244,15 @@ func (e *cueExecutor) getCrossformValue(val cue.Value) (*cue.Value, error) {
 		return nil, err
 	}
 	for iter.Next() {
-		if iter.Selector().String() == "_crossform" {
+		if iter.Selector().String() == name {
 			v := iter.Value()
 			return &v, nil
 		}
 	}
-	return nil, errors.New("unable to find _crossform field")
+	return nil, errors.Errorf("unable to find %s field", name)
 }
 
-func (e *cueExecutor) getMetadataObject(fileName, field string) (*metadata, error) {
+func (e *cueExecutor) getMetadataObject(fileName, field string) (*Metadata, error) {
 	tt := e.instances[fileName].LookupPath(cue.ParsePath(field))
 	cf, err := e.getCrossformValue(tt)
 	if err != nil {
@@ -204,7 +269,7 @@ func (e *cueExecutor) getMetadataObject(fileName, field string) (*metadata, erro
 		e.log.Error().Err(err).Str("field", field).Msg("unable to get crossform metadata")
 		return nil, errors.Wrapf(err, "unable to get crossform metadata. file=%s field=%s", fileName, field)
 	}
-	m := metadata{}
+	m := Metadata{}
 	err = json.Unmarshal(j, &m)
 	if err != nil {
 		e.log.Error().Err(err).Str("field", field).Str("json", string(j)).Msg("unable to unmarshal crossform metadata")
@@ -213,11 +278,11 @@ func (e *cueExecutor) getMetadataObject(fileName, field string) (*metadata, erro
 	return &m, nil
 }
 
-func (e *cueExecutor) GetMetadataObject(fileName, field string) *metadata {
+func (e *cueExecutor) GetMetadataObject(fileName, field string) *Metadata {
 	return e.metadata[e.getFieldPath(fileName, field)]
 }
 
-func (e *cueExecutor) GetCrossformObject(fileName, field string) (*crossform, error) {
+func (e *cueExecutor) GetCrossformObject(fileName, field string) (*Crossform, error) {
 	tt := e.instances[fileName].LookupPath(cue.ParsePath(field))
 	v, err := e.getCrossformValue(tt)
 	if err != nil {
@@ -229,19 +294,98 @@ func (e *cueExecutor) GetCrossformObject(fileName, field string) (*crossform, er
 		e.log.Warn().Err(err).Str("field", field).Msg("unable to get crossform object")
 		return nil, errors.Wrapf(err, "unable to unmarshal crossform object. file=%s field=%s", fileName, field)
 	}
-	cf := crossform{}
+	cf := Crossform{}
 	err = json.Unmarshal(j, &cf)
 	if err != nil {
 		e.log.Error().Err(err).Str("field", field).Msg("unable to unmarshal crossform object")
 		return nil, errors.Wrapf(err, "unable to unmarshal crossform object. file=%s field=%s", fileName, field)
 	}
+	if cf.Metadata.Type == "input" {
+		cf.Schema, err = e.getInputSchema(tt)
+		if err != nil {
+			e.log.Warn().Err(err).Str("field", field).Msg("unable to get input schema")
+			return nil, errors.Wrapf(err, "unable to get input schema. file=%s field=%s", fileName, field)
+		}
+	}
 	return &cf, nil
 }
 
-func (e *cueExecutor) ValidateInputs(_ map[string]*crossform, _ map[string]interface{}) error {
+func (e *cueExecutor) getInputSchema(input cue.Value) (map[string]interface{}, error) {
+	typ, err := e.getHiddenValue(input, "_type")
+	if err != nil {
+		return nil, err
+	}
+	schema := e.cueSchema(*typ)
+	description, err := e.getHiddenValue(input, "_description")
+	if err != nil {
+		return nil, err
+	}
+	if d, err := description.String(); err == nil && d != "" {
+		schema["description"] = d
+	}
+	return schema, nil
+}
+
+// GetOutputSchema converts the _type constraint of an #output into its schema, outputs of any type have none.
+func (e *cueExecutor) GetOutputSchema(fileName, field string) (map[string]interface{}, error) {
+	tt := e.instances[fileName].LookupPath(cue.ParsePath(field))
+	typ, err := e.getHiddenValue(tt, "_type")
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get output type. file=%s field=%s", fileName, field)
+	}
+	if typ.IncompleteKind() == cue.TopKind {
+		return nil, nil
+	}
+	return e.cueSchema(*typ), nil
+}
+
+// ValidateInputs validates inputs of the XR against the _type constraints of #input fields.
+// Inputs which are not provided have to be satisfied by a default.
+func (e *cueExecutor) ValidateInputs(_ map[string]*Crossform, input map[string]interface{}) error {
+	validationErr := &InputsValidationError{Fields: make(map[string]string)}
+	for file, fields := range e.fields {
+		for _, field := range fields {
+			m := e.GetMetadataObject(file, field)
+			if m == nil || m.Type != "input" {
+				continue
+			}
+			typ, err := e.getHiddenValue(e.instances[file].LookupPath(cue.ParsePath(field)), "_type")
+			if err != nil {
+				return errors.Wrapf(err, "unable to get input type. file=%s field=%s", file, field)
+			}
+			v, provided := input[m.Id]
+			if !provided {
+				if err := typ.Validate(cue.Concrete(true)); err != nil {
+					validationErr.Fields[m.Id] = "required input is not provided"
+				}
+				continue
+			}
+			if err := typ.Unify(e.ctx.Encode(v)).Validate(cue.Concrete(true)); err != nil {
+				validationErr.Fields[m.Id] = inputErrorMessage(err)
+			}
+		}
+	}
+	if len(validationErr.Fields) > 0 {
+		return validationErr
+	}
 	return nil
 }
 
+// inputErrorMessage strips cue paths from an error, they point to the lib instead of the input.
+// Headers of grouped errors, e.g. of an empty disjunction, are skipped.
+func inputErrorMessage(err error) string {
+	msgs := make([]string, 0)
+	for _, e := range cueErrors.Errors(err) {
+		format, args := e.Msg()
+		msg := fmt.Sprintf(format, args...)
+		if strings.HasSuffix(msg, ":") {
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	return strings.Join(msgs, "; ")
+}
+
 func (e *cueExecutor) GetResource(file, field string) (map[string]interface{}, bool, resource.Ready, error) {
 	crossform, err := e.GetCrossformObject(file, field)
 	if err != nil {
@@ -274,7 +418,108 @@ func (e *cueExecutor) GetResource(file, field string) (map[string]interface{}, b
 		}
 	}
 
-	e.log.Debug().Str("id", crossform.Metadata.Id).Str("json", string(j)).Msg("resource evaluating success")
+	e.log.Debug().Str("id", crossform.Metadata.Id).Msg("resource evaluating success")
 
 	return obj, false, ready, nil
 }
+
+// GetDependencies detects dependencies statically: references to the status of other resources
+// (e.g. resource1.status.atProvider.arn), to outputs of child modules (e.g. network.#outputs.vpcId),
+// to results of requests and to values of inputs, and direct reads of _observed["id"], _requested["id"] and _xr.spec.inputs["name"].
+func (e *cueExecutor) GetDependencies(fileName, field string) ([]*Dependency, error) {
+	m := e.GetMetadataObject(fileName, field)
+	declared := make(map[string]*Metadata)
+	for f, fields := range e.fields {
+		for _, name := range fields {
+			if meta := e.GetMetadataObject(f, name); meta != nil {
+				declared[name] = meta
+			}
+		}
+	}
+
+	found := make(map[Dependency]bool)
+	for _, file := range e.syntax {
+		for _, decl := range file.Decls {
+			f, ok := decl.(*ast.Field)
+			if !ok {
+				continue
+			}
+			name, _, err := ast.LabelName(f.Label)
+			if err != nil || name != field {
+				continue
+			}
+			ast.Walk(f.Value, func(node ast.Node) bool {
+				root, path := referencePath(node)
+				if root == "" {
+					return true
+				}
+				switch {
+				case (root == "_observed" || root == "_requested") && len(path) > 0:
+					source := dependencySourceObserved
+					if root == "_requested" {
+						source = dependencySourceRequested
+					}
+					found[Dependency{Source: source, Id: path[0]}] = true
+				case root == "_xr" && len(path) > 2 && path[0] == "spec" && path[1] == "inputs":
+					found[Dependency{Source: dependencySourceInput, Id: path[2]}] = true
+				case len(path) > 0 && declared[root] != nil:
+					meta := declared[root]
+					switch {
+					case meta.Type == "resource" && (path[0] == "status" || path[0] == "#outputs"):
+						found[Dependency{Source: dependencySourceObserved, Id: meta.Id}] = true
+					case meta.Type == "request" && path[0] == "result":
+						found[Dependency{Source: dependencySourceRequested, Id: meta.Id}] = true
+					case meta.Type == "input" && path[0] == "value":
+						found[Dependency{Source: dependencySourceInput, Id: meta.Id}] = true
+					}
+				}
+				return false
+			}, nil)
+		}
+	}
+
+	res := make([]*Dependency, 0)
+	for d := range found {
+		if d.Source == dependencySourceObserved && m != nil && d.Id == m.Id {
+			continue
+		}
+		d := d
+		res = append(res, &d)
+	}
+	sortDependencies(res)
+	return res, nil
+}
+
+// referencePath flattens a chain of selectors and string indexes into the referenced identifier and the path.
+func referencePath(node ast.Node) (string, []string) {
+	path := make([]string, 0)
+	for {
+		switch n := node.(type) {
+		case *ast.SelectorExpr:
+			name, _, err := ast.LabelName(n.Sel)
+			if err != nil {
+				return "", nil
+			}
+			path = append([]string{name}, path...)
+			node = n.X
+		case *ast.IndexExpr:
+			lit, ok := n.Index.(*ast.BasicLit)
+			if !ok {
+				return "", nil
+			}
+			name, err := literal.Unquote(lit.Value)
+			if err != nil {
+				return "", nil
+			}
+			path = append([]string{name}, path...)
+			node = n.X
+		case *ast.Ident:
+			if len(path) == 0 {
+				return "", nil
+			}
+			return n.Name, path
+		default:
+			return "", nil
+		}
+	}
+}
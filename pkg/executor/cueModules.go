@@ -0,0 +1,50 @@
+package executor
+
+import (
+	"cuelang.org/go/mod/modconfig"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CueRegistry is the registry of dependencies of CUE modules in the CUE_REGISTRY syntax, like
+// registry.example.com/cue or localhost:5000, it defaults to CUE_REGISTRY of the environment. Dependencies
+// declared by cue.mod/module.cue are fetched from it, vendored packages of cue.mod/pkg are used without it.
+var CueRegistry = os.Getenv("CUE_REGISTRY")
+
+// CueCacheDir keeps modules fetched from CueRegistry, the cache directory of the user is used when it is empty.
+var CueCacheDir string
+
+// findCueModuleRoot returns the nearest directory containing cue.mod from path up to root,
+// or path itself when there is none.
+func findCueModuleRoot(path, root string) string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return path
+	}
+	for dir := absPath; ; dir = filepath.Dir(dir) {
+		if info, err := os.Stat(filepath.Join(dir, "cue.mod")); err == nil && info.IsDir() {
+			return dir
+		}
+		if dir == absRoot || !strings.HasPrefix(dir, absRoot+string(filepath.Separator)) {
+			return path
+		}
+	}
+}
+
+// cueRegistry makes the registry of CueRegistry, it is nil when no registry is configured. Credentials of
+// registries are read from the environment and the logins file of cue, like cue does.
+func cueRegistry() (modconfig.Registry, error) {
+	if CueRegistry == "" {
+		return nil, nil
+	}
+	env := append(os.Environ(), "CUE_REGISTRY="+CueRegistry)
+	if CueCacheDir != "" {
+		env = append(env, "CUE_CACHE_DIR="+CueCacheDir)
+	}
+	return modconfig.NewRegistry(&modconfig.Config{Env: env, ClientType: "crossform"})
+}
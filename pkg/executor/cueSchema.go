@@ -0,0 +1,164 @@
+package executor
+
+import (
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/format"
+	"cuelang.org/go/cue/token"
+)
+
+// cueSchema converts a cue constraint into an OpenAPI v3 schema as it is accepted by CRDs.
+// Types, defaults, enums, bounds and regexes are converted, other constraints are validated by ValidateInputs only.
+func (e *cueExecutor) cueSchema(v cue.Value) map[string]interface{} {
+	schema := make(map[string]interface{})
+	if d, ok := v.Default(); ok && d.IsConcrete() {
+		var value interface{}
+		if err := d.Decode(&value); err == nil {
+			schema["default"] = value
+		}
+		v = e.withoutDefault(v)
+	}
+
+	switch kind := v.IncompleteKind(); kind {
+	case cue.StringKind:
+		schema["type"] = "string"
+	case cue.IntKind:
+		schema["type"] = "integer"
+	case cue.FloatKind, cue.NumberKind:
+		schema["type"] = "number"
+	case cue.BoolKind:
+		schema["type"] = "boolean"
+	case cue.ListKind:
+		schema["type"] = "array"
+		schema["items"] = e.cueSchema(v.LookupPath(cue.MakePath(cue.AnyIndex)))
+		return schema
+	case cue.StructKind:
+		schema["type"] = "object"
+		e.structSchema(v, schema)
+		return schema
+	default:
+		schema["x-kubernetes-preserve-unknown-fields"] = true
+		return schema
+	}
+
+	e.constraintSchema(v, schema)
+	return schema
+}
+
+func (e *cueExecutor) structSchema(v cue.Value, schema map[string]interface{}) {
+	iter, err := v.Fields(cue.Optional(true))
+	if err != nil {
+		return
+	}
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+	for iter.Next() {
+		name := iter.Selector().Unquoted()
+		properties[name] = e.cueSchema(iter.Value())
+		if _, hasDefault := iter.Value().Default(); !iter.IsOptional() && !hasDefault {
+			required = append(required, name)
+		}
+	}
+	if len(properties) == 0 {
+		schema["x-kubernetes-preserve-unknown-fields"] = true
+		return
+	}
+	schema["properties"] = properties
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+}
+
+// constraintSchema converts constraints of a scalar value.
+func (e *cueExecutor) constraintSchema(v cue.Value, schema map[string]interface{}) {
+	op, args := v.Expr()
+	switch op {
+	case cue.AndOp:
+		for _, a := range args {
+			e.constraintSchema(a, schema)
+		}
+	case cue.OrOp:
+		enum := make([]interface{}, 0, len(args))
+		for _, a := range args {
+			var value interface{}
+			if !a.IsConcrete() || a.Decode(&value) != nil {
+				return
+			}
+			enum = append(enum, value)
+		}
+		schema["enum"] = enum
+	case cue.GreaterThanEqualOp, cue.GreaterThanOp, cue.LessThanEqualOp, cue.LessThanOp:
+		var bound float64
+		if len(args) != 1 || args[0].Decode(&bound) != nil {
+			return
+		}
+		switch op {
+		case cue.GreaterThanEqualOp:
+			schema["minimum"] = bound
+		case cue.GreaterThanOp:
+			schema["minimum"] = bound
+			schema["exclusiveMinimum"] = true
+		case cue.LessThanEqualOp:
+			schema["maximum"] = bound
+		case cue.LessThanOp:
+			schema["maximum"] = bound
+			schema["exclusiveMaximum"] = true
+		}
+	case cue.RegexMatchOp:
+		var pattern string
+		if len(args) == 1 && args[0].Decode(&pattern) == nil {
+			schema["pattern"] = pattern
+		}
+	case cue.NoOp:
+		var value interface{}
+		if v.IsConcrete() && v.Decode(&value) == nil {
+			schema["enum"] = []interface{}{value}
+		}
+	}
+}
+
+// withoutDefault removes default values of a disjunction, cue does not expose the constraints of values with defaults.
+// Expressions referencing other values can not be rebuilt, the value is returned as is then.
+func (e *cueExecutor) withoutDefault(v cue.Value) cue.Value {
+	expr, ok := v.Syntax(cue.Raw()).(ast.Expr)
+	if !ok {
+		return v
+	}
+	disjuncts := make([]ast.Expr, 0)
+	var split func(expr ast.Expr)
+	split = func(expr ast.Expr) {
+		switch x := expr.(type) {
+		case *ast.BinaryExpr:
+			if x.Op == token.OR {
+				split(x.X)
+				split(x.Y)
+				return
+			}
+		case *ast.UnaryExpr:
+			if x.Op == token.MUL {
+				return
+			}
+		case *ast.ParenExpr:
+			split(x.X)
+			return
+		}
+		disjuncts = append(disjuncts, expr)
+	}
+	split(expr)
+	if len(disjuncts) == 0 {
+		return v
+	}
+	res := disjuncts[0]
+	if len(disjuncts) > 1 {
+		res = ast.NewBinExpr(token.OR, disjuncts...)
+	}
+	src, err := format.Node(res)
+	if err != nil {
+		return v
+	}
+	built := e.ctx.CompileBytes(src)
+	if built.Err() != nil {
+		return v
+	}
+	return built
+}
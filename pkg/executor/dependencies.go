@@ -0,0 +1,194 @@
+package executor
+
+import (
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	"github.com/crossplane/function-sdk-go/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Dependency is a read of another resource state detected during evaluation.
+type Dependency struct {
+	Source string `json:"source"`
+	Id     string `json:"id"`
+}
+
+const (
+	dependencySourceObserved  = "observed"
+	dependencySourceRequested = "requested"
+	dependencySourceInput     = "input"
+)
+
+func (d *Dependency) String() string {
+	switch d.Source {
+	case dependencySourceRequested:
+		return "request " + d.Id
+	case dependencySourceInput:
+		return "input " + d.Id
+	}
+	return d.Id
+}
+
+func sortDependencies(deps []*Dependency) {
+	sort.Slice(deps, func(i, j int) bool {
+		if deps[i].Source != deps[j].Source {
+			return deps[i].Source < deps[j].Source
+		}
+		return deps[i].Id < deps[j].Id
+	})
+}
+
+// conditionStatus returns the status of a condition of an object, or an empty string if it is not reported.
+func conditionStatus(obj map[string]interface{}, typ string) string {
+	status, ok := obj["status"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	conditions, ok := status["conditions"].([]interface{})
+	if !ok {
+		return ""
+	}
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != typ {
+			continue
+		}
+		s, _ := condition["status"].(string)
+		return s
+	}
+	return ""
+}
+
+func conditionsTrue(obj map[string]interface{}) bool {
+	return conditionStatus(obj, "Ready") == "True" && conditionStatus(obj, "Synced") == "True"
+}
+
+// hasConditions reports whether an object reports any condition, plain kubernetes objects like ConfigMaps have none.
+func hasConditions(obj map[string]interface{}) bool {
+	conditions, _, _ := unstructured.NestedSlice(obj, "status", "conditions")
+	return len(conditions) > 0
+}
+
+// isObservedReady reports whether a resource of this module is ready, using the readiness evaluated by the module
+// when it is available, and the observed conditions otherwise. Objects without conditions are ready once observed.
+func (e *Executor) isObservedReady(result *ExecResult, id string) bool {
+	if d, ok := result.Desired[resource.Name(id)]; ok && d.Ready != resource.ReadyUnspecified {
+		return d.Ready == resource.ReadyTrue
+	}
+	o, ok := e.cmd.Observed[resource.Name(id)]
+	if !ok {
+		return false
+	}
+	return !hasConditions(o.Resource.Object) || conditionsTrue(o.Resource.Object)
+}
+
+// isRequestedReady reports whether all resources of a request exist and none of them reports a Ready condition
+// other than True. Requested resources are often plain kubernetes objects without conditions.
+func (e *Executor) isRequestedReady(id string) bool {
+	items, ok := e.cmd.Requested[id]
+	if !ok || len(items) == 0 {
+		return false
+	}
+	for _, v := range items {
+		if ready := conditionStatus(v.Resource.Object, "Ready"); ready != "" && ready != "True" {
+			return false
+		}
+	}
+	return true
+}
+
+// deferByDependencies defers resources which read the state of resources that are not ready yet, resources
+// deferred by the engine are reported waiting for them too. Resources waiting for each other are never ready,
+// they fail with the cycle instead.
+func (e *Executor) deferByDependencies(result *ExecResult) {
+	deferred := make(map[string]bool)
+	for _, id := range result.Deferred {
+		deferred[id] = true
+	}
+	blocked := make(map[string][]string)
+	// waiting are other resources of the module the blocked resources wait for
+	waiting := make(map[string][]string)
+	for id, deps := range result.Dependencies {
+		if _, ok := result.Desired[resource.Name(id)]; !ok && !deferred[id] {
+			continue
+		}
+		for _, d := range deps {
+			switch d.Source {
+			case dependencySourceObserved:
+				if !e.isObservedReady(result, d.Id) {
+					blocked[id] = append(blocked[id], d.String())
+					if d.Id != id {
+						waiting[id] = append(waiting[id], d.Id)
+					}
+				}
+			case dependencySourceRequested:
+				if !e.isRequestedReady(d.Id) {
+					blocked[id] = append(blocked[id], d.String())
+				}
+			}
+		}
+	}
+	for id, cycle := range dependencyCycles(waiting) {
+		err := errors.Errorf("dependency cycle %s, resources of the cycle wait for each other", strings.Join(cycle, " -> "))
+		e.log.Warn().Err(err).Str("id", id).Msg("resource is in a dependency cycle")
+		delete(blocked, id)
+		if deferred[id] {
+			delete(deferred, id)
+			result.Deferred = slices.DeleteFunc(result.Deferred, func(d string) bool { return d == id })
+		}
+		delete(result.Desired, resource.Name(id))
+		result.DesiredErrors[id] = err
+		// like other failing resources it keeps the observed state
+		if o, ok := e.cmd.Observed[resource.Name(id)]; ok {
+			result.Desired[resource.Name(id)] = &resource.DesiredComposed{Resource: o.Resource}
+		}
+	}
+	for id, blocking := range blocked {
+		e.log.Debug().Str("id", id).Str("waitingFor", strings.Join(blocking, ", ")).Msg("resource deferred by detected dependencies")
+		if !deferred[id] {
+			delete(result.Desired, resource.Name(id))
+			result.Deferred = append(result.Deferred, id)
+		}
+		result.DeferredBy[id] = blocking
+	}
+	sort.Strings(result.Deferred)
+}
+
+// dependencyCycles returns the cycle of every resource waiting for itself through other resources, starting and
+// ending with the resource. Resources which wait for a cycle without being part of it are not returned.
+func dependencyCycles(waiting map[string][]string) map[string][]string {
+	cycles := make(map[string][]string)
+	for _, id := range sortedKeys(waiting) {
+		if _, ok := cycles[id]; ok {
+			continue
+		}
+		// breadth first search of the shortest path back to id
+		previous := map[string]string{id: ""}
+		queue := []string{id}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			next := append([]string(nil), waiting[current]...)
+			sort.Strings(next)
+			for _, n := range next {
+				if n == id {
+					cycle := []string{id}
+					for c := current; c != id; c = previous[c] {
+						cycle = append([]string{c}, cycle...)
+					}
+					cycle = append([]string{id}, cycle...)
+					cycles[id] = cycle
+					queue = nil
+					break
+				}
+				if _, seen := previous[n]; !seen {
+					previous[n] = current
+					queue = append(queue, n)
+				}
+			}
+		}
+	}
+	return cycles
+}
@@ -0,0 +1,144 @@
+package executor
+
+import (
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// manifestFile pins the engine of a module, it is optional when the engine can be detected.
+const manifestFile = "crossform.yaml"
+
+// Engine creates executors of one module language. Engines of this package register themselves in init
+// functions of their files, programs embedding crossform add their own engines by Register.
+type Engine interface {
+	// Name is the engine name pinned by crossform.yaml
+	Name() string
+	// Detect reports whether a module directory contains files of the engine
+	Detect(path string) (bool, error)
+	// New creates the executor of the module in path, nil when the module has no files of the engine
+	New(path string, env *Environment) (ModuleExecutor, error)
+}
+
+// Environment is shared by executors of one evaluation.
+type Environment struct {
+	// Observed, Requested and XR are JSON of observed resources, requested resources and the XR by ids,
+	// Context is JSON of the function context
+	Observed  string
+	Requested string
+	XR        string
+	Context   string
+	// root is the checkout containing the module, engines do not look for files of the module above it
+	root   string
+	budget *budget
+	// imports are git repositories imported by the module, they are resolved before the evaluation
+	imports map[string]*resolvedImport
+}
+
+// engine is an engine of this package.
+type engine struct {
+	name string
+	// detect reports whether a module directory contains files of the engine
+	detect func(path string) (bool, error)
+	// new evaluates the module, engines able to interrupt an evaluation watch the budget of the environment
+	new func(path, observed, requested, xr, context string, env *Environment) (ModuleExecutor, error)
+}
+
+func (e *engine) Name() string {
+	return e.name
+}
+
+func (e *engine) Detect(path string) (bool, error) {
+	return e.detect(path)
+}
+
+func (e *engine) New(path string, env *Environment) (ModuleExecutor, error) {
+	return e.new(path, env.Observed, env.Requested, env.XR, env.Context, env)
+}
+
+var engines = make(map[string]Engine)
+
+// Register adds an engine of modules, it panics when an engine of the same name is registered.
+// Engines are registered before modules are evaluated, e.g. in init functions. Isolated evaluations run
+// in the child process started by Isolation.Command, so that program has to register the engine too, e.g.
+// by running the same binary as the repository server.
+func Register(e Engine) {
+	if _, exist := engines[e.Name()]; exist {
+		panic("engine " + e.Name() + " is already registered")
+	}
+	engines[e.Name()] = e
+}
+
+// detectByGlob makes a detect function which looks for files matching any of the patterns.
+func detectByGlob(patterns ...string) func(path string) (bool, error) {
+	return func(path string) (bool, error) {
+		for _, p := range patterns {
+			files, err := filepath.Glob(filepath.Join(path, p))
+			if err != nil {
+				return false, err
+			}
+			if len(files) > 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+type manifest struct {
+	Engine string `yaml:"engine"`
+}
+
+func readManifest(path string) (*manifest, error) {
+	data, err := os.ReadFile(filepath.Join(path, manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrapf(err, "unable to unmarshal %s", manifestFile)
+	}
+	return &m, nil
+}
+
+// selectEngine returns the engine pinned by the module manifest, or the only engine detecting the module.
+// Modules containing files of several engines have to pin the engine.
+func selectEngine(path string) (Engine, error) {
+	m, err := readManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	if m != nil && m.Engine != "" {
+		e, ok := engines[m.Engine]
+		if !ok {
+			return nil, errors.Errorf("engine %s of %s is not supported", m.Engine, manifestFile)
+		}
+		return e, nil
+	}
+
+	detected := make([]string, 0)
+	for name, e := range engines {
+		ok, err := e.Detect(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to detect engine %s", name)
+		}
+		if ok {
+			detected = append(detected, name)
+		}
+	}
+	switch len(detected) {
+	case 0:
+		return nil, errors.New("project type is not supported")
+	case 1:
+		return engines[detected[0]], nil
+	}
+	sort.Strings(detected)
+	return nil, errors.Errorf("module contains files of several engines (%s), pin the engine in %s",
+		strings.Join(detected, ", "), manifestFile)
+}
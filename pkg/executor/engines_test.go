@@ -0,0 +1,140 @@
+package executor_test
+
+import (
+	"bufio"
+	"crossform.io/pkg/executor"
+	"crossform.io/pkg/logger"
+	"github.com/crossplane/function-sdk-go/resource"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// staticEngine makes a ConfigMap of every key=value line of *.static files.
+type staticEngine struct{}
+
+func (staticEngine) Name() string {
+	return "static"
+}
+
+func (staticEngine) Detect(path string) (bool, error) {
+	files, err := filepath.Glob(filepath.Join(path, "*.static"))
+	return len(files) > 0, err
+}
+
+func (staticEngine) New(path string, env *executor.Environment) (executor.ModuleExecutor, error) {
+	files, err := filepath.Glob(filepath.Join(path, "*.static"))
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+	if env.XR == "" {
+		return nil, os.ErrInvalid
+	}
+	e := &staticExecutor{values: make(map[string]map[string]string)}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Base(file)
+		e.files = append(e.files, name)
+		e.values[name] = make(map[string]string)
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			if k, v, ok := strings.Cut(s.Text(), "="); ok {
+				e.values[name][k] = v
+			}
+		}
+		_ = f.Close()
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+type staticExecutor struct {
+	files  []string
+	values map[string]map[string]string
+}
+
+func (e *staticExecutor) GetFileNames() []string {
+	return e.files
+}
+
+func (e *staticExecutor) GetFields(fileName string) []string {
+	fields := make([]string, 0)
+	for k := range e.values[fileName] {
+		fields = append(fields, k)
+	}
+	return fields
+}
+
+func (e *staticExecutor) GetMetadataObject(fileName, field string) *executor.Metadata {
+	return &executor.Metadata{Id: field, Type: "resource"}
+}
+
+func (e *staticExecutor) GetCrossformObject(fileName, field string) (*executor.Crossform, error) {
+	return &executor.Crossform{Metadata: *e.GetMetadataObject(fileName, field)}, nil
+}
+
+func (e *staticExecutor) ValidateInputs(map[string]*executor.Crossform, map[string]interface{}) error {
+	return nil
+}
+
+func (e *staticExecutor) GetResource(fileName, field string) (map[string]interface{}, bool, resource.Ready, error) {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"data":       map[string]interface{}{"value": e.values[fileName][field]},
+	}, false, resource.ReadyTrue, nil
+}
+
+func (e *staticExecutor) GetDependencies(string, string) ([]*executor.Dependency, error) {
+	return nil, nil
+}
+
+func (e *staticExecutor) GetOutputSchema(string, string) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func TestRegister(t *testing.T) {
+	logger.InitLog()
+	executor.Register(staticEngine{})
+
+	data, err := os.ReadFile("testdata/jsonnet/new/command.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := &executor.ExecCommand{}
+	if err := yaml.Unmarshal(data, cmd); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.static"), []byte("greeting=hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd.Path = "."
+
+	res, err := executor.Execute(dir, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	greeting, ok := res.Desired["greeting"]
+	if !ok {
+		t.Fatalf("resource greeting is not desired, errors: %v", res.DesiredErrors)
+	}
+	value, _ := greeting.Resource.GetString("data.value")
+	if value != "hello" || greeting.Ready != resource.ReadyTrue {
+		t.Fatalf("unexpected resource %v ready %s", greeting.Resource.Object, greeting.Ready)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering an engine twice must panic")
+		}
+	}()
+	executor.Register(staticEngine{})
+}
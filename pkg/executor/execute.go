@@ -1,8 +1,15 @@
 package executor
 
 import (
+	"fmt"
 	fnv1beta1 "github.com/crossplane/function-sdk-go/proto/v1beta1"
 	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composed"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+	"golang.org/x/exp/maps"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sort"
+	"strings"
 )
 
 type ExecCommand struct {
@@ -16,29 +23,131 @@ type ExecCommand struct {
 	Context            string
 }
 
+// DeepCopy copies the command together with its resources, evaluations of the copy do not change the command.
+func (cmd *ExecCommand) DeepCopy() *ExecCommand {
+	res := *cmd
+	if cmd.Observed != nil {
+		res.Observed = make(map[resource.Name]resource.ObservedComposed, len(cmd.Observed))
+		for k, v := range cmd.Observed {
+			if v.Resource != nil {
+				v.Resource = &composed.Unstructured{Unstructured: copyUnstructured(v.Resource.Unstructured)}
+			}
+			v.ConnectionDetails = maps.Clone(v.ConnectionDetails)
+			res.Observed[k] = v
+		}
+	}
+	if cmd.Requested != nil {
+		res.Requested = make(map[string][]resource.Extra, len(cmd.Requested))
+		for k, extras := range cmd.Requested {
+			copied := make([]resource.Extra, len(extras))
+			for i, v := range extras {
+				if v.Resource != nil {
+					u := copyUnstructured(*v.Resource)
+					v.Resource = &u
+				}
+				copied[i] = v
+			}
+			res.Requested[k] = copied
+		}
+	}
+	if cmd.XR != nil {
+		res.XR = &resource.Composite{ConnectionDetails: maps.Clone(cmd.XR.ConnectionDetails)}
+		if cmd.XR.Resource != nil {
+			res.XR.Resource = &composite.Unstructured{Unstructured: copyUnstructured(cmd.XR.Resource.Unstructured)}
+		}
+	}
+	return &res
+}
+
+// copyUnstructured copies the object, unlike Unstructured.DeepCopy it accepts any scalar values which the yaml
+// decoding of commands produces.
+func copyUnstructured(u unstructured.Unstructured) unstructured.Unstructured {
+	if u.Object == nil {
+		return u
+	}
+	return unstructured.Unstructured{Object: copyValue(u.Object).(map[string]interface{})}
+}
+
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			res[k] = copyValue(item)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			res[i] = copyValue(item)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
 type ExecResult struct {
-	Desired               map[resource.Name]*resource.DesiredComposed
-	DesiredErrors         map[string]error
-	Deferred              []string
-	Request               map[string]*fnv1beta1.ResourceSelector
-	RequestErrors         map[string]error
-	Outputs               map[string]interface{}
-	OutputsErrors         map[string]error
-	Inputs                map[string]string
-	InputsErrors          map[string]error
+	Desired             map[resource.Name]*resource.DesiredComposed
+	DesiredErrors       map[string]error
+	Deferred            []string
+	DeferredBy          map[string][]string
+	Dependencies        map[string][]*Dependency
+	Request             map[string]*fnv1beta1.ResourceSelector
+	RequestErrors       map[string]error
+	Outputs             map[string]interface{}
+	OutputsErrors       map[string]error
+	OutputsDependencies map[string][]*Dependency
+	Inputs              map[string]string
+	InputsErrors        map[string]error
+	// InputsDeferredBy are objects referenced by valueFrom of inputs which are not found, by names of the inputs
+	InputsDeferredBy      map[string]string `yaml:",omitempty"`
 	InputsValidationError error
+	Graph                 *Graph
+	// ModulesErrors are errors reported by child modules
+	ModulesErrors map[string]error `yaml:",omitempty"`
+	// ConnectionDetails are values of sensitive outputs and inputs, they are published as connection details
+	// of the XR instead of its status
+	ConnectionDetails map[string]string `yaml:",omitempty"`
+	// SensitiveOutputs are ids of outputs published in ConnectionDetails
+	SensitiveOutputs []string `yaml:",omitempty"`
+	// SensitiveInputs are ids of inputs declared sensitive, their values in the XR are secrets
+	SensitiveInputs []string `yaml:",omitempty"`
+	// OutputsSchema is the schema of status.outputs made of outputs declared by the module, parent modules
+	// validate outputs of the module against it
+	OutputsSchema map[string]interface{} `yaml:",omitempty"`
+	// Imports are commit shas of git repositories imported by the module, keyed by url@revision
+	Imports map[string]string `yaml:",omitempty"`
 }
 
 func NewExecResult() *ExecResult {
 	return &ExecResult{
-		Desired:       make(map[resource.Name]*resource.DesiredComposed),
-		DesiredErrors: make(map[string]error),
-		Deferred:      make([]string, 0),
-		Request:       make(map[string]*fnv1beta1.ResourceSelector),
-		RequestErrors: make(map[string]error),
-		Outputs:       make(map[string]interface{}),
-		OutputsErrors: make(map[string]error),
-		Inputs:        make(map[string]string),
-		InputsErrors:  make(map[string]error),
+		Desired:             make(map[resource.Name]*resource.DesiredComposed),
+		DesiredErrors:       make(map[string]error),
+		Deferred:            make([]string, 0),
+		DeferredBy:          make(map[string][]string),
+		Dependencies:        make(map[string][]*Dependency),
+		Request:             make(map[string]*fnv1beta1.ResourceSelector),
+		RequestErrors:       make(map[string]error),
+		Outputs:             make(map[string]interface{}),
+		OutputsErrors:       make(map[string]error),
+		OutputsDependencies: make(map[string][]*Dependency),
+		Inputs:              make(map[string]string),
+		InputsErrors:        make(map[string]error),
+	}
+}
+
+// InputsValidationError reports inputs which do not satisfy their constraints, messages are keyed by input ids.
+type InputsValidationError struct {
+	Fields map[string]string
+}
+
+func (e *InputsValidationError) Error() string {
+	ids := maps.Keys(e.Fields)
+	sort.Strings(ids)
+	msgs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", id, e.Fields[id]))
 	}
+	return "inputs validation failed: " + strings.Join(msgs, ", ")
 }
@@ -13,31 +13,65 @@ import (
 	"os"
 )
 
-type genericExecutor interface {
+// ModuleExecutor evaluates one module, fields of its files are resources, requests, inputs and outputs
+// described by crossform objects.
+type ModuleExecutor interface {
 	GetFileNames() []string
 	GetFields(fileName string) []string
-	GetMetadataObject(fileName, field string) *metadata
-	GetCrossformObject(fileName, field string) (*crossform, error)
-	ValidateInputs(inputs map[string]*crossform, input map[string]interface{}) error
+	GetMetadataObject(fileName, field string) *Metadata
+	GetCrossformObject(fileName, field string) (*Crossform, error)
+	ValidateInputs(inputs map[string]*Crossform, input map[string]interface{}) error
 	GetResource(fileName, field string) (map[string]interface{}, bool, resource.Ready, error)
+	GetDependencies(fileName, field string) ([]*Dependency, error)
+	// GetOutputSchema returns the JSON schema of an output without evaluating its value, nil when it has none
+	GetOutputSchema(fileName, field string) (map[string]interface{}, error)
 }
 
 type Executor struct {
 	log      zerolog.Logger
 	cmd      *ExecCommand
 	path     string
-	executor genericExecutor
+	env      *Environment
+	executor ModuleExecutor
+	// sensitiveInputs and sensitiveOutputs are declarations of sensitive values, see publishSensitive
+	sensitiveInputs  map[string]*Crossform
+	sensitiveOutputs map[string]bool
+	// inputRequests request kinds referenced by valueFrom of inputs, see resolveInputRefs
+	inputRequests   map[string]*fnv1beta1.ResourceSelector
+	inputRefsErrors map[string]error
+	// inputRefsDeferred are objects referenced by inputs which are not found, by names of the inputs
+	inputRefsDeferred map[string]string
+	inputRefsPending  bool
+	// declaredOutputs are schemas of outputs of the module by ids, nil for outputs without a schema
+	declaredOutputs map[string]map[string]interface{}
+	// modulesOutputsErrors are outputs of child modules not matching their declarations, see typeModulesOutputs
+	modulesOutputsErrors map[string]error
 }
 
 func NewExecutor(cmd *ExecCommand, path string) (*Executor, error) {
+	imports, err := resolveImports(path+"/"+cmd.Path, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return newExecutor(cmd, path, &Environment{root: path, budget: newBudget(DefaultLimits), imports: imports})
+}
+
+func newExecutor(cmd *ExecCommand, path string, env *Environment) (*Executor, error) {
 	e := &Executor{
 		log: logger.GetLogger("Executor").With().
 			Str("url", cmd.RepositoryUrl).
 			Str("revision", cmd.RepositoryRevision).
 			Str("directory", cmd.Path).
 			Logger(),
-		cmd:  cmd,
-		path: path,
+		cmd:               cmd,
+		path:              path,
+		env:               env,
+		sensitiveInputs:   make(map[string]*Crossform),
+		sensitiveOutputs:  make(map[string]bool),
+		inputRequests:     make(map[string]*fnv1beta1.ResourceSelector),
+		inputRefsErrors:   make(map[string]error),
+		inputRefsDeferred: make(map[string]string),
+		declaredOutputs:   make(map[string]map[string]interface{}),
 	}
 
 	if _, err := os.Stat(path + "/" + cmd.Path); os.IsNotExist(err) {
@@ -61,21 +95,25 @@ func NewExecutor(cmd *ExecCommand, path string) (*Executor, error) {
 		statusTyped["connectionDetails"] = d
 	}
 
+	e.typeModulesOutputs()
+	e.resolveInputRefs()
 	observed, requested, xr, context, err := e.marshal()
+	if err != nil {
+		return e, err
+	}
 
-	var ex genericExecutor = nil
-	ex, err = newJsonnetExecutor(path+"/"+cmd.Path, observed, requested, xr, context)
+	eng, err := selectEngine(path + "/" + cmd.Path)
+	if err != nil {
+		return e, err
+	}
+	e.log.Debug().Str("engine", eng.Name()).Msg("engine selected")
+	env.Observed, env.Requested, env.XR, env.Context = observed, requested, xr, context
+	ex, err := eng.New(path+"/"+cmd.Path, env)
 	if err != nil {
 		return e, err
 	}
 	if ex == nil {
-		ex, err = newCueExecutor(path+"/"+cmd.Path, observed, requested, xr, context)
-		if err != nil {
-			return e, err
-		}
-		if ex == nil {
-			return e, errors.New("project type is not supported")
-		}
+		return e, errors.New("project type is not supported")
 	}
 	e.executor = ex
 	return e, nil
@@ -94,6 +132,9 @@ func (e *Executor) marshal() (string, string, string, string, error) {
 
 	temp = make(map[string]interface{})
 	for k, v := range e.cmd.Requested {
+		if isInputsRequest(k) {
+			continue
+		}
 		for _, vv := range v {
 			temp[k] = vv.Resource.Object
 		}
@@ -116,7 +157,7 @@ func (e *Executor) marshal() (string, string, string, string, error) {
 
 func (e *Executor) makeRequestResult(
 	result map[string]*fnv1beta1.ResourceSelector,
-	request map[string]*crossform,
+	request map[string]*Crossform,
 ) error {
 	for k, v := range request {
 		_, exist := result[k]
@@ -146,16 +187,31 @@ func (e *Executor) makeRequestResult(
 
 func (e *Executor) Exec() (*ExecResult, error) {
 	result := NewExecResult()
+	if len(e.env.imports) > 0 {
+		result.Imports = make(map[string]string, len(e.env.imports))
+		for k, v := range e.env.imports {
+			result.Imports[k] = v.Sha
+		}
+	}
 
 	e.log.Debug().Msg("start execution")
 
+	// kinds referenced by inputs are requested by every evaluation, so that the requirements stay stable
+	for k, v := range e.inputRequests {
+		result.Request[k] = v
+	}
+	if e.inputRefsPending {
+		e.log.Debug().Msg("secrets and config maps of inputs are not requested yet, skipping evaluation")
+		return result, nil
+	}
+
 	insufficientRequestedResources := false
 	for _, file := range e.executor.GetFileNames() {
 
-		desired, desiredErrors, resourcesDeferred, request, requestsErrs, outputs, outputsErrs, inputs, inputsErrs, err :=
-			e.execFile(file, false)
+		desired, desiredErrors, resourcesDeferred, request, requestsErrs, outputs, outputsErrs, inputs, inputsErrs, dependencies, outputsDependencies, err :=
+			e.execFile(file, !insufficientRequestedResources)
 		if err != nil {
-			return nil, errors.Wrap(err, "Jsonnet execution fatal error")
+			return nil, errors.Wrap(err, "Module execution fatal error")
 		}
 
 		xrSpec := e.cmd.XR.Resource.Object["spec"]
@@ -164,6 +220,12 @@ func (e *Executor) Exec() (*ExecResult, error) {
 			if err := e.executor.ValidateInputs(inputs, xrInputs.(map[string]interface{})); err != nil {
 				e.log.Error().Err(err).Msg("Inputs schema validation error")
 				result.InputsValidationError = err
+				var fieldsErr *InputsValidationError
+				if errors.As(err, &fieldsErr) {
+					for k, v := range fieldsErr.Fields {
+						inputsErrs[k] = errors.New(v)
+					}
+				}
 			}
 
 			for k, v := range inputsErrs {
@@ -188,12 +250,13 @@ func (e *Executor) Exec() (*ExecResult, error) {
 			continue
 		}
 
-		desired, desiredErrors, resourcesDeferred, request, requestsErrs, outputs, outputsErrs, inputs, inputsErrs, err =
-			e.execFile(file, true)
-		if err != nil {
-			return nil, errors.Wrap(err, "Jsonnet execution fatal error")
+		result.Deferred = append(result.Deferred, resourcesDeferred...)
+		for k, v := range dependencies {
+			result.Dependencies[k] = v
+		}
+		for k, v := range outputsDependencies {
+			result.OutputsDependencies[k] = v
 		}
-		result.Deferred = resourcesDeferred
 
 		err = e.makeRequestResult(result.Request, request)
 		if err != nil {
@@ -208,12 +271,8 @@ func (e *Executor) Exec() (*ExecResult, error) {
 		}
 
 		for k, v := range desired {
-			j, err := v.Resource.MarshalJSON()
-			if err != nil {
-				e.log.Error().Str("id", k).Msg("Unable marshal desired resource")
-				return nil, err
-			}
-			e.log.Debug().Str("id", k).Str("json", string(j)).Msg("desired resource OK")
+			// resources may contain values of sensitive inputs, they are not logged
+			e.log.Debug().Str("id", k).Msg("desired resource OK")
 			_, exist := result.Desired[resource.Name(k)]
 			if exist {
 				err = errors.Errorf("duplicated id=%s detected, execution fatal", k)
@@ -280,6 +339,11 @@ func (e *Executor) Exec() (*ExecResult, error) {
 				if ok {
 					e.log.Debug().Str("id", k).Err(v).Msg("output previous state found")
 					result.Outputs[k] = val
+					// an output not matching its schema keeps the previous value, but the contract is broken
+					var schemaErr *OutputSchemaError
+					if errors.As(v, &schemaErr) {
+						result.OutputsErrors[k] = v
+					}
 				} else {
 					e.log.Debug().Str("id", k).Err(v).Msg("output previous state not found, skipping")
 					result.OutputsErrors[k] = v
@@ -287,6 +351,17 @@ func (e *Executor) Exec() (*ExecResult, error) {
 			}
 		}
 	}
+	for k, v := range e.inputRefsErrors {
+		result.InputsErrors[k] = v
+	}
+	if len(e.inputRefsDeferred) > 0 {
+		result.InputsDeferredBy = e.inputRefsDeferred
+	}
+	e.publishSensitive(result)
+	e.declareOutputs(result)
+	e.deferByDependencies(result)
+	e.expandModules(result)
+	result.Graph = e.buildGraph(result)
 	return result, nil
 }
 
@@ -357,12 +432,14 @@ func (e *Executor) execFile(
 	map[string]*resource.DesiredComposed,
 	map[string]error,
 	[]string,
-	map[string]*crossform,
+	map[string]*Crossform,
 	map[string]error,
 	map[string]interface{},
 	map[string]error,
-	map[string]*crossform,
+	map[string]*Crossform,
 	map[string]error,
+	map[string][]*Dependency,
+	map[string][]*Dependency,
 	error,
 ) {
 	e.log.Debug().
@@ -375,35 +452,22 @@ func (e *Executor) execFile(
 	resources := make(map[string]*resource.DesiredComposed)
 	resourcesErrs := make(map[string]error)
 	resourcesDeferred := make([]string, 0)
-	requests := make(map[string]*crossform)
+	requests := make(map[string]*Crossform)
 	requestsErrs := make(map[string]error)
 	outputs := make(map[string]interface{})
 	outputsErrs := make(map[string]error)
-	inputs := make(map[string]*crossform)
+	inputs := make(map[string]*Crossform)
 	inputsErrs := make(map[string]error)
+	dependencies := make(map[string][]*Dependency)
+	outputsDependencies := make(map[string][]*Dependency)
 
+	// requests and inputs are evaluated first, resources and outputs only once all requests are fulfilled
 	for _, name := range names {
 		log := log.With().Str("file", filename).Str("field", name).Logger()
 
 		metadata := e.executor.GetMetadataObject(filename, name)
 
 		switch metadata.Type {
-		case "resource":
-			if evaluate {
-				res, isDeferred, ready, err := e.executor.GetResource(filename, name)
-				if err != nil {
-					resourcesErrs[metadata.Id] = err
-					continue
-				}
-				if isDeferred {
-					resourcesDeferred = append(resourcesDeferred, metadata.Id)
-				} else {
-					var t composed.Unstructured
-					t.Unstructured.Object = res
-					resources[metadata.Id] = &resource.DesiredComposed{Resource: &t, Ready: ready}
-				}
-				log.Debug().Str("id", metadata.Id).Msg("resource unmarshal success")
-			}
 		case "request":
 			crossform, err := e.executor.GetCrossformObject(filename, name)
 			if err != nil {
@@ -412,16 +476,6 @@ func (e *Executor) execFile(
 			}
 			requests[metadata.Id] = crossform
 			log.Debug().Str("id", metadata.Id).Msg("request unmarshal success")
-		case "output":
-			if evaluate {
-				crossform, err := e.executor.GetCrossformObject(filename, name)
-				if err != nil {
-					outputsErrs[metadata.Id] = err
-					continue
-				}
-				outputs[metadata.Id] = crossform.Output
-				log.Debug().Str("id", metadata.Id).Msg("outputs unmarshal success")
-			}
 		case "input":
 			crossform, err := e.executor.GetCrossformObject(filename, name)
 			if err != nil {
@@ -429,8 +483,80 @@ func (e *Executor) execFile(
 				continue
 			}
 			inputs[metadata.Id] = crossform
+			if crossform.Sensitive {
+				e.sensitiveInputs[metadata.Id] = crossform
+			}
 			log.Debug().Str("id", metadata.Id).Msg("input unmarshal success")
 		}
 	}
-	return resources, resourcesErrs, resourcesDeferred, requests, requestsErrs, outputs, outputsErrs, inputs, inputsErrs, nil
+	for id := range requests {
+		if _, ok := e.cmd.Requested[id]; !ok {
+			evaluate = false
+		}
+	}
+	if !evaluate {
+		return resources, resourcesErrs, resourcesDeferred, requests, requestsErrs, outputs, outputsErrs, inputs, inputsErrs, dependencies, outputsDependencies, nil
+	}
+
+	for _, name := range names {
+		log := log.With().Str("file", filename).Str("field", name).Logger()
+
+		metadata := e.executor.GetMetadataObject(filename, name)
+
+		switch metadata.Type {
+		case "resource":
+			res, isDeferred, ready, err := e.executor.GetResource(filename, name)
+			if err != nil {
+				resourcesErrs[metadata.Id] = err
+				continue
+			}
+			// dependencies of deferred resources tell what they wait for
+			deps, err := e.executor.GetDependencies(filename, name)
+			if err != nil {
+				log.Warn().Err(err).Str("id", metadata.Id).Msg("dependencies detection failed")
+			} else if len(deps) > 0 {
+				dependencies[metadata.Id] = deps
+			}
+			if isDeferred {
+				resourcesDeferred = append(resourcesDeferred, metadata.Id)
+			} else {
+				var t composed.Unstructured
+				t.Unstructured.Object = res
+				resources[metadata.Id] = &resource.DesiredComposed{Resource: &t, Ready: ready}
+			}
+			log.Debug().Str("id", metadata.Id).Msg("resource unmarshal success")
+		case "output":
+			schema, err := e.executor.GetOutputSchema(filename, name)
+			if err != nil {
+				outputsErrs[metadata.Id] = err
+				continue
+			}
+			e.declaredOutputs[metadata.Id] = schema
+			crossform, err := e.executor.GetCrossformObject(filename, name)
+			if err != nil {
+				outputsErrs[metadata.Id] = err
+				continue
+			}
+			if crossform.Sensitive {
+				e.sensitiveOutputs[metadata.Id] = true
+			}
+			if schema != nil {
+				err = validateOutputSchema(metadata.Id, schema, crossform.Output)
+			}
+			if err != nil {
+				outputsErrs[metadata.Id] = err
+				continue
+			}
+			outputs[metadata.Id] = crossform.Output
+			log.Debug().Str("id", metadata.Id).Msg("outputs unmarshal success")
+
+			deps, err := e.executor.GetDependencies(filename, name)
+			if err != nil {
+				log.Warn().Err(err).Str("id", metadata.Id).Msg("dependencies detection failed")
+			} else if len(deps) > 0 {
+				outputsDependencies[metadata.Id] = deps
+			}
+		}
+	}
+	return resources, resourcesErrs, resourcesDeferred, requests, requestsErrs, outputs, outputsErrs, inputs, inputsErrs, dependencies, outputsDependencies, nil
 }
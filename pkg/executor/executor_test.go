@@ -1,12 +1,29 @@
 package executor
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
 	"crossform.io/pkg/logger"
+	"cuelabs.dev/go/oci/ociregistry/ocimem"
+	"cuelabs.dev/go/oci/ociregistry/ociserver"
+	"cuelang.org/go/mod/modregistry"
+	"cuelang.org/go/mod/module"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composed"
 	"github.com/kylelemons/godebug/diff"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	"gopkg.in/yaml.v3"
+	"io/fs"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -18,6 +35,21 @@ type testCase struct {
 	name   string
 }
 
+// loadCommand reads command.yaml of the test case directory, the module is in its src directory.
+func loadCommand(t testing.TB, dir string) *ExecCommand {
+	t.Helper()
+	cmdYaml, err := os.ReadFile(dir + "/command.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cmd ExecCommand
+	if err := yaml.Unmarshal(cmdYaml, &cmd); err != nil {
+		t.Fatal(err)
+	}
+	cmd.Path = "src"
+	return &cmd
+}
+
 func TestExecutor(t *testing.T) {
 	logger.InitLog()
 	var cases []*testCase
@@ -98,7 +130,7 @@ func TestExecutor(t *testing.T) {
 				return
 			}
 			resYaml, err := yaml.Marshal(res)
-			if string(resYaml) != tc.result {
+			if err != nil {
 				t.Fatalf(err.Error())
 				return
 			}
@@ -108,3 +140,890 @@ func TestExecutor(t *testing.T) {
 		})
 	}
 }
+
+func TestCommandDeepCopy(t *testing.T) {
+	cmd := loadCommand(t, "testdata/jsonnet/new")
+	cp := cmd.DeepCopy()
+	cp.XR.Resource.SetName("changed")
+	cp.Observed["test1"].Resource.SetName("changed")
+	if cmd.XR.Resource.GetName() == "changed" || cmd.Observed["test1"].Resource.GetName() == "changed" {
+		t.Fatal("changes of the copy must not change the command")
+	}
+}
+
+func TestGraphDOT(t *testing.T) {
+	g := &Graph{
+		Module: "test",
+		Nodes: []*GraphNode{
+			{Id: "resource/a", Type: "resource", Name: "a", Status: graphNodeOk, Ready: "False", Synced: "True"},
+			{Id: "resource/b", Type: "resource", Name: "b", Status: graphNodeDeferred},
+		},
+		Edges: []*GraphEdge{
+			{From: "resource/a", To: "resource/b", Blocking: true},
+		},
+	}
+	expected := `digraph "test" {
+  rankdir=LR;
+  "resource/a" [label="resource a\nOK\nReady=False Synced=True", shape=box, color=darkgreen];
+  "resource/b" [label="resource b\nDEFERRED", shape=box, color=orange];
+  "resource/a" -> "resource/b" [color=red, style=bold, label="blocking"];
+}
+`
+	if res := g.DOT(); res != expected {
+		t.Fatal(diff.Diff(expected, res))
+	}
+}
+
+func TestInputsSchema(t *testing.T) {
+	logger.InitLog()
+	dirs, err := filepath.Glob("testdata/*/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, testPath := range dirs {
+		expected, err := os.ReadFile(testPath + "/schema.yaml")
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		t.Run(testPath, func(t *testing.T) {
+			schema, err := InputsSchema(testPath, loadCommand(t, testPath))
+			if err != nil {
+				t.Fatal(err)
+			}
+			schemaYaml, err := yaml.Marshal(schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(schemaYaml) != string(expected) {
+				t.Fatal(diff.Diff(string(schemaYaml), string(expected)))
+			}
+		})
+	}
+}
+
+// TestInputsSchemaValueFrom validates claims against the generated inputs schema, it has to stay structural and
+// accept valueFrom in place of every input.
+func TestInputsSchemaValueFrom(t *testing.T) {
+	logger.InitLog()
+	cmd := loadCommand(t, "testdata/cue/inputs")
+	schema, err := InputsSchema("testdata/cue/inputs", cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range schema["properties"].(map[string]interface{}) {
+		property := v.(map[string]interface{})
+		if _, ok := property["type"]; ok {
+			t.Fatalf("input %s is typed, valueFrom objects would be rejected", k)
+		}
+		if property["x-kubernetes-preserve-unknown-fields"] != true {
+			t.Fatalf("input %s prunes valueFrom fields", k)
+		}
+	}
+	j, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource("https://crossform.io/inputs", bytes.NewReader(j)); err != nil {
+		t.Fatal(err)
+	}
+	sch, err := compiler.Compile("https://crossform.io/inputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valueFrom := map[string]interface{}{
+		"valueFrom": map[string]interface{}{
+			"secretKeyRef": map[string]interface{}{"name": "inputs", "key": "name"},
+		},
+	}
+	valid := map[string]interface{}{
+		"name":     valueFrom,
+		"size":     "small",
+		"zone":     valueFrom,
+		"replicas": 3,
+	}
+	if err := sch.Validate(valid); err != nil {
+		t.Fatalf("the claim with valueFrom is rejected: %v", err)
+	}
+	invalid := map[string]interface{}{
+		"name":     "Invalid Name",
+		"size":     "small",
+		"zone":     valueFrom,
+		"replicas": 11,
+	}
+	err = sch.Validate(invalid)
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	for _, field := range []string{"/name", "/replicas"} {
+		found := false
+		for _, cause := range validationErr.Causes {
+			if cause.InstanceLocation == field {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("expected an error of %s, got %v", field, err)
+		}
+	}
+}
+
+func TestOutputsSchema(t *testing.T) {
+	logger.InitLog()
+	dirs, err := filepath.Glob("testdata/*/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, testPath := range dirs {
+		expected, err := os.ReadFile(testPath + "/outputsSchema.yaml")
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		t.Run(testPath, func(t *testing.T) {
+			schema, err := OutputsSchema(testPath, loadCommand(t, testPath))
+			if err != nil {
+				t.Fatal(err)
+			}
+			schemaYaml, err := yaml.Marshal(schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(schemaYaml) != string(expected) {
+				t.Fatal(diff.Diff(string(schemaYaml), string(expected)))
+			}
+		})
+	}
+}
+
+func TestExecuteLimits(t *testing.T) {
+	logger.InitLog()
+	// jsonnet can not interrupt an evaluation, it is abandoned at the timeout
+	loop := t.TempDir()
+	// the recursion is bounded, so that the abandoned evaluation does not overflow the stack of the test process
+	src := "local loop(n) = if n == 0 then 0 else loop(n - 1) tailstrict;\n" +
+		"local n = std.foldl(function(acc, i) acc + loop(10000), std.range(1, 1e6), 0);\n" +
+		"{ test1: std.extVar('crossform').resource('test1', { spec: { n: n } }) }\n"
+	if err := os.WriteFile(filepath.Join(loop, "main.jsonnet"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		path   string
+		src    string
+		limits map[string]interface{}
+		budget string
+	}{
+		{name: "starlark steps", path: "testdata/starlark/steps", src: "src", budget: "steps"},
+		{name: "starlark timeout", path: "testdata/starlark/steps", src: "src",
+			limits: map[string]interface{}{"timeout": "100ms", "maxSteps": 0}, budget: "timeout"},
+		{name: "jsonnet timeout", path: loop, src: ".",
+			limits: map[string]interface{}{"timeout": "100ms"}, budget: "timeout"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cmd := loadCommand(t, "testdata/starlark/steps")
+			cmd.Path = c.src
+			if c.limits != nil {
+				cmd.XR.Resource.Object["spec"].(map[string]interface{})["limits"] = c.limits
+			}
+			_, err := Execute(c.path, cmd)
+			var budgetErr *BudgetExceededError
+			if !errors.As(err, &budgetErr) {
+				t.Fatalf("expected exceeded budget, got %v", err)
+			}
+			if budgetErr.Budget != c.budget {
+				t.Fatalf("expected %s budget, got %s", c.budget, budgetErr.Budget)
+			}
+		})
+	}
+}
+
+// TestIsolatedChild is the child process of isolated evaluations of TestExecuteIsolated.
+func TestIsolatedChild(t *testing.T) {
+	if os.Getenv("CROSSFORM_ISOLATED_CHILD") != "1" {
+		t.Skip("started by isolated evaluations")
+	}
+	logger.InitLogWriter(os.Stderr)
+	if err := ServeIsolated(os.Stdin, os.Stdout); err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// skipWithoutSandbox skips tests of sandboxed evaluations where unprivileged user namespaces are not available.
+func skipWithoutSandbox(t *testing.T) {
+	t.Helper()
+	attr, err := isolationAttr(IsolationSandbox)
+	if err == nil {
+		c := exec.Command(os.Args[0], "-test.run=^$")
+		c.SysProcAttr = attr
+		err = c.Run()
+	}
+	if err != nil {
+		t.Skipf("sandbox is not supported: %v", err)
+	}
+}
+
+func TestExecuteIsolated(t *testing.T) {
+	logger.InitLog()
+	t.Setenv("CROSSFORM_ISOLATED_CHILD", "1")
+	defer func(i IsolationConfig) { Isolation = i }(Isolation)
+	loop := t.TempDir()
+	// the recursion is bounded, so that the evaluation runs until the child is killed instead of overflowing the stack
+	src := "local loop(n) = if n == 0 then 0 else loop(n - 1) tailstrict;\n" +
+		"local n = std.foldl(function(acc, i) acc + loop(10000), std.range(1, 1e6), 0);\n" +
+		"{ test1: std.extVar('crossform').resource('test1', { spec: { n: n } }) }\n"
+	if err := os.WriteFile(filepath.Join(loop, "main.jsonnet"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hog := t.TempDir()
+	src = "{ test1: std.extVar('crossform').resource('test1', { spec: { n: std.length(std.range(1, 1e9)) } }) }\n"
+	if err := os.WriteFile(filepath.Join(hog, "main.jsonnet"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []IsolationMode{IsolationProcess, IsolationSandbox} {
+		Isolation = IsolationConfig{Mode: mode, Command: []string{os.Args[0], "-test.run=^TestIsolatedChild$"}}
+		t.Run(string(mode), func(t *testing.T) {
+			if mode == IsolationSandbox {
+				skipWithoutSandbox(t)
+			}
+			for _, testPath := range []string{"testdata/jsonnet/new", "testdata/starlark/new", "testdata/yaml/new"} {
+				res, err := Execute(testPath, loadCommand(t, testPath))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resYaml, err := yaml.Marshal(res)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected, err := os.ReadFile(testPath + "/result.yaml")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(resYaml) != string(expected) {
+					t.Fatal(diff.Diff(string(resYaml), string(expected)))
+				}
+			}
+
+			cmd := loadCommand(t, "testdata/starlark/steps")
+			cmd.Path = "."
+			cmd.XR.Resource.Object["spec"].(map[string]interface{})["limits"] = map[string]interface{}{"timeout": "200ms"}
+			_, err := Execute(loop, cmd)
+			var budgetErr *BudgetExceededError
+			if !errors.As(err, &budgetErr) || budgetErr.Budget != "timeout" {
+				t.Fatalf("expected exceeded timeout, got %v", err)
+			}
+
+			cmd = loadCommand(t, "testdata/starlark/steps")
+			cmd.Path = "."
+			cmd.XR.Resource.Object["spec"].(map[string]interface{})["limits"] = map[string]interface{}{"maxMemory": "64Mi"}
+			_, err = Execute(hog, cmd)
+			if !errors.As(err, &budgetErr) || budgetErr.Budget != "memory" {
+				t.Fatalf("expected exceeded memory, got %v", err)
+			}
+		})
+	}
+}
+
+type testImportResolver map[string]string
+
+func (r testImportResolver) ResolveImport(_ *ExecCommand, url, revision string) (string, string, error) {
+	dir, ok := r[url+"@"+revision]
+	if !ok {
+		return "", "", fmt.Errorf("unknown repository %s", url)
+	}
+	return dir, "0123456789abcdef0123456789abcdef01234567", nil
+}
+
+func TestGitImports(t *testing.T) {
+	logger.InitLog()
+	defer func(i ImportResolver) { Imports = i }(Imports)
+	libs := t.TempDir()
+	files := map[string]string{
+		"k8s/k8s.libsonnet":  "local util = import 'util.libsonnet';\n{ configMap(name, data):: util.metadata(name) + { apiVersion: 'v1', kind: 'ConfigMap', data: data } }\n",
+		"k8s/util.libsonnet": "{ metadata(name):: { metadata: { name: name } } }\n",
+		"version.txt":        "v1",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(libs, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(libs, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	module := t.TempDir()
+	src := "local k8s = import 'git+https://example.com/libs.git@v1//k8s/k8s.libsonnet';\n" +
+		"local version = importstr 'git+https://example.com/libs.git@v1//version.txt';\n" +
+		"{ config: std.extVar('crossform').resource('config', k8s.configMap('config', { version: version })) }\n"
+	if err := os.WriteFile(filepath.Join(module, "main.jsonnet"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := loadCommand(t, "testdata/jsonnet/new")
+	cmd.Path = "."
+
+	Imports = nil
+	if _, err := Execute(module, cmd); err == nil || !strings.Contains(err.Error(), "git imports are not supported") {
+		t.Fatalf("expected unsupported git imports, got %v", err)
+	}
+
+	Imports = testImportResolver{"https://example.com/libs.git@v1": libs}
+	res, err := Execute(module, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha := res.Imports["https://example.com/libs.git@v1"]; sha != "0123456789abcdef0123456789abcdef01234567" {
+		t.Fatalf("expected the pinned revision in imports, got %v", res.Imports)
+	}
+	config, ok := res.Desired["config"]
+	if !ok {
+		t.Fatalf("expected resource config, got errors %v", res.DesiredErrors)
+	}
+	if v, _, _ := unstructured.NestedString(config.Resource.Object, "data", "version"); v != "v1" {
+		t.Fatalf("expected imported version v1, got %q", v)
+	}
+	if n := config.Resource.GetName(); n != "config" {
+		t.Fatalf("expected name config imported from util.libsonnet, got %q", n)
+	}
+
+	for _, invalid := range []string{
+		"git+https://example.com/libs.git//k8s.libsonnet",
+		"git+https://example.com/libs.git@v1",
+		"git+ssh://git@example.com/libs.git//k8s.libsonnet",
+		"git+example.com/libs.git@v1//k8s.libsonnet",
+	} {
+		if _, err := parseGitImport(invalid); err == nil {
+			t.Errorf("expected invalid git import %s", invalid)
+		}
+	}
+	i, err := parseGitImport("git+ssh://git@example.com/libs.git@main//lib/k8s.libsonnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Url != "ssh://git@example.com/libs.git" || i.Revision != "main" || i.Path != "lib/k8s.libsonnet" {
+		t.Fatalf("unexpected git import %+v", i)
+	}
+}
+
+func TestCueCidrErrors(t *testing.T) {
+	logger.InitLog()
+	cmd := loadCommand(t, "testdata/cue/natives")
+	cmd.Path = "."
+	// an invalid argument fails the field using the helper, other fields of the module are evaluated
+	cases := map[string]string{
+		`(#cidrSubnet & {_prefix: "10.0.0.0/16", _newbits: 8, _netnum: 255}).out`: "",
+		`(#cidrSubnet & {_prefix: "10.0.0.0/16", _newbits: 8, _netnum: 256}).out`: "netnum 256 does not fit in 8 bits",
+		`(#cidrSubnet & {_prefix: "10.0.0.0/16", _newbits: 17, _netnum: 0}).out`:  "unable to extend prefix 10.0.0.0/16 by 17 bits",
+		`(#cidrSubnet & {_prefix: "2001:db8::/32", _newbits: 8, _netnum: 0}).out`: "prefix 2001:db8::/32 is not an IPv4 prefix",
+		`(#cidrHost & {_prefix: "10.0.2.0/24", _hostnum: -257}).out`:              "prefix 10.0.2.0/24 has no host -257",
+		`(#cidrHost & {_prefix: "10.0.2.0/24", _hostnum: 255}).out`:               "",
+	}
+	for expr, message := range cases {
+		module := t.TempDir()
+		src := "package test\n\nvalue: #output & {\n  _id: \"value\"\n  _value: " + expr + "\n}\n" +
+			"other: #output & {\n  _id: \"other\"\n  _value: \"other\"\n}\n"
+		if err := os.WriteFile(filepath.Join(module, "main.cue"), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		res, err := Execute(module, cmd)
+		if err != nil {
+			t.Fatalf("expected an evaluation of %s, got %v", expr, err)
+		}
+		if res.Outputs["other"] == nil {
+			t.Errorf("expected the other output with %s, errors: %v", expr, res.OutputsErrors)
+		}
+		if message == "" {
+			if res.Outputs["value"] == nil {
+				t.Errorf("expected a value of %s, got %v", expr, res.OutputsErrors["value"])
+			}
+			continue
+		}
+		if err := res.OutputsErrors["value"]; err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("expected an error of %s containing %q, got %v", expr, message, err)
+		}
+	}
+}
+
+func TestCueRegistry(t *testing.T) {
+	logger.InitLog()
+	defer func(registry, cacheDir string) { CueRegistry, CueCacheDir = registry, cacheDir }(CueRegistry, CueCacheDir)
+	backend := ocimem.New()
+	server := httptest.NewServer(ociserver.New(backend, nil))
+	defer server.Close()
+
+	var archive bytes.Buffer
+	w := zip.NewWriter(&archive)
+	for name, content := range map[string]string{
+		"cue.mod/module.cue": "module: \"example.com/tags@v0\"\n",
+		"tags.cue":           "package tags\n\n#tags: {\n\tEnvironment: string | *\"dev\"\n\tManagedBy:   \"crossform\"\n}\n",
+	} {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	version := module.MustNewVersion("example.com/tags@v0", "v0.1.0")
+	err := modregistry.NewClient(backend).PutModule(context.Background(), version, bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	files := map[string]string{
+		"cue.mod/module.cue": "module: \"example.com/infra@v0\"\ndeps: \"example.com/tags@v0\": v: \"v0.1.0\"\n",
+		"src/main.cue": "package test\n\nimport \"example.com/tags\"\n\n" +
+			"bucket: #resource & {\n\t_id: \"bucket\"\n\tapiVersion: \"s3.aws.upbound.io/v1beta1\"\n\tkind: \"Bucket\"\n" +
+			"\tmetadata: name: \"bucket\"\n\tspec: forProvider: \"tags\": tags.#tags\n}\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := loadCommand(t, "testdata/cue/imports")
+
+	CueRegistry = strings.TrimPrefix(server.URL, "http://")
+	CueCacheDir = t.TempDir()
+	// the cache keeps fetched modules read only
+	t.Cleanup(func() {
+		_ = filepath.WalkDir(CueCacheDir, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				_ = os.Chmod(path, 0755)
+			}
+			return nil
+		})
+	})
+	res, err := Execute(root, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, ok := res.Desired["bucket"]
+	if !ok {
+		t.Fatalf("expected resource bucket, got errors %v", res.DesiredErrors)
+	}
+	tags, _, _ := unstructured.NestedStringMap(bucket.Resource.Object, "spec", "forProvider", "tags")
+	if tags["Environment"] != "dev" || tags["ManagedBy"] != "crossform" {
+		t.Fatalf("expected tags of the registry module, got %v", tags)
+	}
+}
+
+// benchmarkModule makes a jsonnet module of n resources, every resource reads the status of the previous one.
+func benchmarkModule(b *testing.B, n int) string {
+	var src strings.Builder
+	src.WriteString("local lib = std.extVar('crossform');\nlocal observed = std.extVar('observed');\n{\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&src, `  r%[1]d: lib.resource('r%[1]d', {
+    apiVersion: 'v1',
+    kind: 'ConfigMap',
+    metadata: { name: 'r%[1]d' },
+    data: {
+      index: '%[1]d',
+      previous: std.toString(std.get(std.get(observed, 'r%[2]d', {}), 'status', {})),
+    },
+  }),
+`, i, i-1)
+	}
+	fmt.Fprintf(&src, "  count: lib.output('count', %d),\n}\n", n)
+
+	dir := b.TempDir()
+	if err := os.MkdirAll(dir+"/src", 0755); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/src/main.jsonnet", []byte(src.String()), 0644); err != nil {
+		b.Fatal(err)
+	}
+	return dir
+}
+
+func BenchmarkJsonnetExec(b *testing.B) {
+	logger.InitLog()
+	dir := benchmarkModule(b, 150)
+	for i := 0; i < b.N; i++ {
+		e, err := NewExecutor(loadCommand(b, "testdata/jsonnet/new"), dir)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := e.Exec(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestModuleErrors(t *testing.T) {
+	logger.InitLog()
+	cmd := loadCommand(t, "testdata/jsonnet/modules")
+	network := cmd.Observed["network"].Resource.Object
+	if err := unstructured.SetNestedField(network, "ERROR:\nsubnet overlaps", "status", "report", "resources", "vpc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := unstructured.SetNestedField(network, "ERROR:\nmodule test-jsonnet-modules-network-nat has errors: resource gateway: quota exceeded", "status", "report", "modules", "nat"); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewExecutor(cmd, "testdata/jsonnet/modules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := e.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res.ModulesErrors["eks"]; ok {
+		t.Fatalf("expected no errors of the unobserved module eks, got %v", res.ModulesErrors["eks"])
+	}
+	moduleErr, ok := res.ModulesErrors["network"]
+	if !ok {
+		t.Fatalf("expected errors of the module network, got %v", res.ModulesErrors)
+	}
+	expected := "module test-jsonnet-modules-network has errors: resource vpc: subnet overlaps; " +
+		"module nat: module test-jsonnet-modules-network-nat has errors: resource gateway: quota exceeded"
+	if moduleErr.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, moduleErr.Error())
+	}
+}
+
+func TestModuleOutputs(t *testing.T) {
+	logger.InitLog()
+	cmd := loadCommand(t, "testdata/jsonnet/modules")
+	network := cmd.Observed["network"].Resource.Object
+	if err := unstructured.SetNestedField(network, int64(3), "status", "outputs", "azCount"); err != nil {
+		t.Fatal(err)
+	}
+	declared := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"vpcId":          map[string]interface{}{"type": "string", "pattern": "^vpc-[0-9a-f]+$"},
+			"privateSubnets": map[string]interface{}{"type": "string"},
+		},
+	}
+	if err := unstructured.SetNestedMap(network, declared, "status", "outputsSchema"); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Execute("testdata/jsonnet/modules", cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Outputs["vpcId"] != "vpc-0123456789" {
+		t.Fatalf("expected the declared output vpcId of the child, got %v", res.Outputs)
+	}
+	// eks reads privateSubnets of the child, which does not match its declaration
+	if _, ok := res.Desired["eks"]; ok {
+		t.Fatal("expected no resource eks reading an invalid output of the child")
+	}
+	expected := "module test-jsonnet-modules-network has invalid outputs: output azCount is not declared; " +
+		"output privateSubnets does not match its schema"
+	if err := res.ModulesErrors["network"]; err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Fatalf("expected errors of outputs of the module network, got %v", err)
+	}
+	if res.OutputsSchema["properties"].(map[string]interface{})["vpcId"] == nil {
+		t.Fatalf("expected the declared output vpcId in the schema, got %v", res.OutputsSchema)
+	}
+}
+
+func TestOutputSchemaErrors(t *testing.T) {
+	logger.InitLog()
+	module := t.TempDir()
+	src := "local lib = std.extVar('crossform');\n" +
+		"{ vpcId: lib.output('vpcId', 'vpc_invalid', schema={ type: 'string', pattern: '^vpc-[0-9a-f]+$' }) }\n"
+	if err := os.WriteFile(filepath.Join(module, "main.jsonnet"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := loadCommand(t, "testdata/jsonnet/outputs")
+	cmd.Path = "."
+
+	res, err := Execute(module, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schemaErr *OutputSchemaError
+	if !errors.As(res.OutputsErrors["vpcId"], &schemaErr) {
+		t.Fatalf("expected a schema error of output vpcId, got %v", res.OutputsErrors["vpcId"])
+	}
+	if _, ok := res.Outputs["vpcId"]; ok {
+		t.Fatalf("expected no invalid output vpcId, got %v", res.Outputs["vpcId"])
+	}
+
+	// the previous value is kept, while the error is still reported
+	cmd.XR.Resource.Object["status"] = map[string]interface{}{
+		"outputs": map[string]interface{}{"vpcId": "vpc-0a"},
+	}
+	res, err = Execute(module, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Outputs["vpcId"] != "vpc-0a" {
+		t.Fatalf("expected the previous output vpc-0a, got %v", res.Outputs["vpcId"])
+	}
+	if !errors.As(res.OutputsErrors["vpcId"], &schemaErr) {
+		t.Fatalf("expected a schema error of output vpcId, got %v", res.OutputsErrors["vpcId"])
+	}
+}
+
+func TestSensitiveOutputErrors(t *testing.T) {
+	logger.InitLog()
+	module := t.TempDir()
+	src := "local lib = std.extVar('crossform');\n" +
+		"{ token: lib.output('token', 'tkn_invalid', schema={ type: 'string', enum: ['tkn_a', 'tkn_b'] }, sensitive=true) }\n"
+	if err := os.WriteFile(filepath.Join(module, "main.jsonnet"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := loadCommand(t, "testdata/jsonnet/outputs")
+	cmd.Path = "."
+	cmd.XR.ConnectionDetails = map[string][]byte{"token": []byte("tkn_a")}
+
+	res, err := Execute(module, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res.Outputs["token"]; ok {
+		t.Fatalf("expected no sensitive output in outputs, got %v", res.Outputs)
+	}
+	if res.ConnectionDetails["token"] != "tkn_a" {
+		t.Fatalf("expected the previous connection detail tkn_a, got %v", res.ConnectionDetails)
+	}
+	outputErr := res.OutputsErrors["token"]
+	if outputErr == nil || strings.Contains(outputErr.Error(), "tkn_") {
+		t.Fatalf("expected a redacted schema error of output token, got %v", outputErr)
+	}
+}
+
+func TestInputRefs(t *testing.T) {
+	logger.InitLog()
+	cmd := loadCommand(t, "testdata/jsonnet/secrets")
+	cmd.Requested = make(map[string][]resource.Extra)
+	e, err := NewExecutor(cmd, "testdata/jsonnet/secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := e.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res.Request[inputSecretsRequest+"db"]; !ok {
+		t.Fatalf("expected the request of the secret, got %v", res.Request)
+	}
+	if _, ok := res.Request[inputConfigMapsRequest+"defaults"]; !ok {
+		t.Fatalf("expected the request of the config map, got %v", res.Request)
+	}
+	if len(res.Outputs) > 0 || len(res.ConnectionDetails) > 0 {
+		t.Fatalf("expected no evaluation before secrets are requested, got %v", res.Outputs)
+	}
+
+	// an object without the labels is not found, the input waits for it and the module is evaluated
+	cmd = loadCommand(t, "testdata/jsonnet/secrets")
+	cmd.Requested[inputSecretsRequest+"db"] = nil
+	e, err = NewExecutor(cmd, "testdata/jsonnet/secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = e.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waiting := res.InputsDeferredBy["dbPassword"]; !strings.Contains(waiting, inputNameLabel+"=db") ||
+		!strings.Contains(waiting, inputNamespaceLabel+"=team-a") {
+		t.Fatalf("expected input dbPassword waiting for the labelled secret, got %q", waiting)
+	}
+	if len(res.Outputs) == 0 {
+		t.Fatalf("expected an evaluation without the secret, errors: %v", res.OutputsErrors)
+	}
+
+	cmd = loadCommand(t, "testdata/jsonnet/secrets")
+	inputs := cmd.XR.Resource.Object["spec"].(map[string]interface{})["inputs"].(map[string]interface{})
+	inputs["dbPassword"] = map[string]interface{}{
+		"valueFrom": map[string]interface{}{
+			"secretKeyRef": map[string]interface{}{"name": "db", "key": "user"},
+		},
+	}
+	e, err = NewExecutor(cmd, "testdata/jsonnet/secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = e.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.InputsErrors["dbPassword"]; err == nil || !strings.Contains(err.Error(), "has no key user") {
+		t.Fatalf("expected the missing key error of input dbPassword, got %v", err)
+	}
+	if _, ok := inputs["dbPassword"].(map[string]interface{}); !ok {
+		t.Fatal("expected the XR of the command to keep valueFrom")
+	}
+
+	cmd = loadCommand(t, "testdata/jsonnet/secrets")
+	inputs = cmd.XR.Resource.Object["spec"].(map[string]interface{})["inputs"].(map[string]interface{})
+	inputs["dbPassword"] = map[string]interface{}{
+		"valueFrom": map[string]interface{}{
+			"secretKeyRef": map[string]interface{}{"name": "db", "namespace": "team-b", "key": "password"},
+		},
+	}
+	e, err = NewExecutor(cmd, "testdata/jsonnet/secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = e.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.InputsErrors["dbPassword"]; err == nil || !strings.Contains(err.Error(), "limited to the claim namespace team-a") {
+		t.Fatalf("expected the namespace error of input dbPassword, got %v", err)
+	}
+	if _, ok := res.Request[inputSecretsRequest+"db"]; ok {
+		t.Fatal("expected no request of the secret of another namespace")
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	logger.InitLog()
+	dir := t.TempDir()
+	src := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  annotations:
+    crossform.io/id: config
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  annotations:
+    crossform.io/id: app
+data:
+  value: ${observed.config.data.value}
+---
+apiVersion: ec2.aws.upbound.io/v1beta1
+kind: VPC
+metadata:
+  name: a
+  annotations:
+    crossform.io/id: a
+spec:
+  peer: ${observed.b.status.id}
+---
+apiVersion: ec2.aws.upbound.io/v1beta1
+kind: VPC
+metadata:
+  name: b
+  annotations:
+    crossform.io/id: b
+spec:
+  peer: ${observed.a.status.id}
+---
+apiVersion: ec2.aws.upbound.io/v1beta1
+kind: Subnet
+metadata:
+  name: c
+  annotations:
+    crossform.io/id: c
+spec:
+  vpc: ${observed.a.status.id}
+`
+	if err := os.WriteFile(filepath.Join(dir, "main.yaml"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := loadCommand(t, "testdata/yaml/new")
+	cmd.Path = "."
+	observe := func(obj map[string]interface{}) resource.ObservedComposed {
+		return resource.ObservedComposed{Resource: &composed.Unstructured{Unstructured: unstructured.Unstructured{Object: obj}}}
+	}
+	cmd.Observed = map[resource.Name]resource.ObservedComposed{
+		// plain objects without conditions are ready once observed
+		"config": observe(map[string]interface{}{
+			"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{"name": "config"},
+			"data": map[string]interface{}{"value": "v"},
+		}),
+		"a": observe(map[string]interface{}{
+			"apiVersion": "ec2.aws.upbound.io/v1beta1", "kind": "VPC", "metadata": map[string]interface{}{"name": "a"},
+			"status": map[string]interface{}{
+				"id":         "vpc-a",
+				"conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": "False"}},
+			},
+		}),
+	}
+	cmd.Requested = make(map[string][]resource.Extra)
+
+	res, err := Execute(dir, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res.Desired["app"]; !ok {
+		t.Fatalf("app waits for a ConfigMap without conditions: %v", res.DeferredBy["app"])
+	}
+	for _, id := range []string{"a", "b"} {
+		if err := res.DesiredErrors[id]; err == nil || !strings.Contains(err.Error(), "dependency cycle") {
+			t.Fatalf("expected the dependency cycle error of %s, got %v", id, err)
+		}
+	}
+	if !strings.Contains(res.DesiredErrors["a"].Error(), "a -> b -> a") {
+		t.Fatalf("unexpected cycle %s", res.DesiredErrors["a"])
+	}
+	// a keeps its observed state, b does not exist
+	if _, ok := res.Desired["a"]; !ok {
+		t.Fatal("a in a cycle lost its observed state")
+	}
+	if _, ok := res.Desired["b"]; ok {
+		t.Fatal("b in a cycle is desired")
+	}
+	if strings.Join(res.Deferred, ",") != "c" || strings.Join(res.DeferredBy["c"], ",") != "a" {
+		t.Fatalf("expected c waiting for a, got %v %v", res.Deferred, res.DeferredBy)
+	}
+}
+
+func TestDeferredDependencies(t *testing.T) {
+	logger.InitLog()
+	dir := t.TempDir()
+	src := "local lib = std.extVar('crossform');\n" +
+		"local test1 = lib.resource('test1', { apiVersion: 'kubernetes.crossplane.io/v1alpha2', kind: 'Object', metadata: { name: 'first' } });\n" +
+		"local test2 = lib.resource('test2', { apiVersion: 'kubernetes.crossplane.io/v1alpha2', kind: 'Object', metadata: { name: 'second-' + test1.status.atProvider.manifest.kind } });\n" +
+		"{ test1: test1, test2: test2 }\n"
+	if err := os.WriteFile(dir+"/main.jsonnet", []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := loadCommand(t, "testdata/jsonnet/new")
+	cmd.Path = "."
+	e, err := NewExecutor(cmd, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := e.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := res.Dependencies["test2"]
+	if len(deps) != 1 || deps[0].Source != dependencySourceObserved || deps[0].Id != "test1" {
+		t.Fatalf("expected the dependency of test2 on observed test1, got %v", deps)
+	}
+	if _, ok := res.Desired["test2"]; !ok {
+		t.Fatalf("test2 of the ready test1 is deferred by %v", res.DeferredBy["test2"])
+	}
+
+	// the dependent waits for the resource which is not ready
+	status := cmd.Observed["test1"].Resource.Object["status"].(map[string]interface{})
+	status["conditions"] = []interface{}{map[string]interface{}{"type": "Ready", "status": "False"}}
+	e, err = NewExecutor(cmd, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = e.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res.Desired["test2"]; ok || len(res.DeferredBy["test2"]) != 1 || res.DeferredBy["test2"][0] != "test1" {
+		t.Fatalf("expected test2 deferred by test1, got %v", res.DeferredBy)
+	}
+}
@@ -0,0 +1,178 @@
+package executor
+
+import (
+	"fmt"
+	"github.com/crossplane/function-sdk-go/resource"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	graphNodeOk       = "ok"
+	graphNodeDeferred = "deferred"
+	graphNodeError    = "error"
+	graphNodeMissing  = "missing"
+)
+
+// Graph is the dependency graph of a module evaluation. Edges point from a dependency to its dependent.
+type Graph struct {
+	Module string       `json:"module"`
+	Nodes  []*GraphNode `json:"nodes"`
+	Edges  []*GraphEdge `json:"edges"`
+}
+
+type GraphNode struct {
+	Id     string `json:"id"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Ready  string `json:"ready,omitempty"`
+	Synced string `json:"synced,omitempty"`
+}
+
+type GraphEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Blocking bool   `json:"blocking,omitempty"`
+}
+
+func graphNodeId(typ, name string) string {
+	return typ + "/" + name
+}
+
+func dependencyNodeId(d *Dependency) string {
+	switch d.Source {
+	case dependencySourceRequested:
+		return graphNodeId("request", d.Id)
+	case dependencySourceInput:
+		return graphNodeId("input", d.Id)
+	}
+	return graphNodeId("resource", d.Id)
+}
+
+// buildGraph makes the graph of all resources, requests, inputs and outputs of the result.
+// Edges into deferred resources are blocking when the dependency is the reason of the deferral.
+func (e *Executor) buildGraph(result *ExecResult) *Graph {
+	nodes := make(map[string]*GraphNode)
+	addNode := func(typ, name, status string) *GraphNode {
+		n := &GraphNode{Id: graphNodeId(typ, name), Type: typ, Name: name, Status: status}
+		if typ == "resource" {
+			if o, ok := e.cmd.Observed[resource.Name(name)]; ok {
+				n.Ready = conditionStatus(o.Resource.Object, "Ready")
+				n.Synced = conditionStatus(o.Resource.Object, "Synced")
+			}
+		}
+		nodes[n.Id] = n
+		return n
+	}
+
+	for k := range result.Desired {
+		addNode("resource", string(k), graphNodeOk)
+	}
+	for _, k := range result.Deferred {
+		addNode("resource", k, graphNodeDeferred)
+	}
+	for k := range result.DesiredErrors {
+		addNode("resource", k, graphNodeError)
+	}
+	for k := range result.Request {
+		addNode("request", k, graphNodeOk)
+	}
+	for k := range result.RequestErrors {
+		addNode("request", k, graphNodeError)
+	}
+	for k := range result.Inputs {
+		addNode("input", k, graphNodeOk)
+	}
+	for k := range result.InputsErrors {
+		addNode("input", k, graphNodeError)
+	}
+	for k := range result.Outputs {
+		addNode("output", k, graphNodeOk)
+	}
+	for _, k := range result.SensitiveOutputs {
+		addNode("output", k, graphNodeOk)
+	}
+	for k := range result.OutputsErrors {
+		addNode("output", k, graphNodeError)
+	}
+
+	edges := make([]*GraphEdge, 0)
+	addEdges := func(to string, deps []*Dependency, blocking []string) {
+		for _, d := range deps {
+			from := dependencyNodeId(d)
+			if _, ok := nodes[from]; !ok {
+				typ, name, _ := strings.Cut(from, "/")
+				addNode(typ, name, graphNodeMissing)
+			}
+			edges = append(edges, &GraphEdge{
+				From:     from,
+				To:       to,
+				Blocking: slices.Contains(blocking, d.String()),
+			})
+		}
+	}
+	for k, deps := range result.Dependencies {
+		addEdges(graphNodeId("resource", k), deps, result.DeferredBy[k])
+	}
+	for k, deps := range result.OutputsDependencies {
+		addEdges(graphNodeId("output", k), deps, nil)
+	}
+
+	g := &Graph{
+		Module: e.cmd.ModuleName,
+		Nodes:  make([]*GraphNode, 0, len(nodes)),
+		Edges:  edges,
+	}
+	for _, n := range nodes {
+		g.Nodes = append(g.Nodes, n)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		return g.Nodes[i].Id < g.Nodes[j].Id
+	})
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].To != g.Edges[j].To {
+			return g.Edges[i].To < g.Edges[j].To
+		}
+		return g.Edges[i].From < g.Edges[j].From
+	})
+	return g
+}
+
+var graphNodeShapes = map[string]string{
+	"resource": "box",
+	"request":  "folder",
+	"input":    "invhouse",
+	"output":   "house",
+}
+
+var graphNodeColors = map[string]string{
+	graphNodeOk:       "darkgreen",
+	graphNodeDeferred: "orange",
+	graphNodeError:    "red",
+	graphNodeMissing:  "gray",
+}
+
+// DOT renders the graph in the graphviz format.
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", g.Module)
+	b.WriteString("  rankdir=LR;\n")
+	for _, n := range g.Nodes {
+		label := fmt.Sprintf("%s %s\n%s", n.Type, n.Name, strings.ToUpper(n.Status))
+		if n.Ready != "" || n.Synced != "" {
+			label += fmt.Sprintf("\nReady=%s Synced=%s", n.Ready, n.Synced)
+		}
+		fmt.Fprintf(&b, "  %q [label=%q, shape=%s, color=%s];\n", n.Id, label, graphNodeShapes[n.Type], graphNodeColors[n.Status])
+	}
+	for _, e := range g.Edges {
+		if e.Blocking {
+			fmt.Fprintf(&b, "  %q -> %q [color=red, style=bold, label=\"blocking\"];\n", e.From, e.To)
+		} else {
+			fmt.Fprintf(&b, "  %q -> %q;\n", e.From, e.To)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
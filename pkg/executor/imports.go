@@ -0,0 +1,190 @@
+package executor
+
+import (
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ImportResolver checks out git repositories imported by modules.
+type ImportResolver interface {
+	// ResolveImport returns a directory with the tree of the revision of the repository and the commit sha
+	// of the revision. The directory must not change while it is used by evaluations. The importer is the command
+	// of the evaluated module, the imported repository is kept while the repository of the module is used.
+	ResolveImport(importer *ExecCommand, url, revision string) (string, string, error)
+}
+
+// Imports resolves git imports of jsonnet modules, modules importing git repositories fail without it.
+var Imports ImportResolver
+
+// gitImportPrefix starts jsonnet imports of files of git repositories,
+// like git+https://github.com/org/libs.git@v1.0.0//k8s.libsonnet
+const gitImportPrefix = "git+"
+
+type gitImport struct {
+	Url      string
+	Revision string
+	Path     string
+}
+
+// repository returns the key of the imported revision of the repository.
+func (i *gitImport) repository() string {
+	return i.Url + "@" + i.Revision
+}
+
+// parseGitImport parses git+<url>@<revision>//<path> import paths.
+func parseGitImport(importedPath string) (*gitImport, error) {
+	s := strings.TrimPrefix(importedPath, gitImportPrefix)
+	scheme := strings.Index(s, "://")
+	if scheme < 0 {
+		return nil, errors.Errorf("invalid git import %s, the repository url has no scheme", importedPath)
+	}
+	sep := strings.Index(s[scheme+3:], "//")
+	if sep < 0 {
+		return nil, errors.Errorf("invalid git import %s, expected git+<url>@<revision>//<path>", importedPath)
+	}
+	repository, path := s[:scheme+3+sep], s[scheme+3+sep+2:]
+	at := strings.LastIndex(repository, "@")
+	if at < 0 || at < scheme+3+strings.Index(repository[scheme+3:], "/") {
+		return nil, errors.Errorf("invalid git import %s, the revision is required", importedPath)
+	}
+	i := &gitImport{Url: repository[:at], Revision: repository[at+1:], Path: path}
+	if i.Revision == "" || i.Path == "" {
+		return nil, errors.Errorf("invalid git import %s, expected git+<url>@<revision>//<path>", importedPath)
+	}
+	return i, nil
+}
+
+// resolvedImport is a checked out revision of an imported git repository.
+type resolvedImport struct {
+	Dir string `json:"dir"`
+	Sha string `json:"sha"`
+}
+
+// resolveImports finds git imports of jsonnet files of the module and of the files they import, and checks
+// the repositories out by Imports. Import paths of jsonnet are string literals, so they are found without
+// an evaluation. Files which can not be read or parsed are skipped, their evaluation reports the errors.
+func resolveImports(path string, cmd *ExecCommand) (map[string]*resolvedImport, error) {
+	files, err := filepath.Glob(filepath.Join(path, "*.jsonnet"))
+	if err != nil {
+		return nil, err
+	}
+	s := importScanner{
+		importer: cmd,
+		resolved: make(map[string]*resolvedImport),
+		scanned:  make(map[string]bool),
+	}
+	for _, file := range files {
+		if err := s.scan(file); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.resolved) == 0 {
+		return nil, nil
+	}
+	return s.resolved, nil
+}
+
+type importScanner struct {
+	importer *ExecCommand
+	resolved map[string]*resolvedImport
+	scanned  map[string]bool
+}
+
+func (s *importScanner) scan(file string) error {
+	if s.scanned[file] {
+		return nil
+	}
+	s.scanned[file] = true
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	node, err := jsonnet.SnippetToAST(file, string(data))
+	if err != nil {
+		return nil
+	}
+
+	var walkErr error
+	var walk func(node ast.Node)
+	walk = func(node ast.Node) {
+		var importedPath string
+		code := false
+		switch n := node.(type) {
+		case *ast.Import:
+			importedPath, code = n.File.Value, true
+		case *ast.ImportStr:
+			importedPath = n.File.Value
+		case *ast.ImportBin:
+			importedPath = n.File.Value
+		}
+		if importedPath != "" && walkErr == nil {
+			walkErr = s.scanImport(file, importedPath, code)
+		}
+		for _, child := range toolutils.Children(node) {
+			walk(child)
+		}
+	}
+	walk(node)
+	return walkErr
+}
+
+// scanImport resolves a git import and scans imported code for further imports.
+func (s *importScanner) scanImport(file, importedPath string, code bool) error {
+	if !strings.HasPrefix(importedPath, gitImportPrefix) {
+		if !code {
+			return nil
+		}
+		if !filepath.IsAbs(importedPath) {
+			importedPath = filepath.Join(filepath.Dir(file), importedPath)
+		}
+		return s.scan(importedPath)
+	}
+
+	i, err := parseGitImport(importedPath)
+	if err != nil {
+		return err
+	}
+	r, ok := s.resolved[i.repository()]
+	if !ok {
+		if Imports == nil {
+			return errors.Errorf("unable to import %s, git imports are not supported here", importedPath)
+		}
+		dir, sha, err := Imports.ResolveImport(s.importer, i.Url, i.Revision)
+		if err != nil {
+			return errors.Wrapf(err, "unable to resolve repository %s of import %s", i.repository(), importedPath)
+		}
+		r = &resolvedImport{Dir: dir, Sha: sha}
+		s.resolved[i.repository()] = r
+	}
+	if !code {
+		return nil
+	}
+	return s.scan(filepath.Join(r.Dir, filepath.FromSlash(i.Path)))
+}
+
+// jsonnetImporter imports files of the file system and files of resolved git repositories.
+// Relative imports of imported git files are resolved within their checkouts.
+type jsonnetImporter struct {
+	files   jsonnet.FileImporter
+	imports map[string]*resolvedImport
+}
+
+func (i *jsonnetImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	if !strings.HasPrefix(importedPath, gitImportPrefix) {
+		return i.files.Import(importedFrom, importedPath)
+	}
+	gi, err := parseGitImport(importedPath)
+	if err != nil {
+		return jsonnet.Contents{}, "", err
+	}
+	r, ok := i.imports[gi.repository()]
+	if !ok {
+		return jsonnet.Contents{}, "", errors.Errorf("repository %s of import %s is not resolved", gi.repository(), importedPath)
+	}
+	return i.files.Import("", filepath.Join(r.Dir, filepath.FromSlash(gi.Path)))
+}
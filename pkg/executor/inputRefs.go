@@ -0,0 +1,158 @@
+package executor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	fnv1beta1 "github.com/crossplane/function-sdk-go/proto/v1beta1"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"strings"
+)
+
+// Inputs of XRs read values of Secrets and ConfigMaps of the claim namespace by valueFrom. Extra resources can be
+// selected by labels only, so every referenced object is requested by labels carrying its name and namespace.
+const (
+	inputSecretsRequest    = "crossform.io/input-secret/"
+	inputConfigMapsRequest = "crossform.io/input-configmap/"
+	inputNameLabel         = "crossform.io/input-name"
+	inputNamespaceLabel    = "crossform.io/input-namespace"
+	claimNamespaceLabel    = "crossplane.io/claim-namespace"
+)
+
+type inputKeyRef struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+}
+
+type inputValueFrom struct {
+	SecretKeyRef    *inputKeyRef `json:"secretKeyRef"`
+	ConfigMapKeyRef *inputKeyRef `json:"configMapKeyRef"`
+}
+
+func isInputsRequest(id string) bool {
+	return strings.HasPrefix(id, inputSecretsRequest) || strings.HasPrefix(id, inputConfigMapsRequest)
+}
+
+// resolveInputRefs replaces inputs of the XR which have valueFrom by values of the referenced keys. The XR of
+// the command is copied, so that the values are not written back to the XR. The referenced kinds are requested
+// while Crossplane has not provided them yet, the module is not evaluated till then.
+func (e *Executor) resolveInputRefs() {
+	inputs, ok, _ := unstructured.NestedMap(e.cmd.XR.Resource.Object, "spec", "inputs")
+	if !ok {
+		return
+	}
+	changed := false
+	for name, v := range inputs {
+		m, ok := v.(map[string]interface{})
+		if !ok || len(m) != 1 || m["valueFrom"] == nil {
+			continue
+		}
+		changed = true
+		delete(inputs, name)
+
+		var from inputValueFrom
+		j, err := json.Marshal(m["valueFrom"])
+		if err == nil {
+			err = json.Unmarshal(j, &from)
+		}
+		if err != nil {
+			e.inputRefsErrors[name] = errors.Wrapf(err, "invalid valueFrom of input %s", name)
+			continue
+		}
+		var value, missing string
+		var requested bool
+		switch {
+		case from.SecretKeyRef != nil && from.ConfigMapKeyRef == nil:
+			value, missing, requested, err = e.lookupInputRef(from.SecretKeyRef, inputSecretsRequest, "Secret")
+		case from.ConfigMapKeyRef != nil && from.SecretKeyRef == nil:
+			value, missing, requested, err = e.lookupInputRef(from.ConfigMapKeyRef, inputConfigMapsRequest, "ConfigMap")
+		default:
+			err = errors.Errorf("valueFrom of input %s must have either secretKeyRef or configMapKeyRef", name)
+		}
+		switch {
+		case err != nil:
+			e.inputRefsErrors[name] = err
+		case missing != "":
+			e.inputRefsDeferred[name] = missing
+			e.inputRefsPending = e.inputRefsPending || !requested
+		default:
+			inputs[name] = value
+		}
+	}
+	if !changed {
+		return
+	}
+
+	xr := &resource.Composite{
+		Resource:          &composite.Unstructured{Unstructured: *e.cmd.XR.Resource.Unstructured.DeepCopy()},
+		ConnectionDetails: e.cmd.XR.ConnectionDetails,
+	}
+	if err := unstructured.SetNestedMap(xr.Resource.Object, inputs, "spec", "inputs"); err != nil {
+		e.log.Error().Err(err).Msg("unable to set resolved inputs")
+		return
+	}
+	cmd := *e.cmd
+	cmd.XR = xr
+	e.cmd = &cmd
+}
+
+// lookupInputRef requests the referenced object and returns the value of its key. While the object is not found
+// missing describes it with the labels it is selected by, requested is false while the object is not requested yet.
+// Objects are read from the claim namespace only, so that claims do not read other namespaces.
+func (e *Executor) lookupInputRef(ref *inputKeyRef, request, kind string) (value, missing string, requested bool, err error) {
+	if ref.Name == "" || ref.Key == "" {
+		return "", "", false, errors.Errorf("name and key of the %s reference are required", kind)
+	}
+	namespace := e.cmd.XR.Resource.GetLabels()[claimNamespaceLabel]
+	if namespace == "" {
+		return "", "", false, errors.Errorf("%s %s is not readable, the XR has no claim", kind, ref.Name)
+	}
+	if ref.Namespace != "" && ref.Namespace != namespace {
+		return "", "", false, errors.Errorf("%s %s/%s is not readable, references are limited to the claim namespace %s",
+			kind, ref.Namespace, ref.Name, namespace)
+	}
+	request += ref.Name
+	e.inputRequests[request] = &fnv1beta1.ResourceSelector{
+		ApiVersion: "v1",
+		Kind:       kind,
+		Match: &fnv1beta1.ResourceSelector_MatchLabels{
+			MatchLabels: &fnv1beta1.MatchLabels{
+				Labels: map[string]string{inputNameLabel: ref.Name, inputNamespaceLabel: namespace},
+			},
+		},
+	}
+	missing = fmt.Sprintf("%s %s/%s labelled %s=%s and %s=%s", kind, namespace, ref.Name,
+		inputNameLabel, ref.Name, inputNamespaceLabel, namespace)
+	extras, requested := e.cmd.Requested[request]
+	if !requested {
+		return "", missing, false, nil
+	}
+
+	var obj *unstructured.Unstructured
+	for _, extra := range extras {
+		if extra.Resource != nil && extra.Resource.GetName() == ref.Name && extra.Resource.GetNamespace() == namespace {
+			obj = extra.Resource
+			break
+		}
+	}
+	if obj == nil {
+		return "", missing, true, nil
+	}
+	data, _, _ := unstructured.NestedStringMap(obj.Object, "data")
+	value, ok := data[ref.Key]
+	if !ok {
+		return "", "", true, errors.Errorf("%s %s/%s has no key %s", kind, namespace, ref.Name, ref.Key)
+	}
+	if kind == "Secret" {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", "", true, errors.Wrapf(err, "unable to decode key %s of %s %s/%s", ref.Key, kind, namespace, ref.Name)
+		}
+		value = string(decoded)
+	}
+	return value, "", true, nil
+}
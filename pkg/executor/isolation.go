@@ -0,0 +1,320 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"crossform.io/pkg/logger"
+	"encoding/json"
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	fnv1beta1 "github.com/crossplane/function-sdk-go/proto/v1beta1"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composed"
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+type IsolationMode string
+
+const (
+	// IsolationNone evaluates modules in the process of the repository server
+	IsolationNone IsolationMode = "none"
+	// IsolationProcess evaluates every module in a child process with limited memory
+	IsolationProcess IsolationMode = "process"
+	// IsolationSandbox is IsolationProcess with own user, network and mount namespaces of the child,
+	// it has no network and a read only view of the file system. It is supported on linux only.
+	IsolationSandbox IsolationMode = "sandbox"
+)
+
+// IsolationConfig configures evaluations in child processes.
+type IsolationConfig struct {
+	Mode IsolationMode
+	// Command starts a child process serving one evaluation with ServeIsolated
+	Command []string
+}
+
+var Isolation = IsolationConfig{Mode: IsolationNone}
+
+// isolationGrace is the time a child gets to report an exceeded timeout before it is killed.
+var isolationGrace = time.Second
+
+// runtimeFatalExitCode is the exit code of a child stopped by a fatal error of the go runtime. Panics of
+// evaluations are reported in the response, so under a memory budget the fatal error is an exhausted rlimit.
+const runtimeFatalExitCode = 2
+
+// isolationHeadroom is added to the memory budget of a child for the runtime and the executable.
+var isolationHeadroom int64 = 256 << 20
+
+type isolatedRequest struct {
+	Path     string                     `json:"path"`
+	Command  *ExecCommand               `json:"command"`
+	Limits   Limits                     `json:"limits"`
+	Imports  map[string]*resolvedImport `json:"imports,omitempty"`
+	Mode     IsolationMode              `json:"mode"`
+	LogLevel zerolog.Level              `json:"logLevel"`
+}
+
+type isolatedError struct {
+	Message          string                 `json:"message"`
+	Budget           *BudgetExceededError   `json:"budget,omitempty"`
+	InputsValidation *InputsValidationError `json:"inputsValidation,omitempty"`
+}
+
+type isolatedDesired struct {
+	Resource map[string]interface{} `json:"resource"`
+	Ready    resource.Ready         `json:"ready"`
+}
+
+// isolatedResult is ExecResult encoded for the transfer from a child process, errors keep their messages only.
+type isolatedResult struct {
+	Desired               map[resource.Name]*isolatedDesired `json:"desired"`
+	DesiredErrors         map[string]*isolatedError          `json:"desiredErrors"`
+	Deferred              []string                           `json:"deferred"`
+	DeferredBy            map[string][]string                `json:"deferredBy"`
+	Dependencies          map[string][]*Dependency           `json:"dependencies"`
+	Request               map[string]json.RawMessage         `json:"request"`
+	RequestErrors         map[string]*isolatedError          `json:"requestErrors"`
+	Outputs               map[string]interface{}             `json:"outputs"`
+	OutputsErrors         map[string]*isolatedError          `json:"outputsErrors"`
+	OutputsDependencies   map[string][]*Dependency           `json:"outputsDependencies"`
+	Inputs                map[string]string                  `json:"inputs"`
+	InputsErrors          map[string]*isolatedError          `json:"inputsErrors"`
+	InputsDeferredBy      map[string]string                  `json:"inputsDeferredBy,omitempty"`
+	InputsValidationError *isolatedError                     `json:"inputsValidationError,omitempty"`
+	Graph                 *Graph                             `json:"graph,omitempty"`
+	ModulesErrors         map[string]*isolatedError          `json:"modulesErrors,omitempty"`
+	ConnectionDetails     map[string]string                  `json:"connectionDetails,omitempty"`
+	SensitiveOutputs      []string                           `json:"sensitiveOutputs,omitempty"`
+	SensitiveInputs       []string                           `json:"sensitiveInputs,omitempty"`
+	OutputsSchema         map[string]interface{}             `json:"outputsSchema,omitempty"`
+	Imports               map[string]string                  `json:"imports,omitempty"`
+}
+
+type isolatedResponse struct {
+	Result *isolatedResult `json:"result,omitempty"`
+	Error  *isolatedError  `json:"error,omitempty"`
+}
+
+func encodeError(err error) *isolatedError {
+	if err == nil {
+		return nil
+	}
+	res := &isolatedError{Message: err.Error()}
+	var budgetErr *BudgetExceededError
+	if errors.As(err, &budgetErr) {
+		res.Budget = budgetErr
+	}
+	var validationErr *InputsValidationError
+	if errors.As(err, &validationErr) {
+		res.InputsValidation = validationErr
+	}
+	return res
+}
+
+func (e *isolatedError) decode() error {
+	switch {
+	case e == nil:
+		return nil
+	case e.Budget != nil:
+		return e.Budget
+	case e.InputsValidation != nil:
+		return e.InputsValidation
+	}
+	return errors.New(e.Message)
+}
+
+func encodeErrors(errs map[string]error) map[string]*isolatedError {
+	res := make(map[string]*isolatedError, len(errs))
+	for k, v := range errs {
+		res[k] = encodeError(v)
+	}
+	return res
+}
+
+func decodeErrors(errs map[string]*isolatedError) map[string]error {
+	res := make(map[string]error, len(errs))
+	for k, v := range errs {
+		res[k] = v.decode()
+	}
+	return res
+}
+
+func encodeResult(r *ExecResult) (*isolatedResult, error) {
+	res := &isolatedResult{
+		Desired:               make(map[resource.Name]*isolatedDesired, len(r.Desired)),
+		DesiredErrors:         encodeErrors(r.DesiredErrors),
+		Deferred:              r.Deferred,
+		DeferredBy:            r.DeferredBy,
+		Dependencies:          r.Dependencies,
+		Request:               make(map[string]json.RawMessage, len(r.Request)),
+		RequestErrors:         encodeErrors(r.RequestErrors),
+		Outputs:               r.Outputs,
+		OutputsErrors:         encodeErrors(r.OutputsErrors),
+		OutputsDependencies:   r.OutputsDependencies,
+		Inputs:                r.Inputs,
+		InputsErrors:          encodeErrors(r.InputsErrors),
+		InputsDeferredBy:      r.InputsDeferredBy,
+		InputsValidationError: encodeError(r.InputsValidationError),
+		Graph:                 r.Graph,
+		ConnectionDetails:     r.ConnectionDetails,
+		SensitiveOutputs:      r.SensitiveOutputs,
+		SensitiveInputs:       r.SensitiveInputs,
+		OutputsSchema:         r.OutputsSchema,
+		Imports:               r.Imports,
+	}
+	if r.ModulesErrors != nil {
+		res.ModulesErrors = encodeErrors(r.ModulesErrors)
+	}
+	for k, v := range r.Desired {
+		res.Desired[k] = &isolatedDesired{Resource: v.Resource.Object, Ready: v.Ready}
+	}
+	for k, v := range r.Request {
+		j, err := protojson.Marshal(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to marshal request %s", k)
+		}
+		res.Request[k] = j
+	}
+	return res, nil
+}
+
+func (r *isolatedResult) decode() (*ExecResult, error) {
+	res := NewExecResult()
+	for k, v := range r.Desired {
+		res.Desired[k] = &resource.DesiredComposed{
+			Resource: &composed.Unstructured{Unstructured: unstructured.Unstructured{Object: v.Resource}},
+			Ready:    v.Ready,
+		}
+	}
+	res.DesiredErrors = decodeErrors(r.DesiredErrors)
+	if r.Deferred != nil {
+		res.Deferred = r.Deferred
+	}
+	if r.DeferredBy != nil {
+		res.DeferredBy = r.DeferredBy
+	}
+	if r.Dependencies != nil {
+		res.Dependencies = r.Dependencies
+	}
+	for k, v := range r.Request {
+		selector := &fnv1beta1.ResourceSelector{}
+		if err := protojson.Unmarshal(v, selector); err != nil {
+			return nil, errors.Wrapf(err, "unable to unmarshal request %s", k)
+		}
+		res.Request[k] = selector
+	}
+	res.RequestErrors = decodeErrors(r.RequestErrors)
+	if r.Outputs != nil {
+		res.Outputs = r.Outputs
+	}
+	res.OutputsErrors = decodeErrors(r.OutputsErrors)
+	if r.OutputsDependencies != nil {
+		res.OutputsDependencies = r.OutputsDependencies
+	}
+	if r.Inputs != nil {
+		res.Inputs = r.Inputs
+	}
+	res.InputsErrors = decodeErrors(r.InputsErrors)
+	res.InputsDeferredBy = r.InputsDeferredBy
+	res.InputsValidationError = r.InputsValidationError.decode()
+	res.Graph = r.Graph
+	if r.ModulesErrors != nil {
+		res.ModulesErrors = decodeErrors(r.ModulesErrors)
+	}
+	res.ConnectionDetails = r.ConnectionDetails
+	res.SensitiveOutputs = r.SensitiveOutputs
+	res.SensitiveInputs = r.SensitiveInputs
+	res.OutputsSchema = r.OutputsSchema
+	res.Imports = r.Imports
+	return res, nil
+}
+
+// executeIsolated evaluates the module in a child process started by Isolation.Command. The child is killed
+// when it does not report an exceeded timeout in time.
+func executeIsolated(path string, cmd *ExecCommand, limits Limits, imports map[string]*resolvedImport, mode IsolationMode) (*ExecResult, error) {
+	if len(Isolation.Command) == 0 {
+		return nil, errors.New("isolation command is not configured")
+	}
+	req, err := json.Marshal(&isolatedRequest{
+		Path:     path,
+		Command:  cmd,
+		Limits:   limits,
+		Imports:  imports,
+		Mode:     mode,
+		LogLevel: zerolog.GlobalLevel(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to marshal isolated request")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if limits.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), limits.Timeout+isolationGrace)
+	}
+	defer cancel()
+	c := exec.CommandContext(ctx, Isolation.Command[0], Isolation.Command[1:]...)
+	attr, err := isolationAttr(mode)
+	if err != nil {
+		return nil, err
+	}
+	c.SysProcAttr = attr
+	c.Stdin = bytes.NewReader(req)
+	var stdout bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = os.Stderr
+
+	// the child is killed by Pdeathsig when the thread which started it exits, not the process
+	runtime.LockOSThread()
+	runErr := c.Run()
+	runtime.UnlockOSThread()
+	var rsp isolatedResponse
+	if err := json.Unmarshal(stdout.Bytes(), &rsp); err != nil || rsp.Result == nil && rsp.Error == nil {
+		switch {
+		case ctx.Err() == context.DeadlineExceeded:
+			return nil, &BudgetExceededError{Budget: "timeout", Limit: limits.Timeout.String()}
+		case limits.MaxMemory > 0 && c.ProcessState != nil && c.ProcessState.ExitCode() == runtimeFatalExitCode:
+			return nil, memoryExceededError(limits.MaxMemory)
+		case runErr != nil:
+			return nil, errors.Wrap(runErr, "isolated evaluation failed")
+		}
+		return nil, errors.New("isolated evaluation returned no result")
+	}
+	if rsp.Error != nil {
+		return nil, rsp.Error.decode()
+	}
+	return rsp.Result.decode()
+}
+
+// ServeIsolated serves one evaluation in a child process, it reads the request from r and writes the response to w.
+// Errors of the evaluation are written to w, the returned error means that the response can not be written.
+func ServeIsolated(r io.Reader, w io.Writer) error {
+	var req isolatedRequest
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		return errors.Wrap(err, "unable to unmarshal isolated request")
+	}
+	zerolog.SetGlobalLevel(req.LogLevel)
+	log := logger.GetLogger("isolation")
+
+	var rsp isolatedResponse
+	if err := enterIsolation(req.Path, req.Mode, req.Limits); err != nil {
+		log.Error().Err(err).Msg("unable to isolate evaluation")
+		rsp.Error = encodeError(errors.Wrap(err, "unable to isolate evaluation"))
+		return json.NewEncoder(w).Encode(&rsp)
+	}
+	result, err := executeWithin(req.Path, req.Command, req.Limits, req.Imports)
+	if err != nil {
+		rsp.Error = encodeError(err)
+		return json.NewEncoder(w).Encode(&rsp)
+	}
+	rsp.Result, err = encodeResult(result)
+	if err != nil {
+		rsp.Result = nil
+		rsp.Error = encodeError(err)
+	}
+	return json.NewEncoder(w).Encode(&rsp)
+}
@@ -0,0 +1,120 @@
+package executor
+
+import (
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// isolationAttr starts sandboxed children in own user, network and mount namespaces.
+func isolationAttr(mode IsolationMode) (*syscall.SysProcAttr, error) {
+	if mode != IsolationSandbox {
+		return &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}, nil
+	}
+	return &syscall.SysProcAttr{
+		Pdeathsig:  syscall.SIGKILL,
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+		},
+	}, nil
+}
+
+// enterIsolation limits memory of the child and makes every mount read only in a sandbox, the checkout
+// and the rest of the file system alike.
+func enterIsolation(path string, mode IsolationMode, limits Limits) error {
+	if limits.MaxMemory > 0 {
+		max := uint64(limits.MaxMemory + isolationHeadroom)
+		if err := syscall.Setrlimit(syscall.RLIMIT_DATA, &syscall.Rlimit{Cur: max, Max: max}); err != nil {
+			return errors.Wrap(err, "unable to limit memory")
+		}
+	}
+	if mode != IsolationSandbox {
+		return nil
+	}
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return errors.Wrap(err, "unable to make mounts private")
+	}
+	mounts, err := readMounts()
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		// flags of the original mount are locked in a user namespace and have to be kept by the remount
+		flags := uintptr(syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY) | m.flags
+		if err := syscall.Mount("", m.path, "", flags, ""); err != nil {
+			return errors.Wrapf(err, "unable to make %s read only", m.path)
+		}
+	}
+	return nil
+}
+
+type mount struct {
+	path  string
+	flags uintptr
+}
+
+// mountFlags are options of /proc/self/mountinfo locked in a user namespace.
+var mountFlags = map[string]uintptr{
+	"nosuid":      syscall.MS_NOSUID,
+	"nodev":       syscall.MS_NODEV,
+	"noexec":      syscall.MS_NOEXEC,
+	"noatime":     syscall.MS_NOATIME,
+	"nodiratime":  syscall.MS_NODIRATIME,
+	"relatime":    syscall.MS_RELATIME,
+	"strictatime": syscall.MS_STRICTATIME,
+}
+
+// readMounts lists mount points of the mount namespace of the process with their locked flags. A mount point
+// mounted over keeps the flags of the last mount, which is the visible one.
+func readMounts() ([]mount, error) {
+	data, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read mounts")
+	}
+	flags := make(map[string]uintptr)
+	paths := make([]string, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+		path := unescapeMountPath(fields[4])
+		if _, ok := flags[path]; !ok {
+			paths = append(paths, path)
+		}
+		flags[path] = 0
+		for _, o := range strings.Split(fields[5], ",") {
+			flags[path] |= mountFlags[o]
+		}
+	}
+	mounts := make([]mount, 0, len(paths))
+	for _, p := range paths {
+		mounts = append(mounts, mount{path: p, flags: flags[p]})
+	}
+	return mounts, nil
+}
+
+// unescapeMountPath decodes octal escapes of spaces, tabs, new lines and backslashes of mountinfo paths.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
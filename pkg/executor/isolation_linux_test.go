@@ -0,0 +1,42 @@
+package executor
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestSandboxChild is the child process of TestSandboxReadOnly.
+func TestSandboxChild(t *testing.T) {
+	dir := os.Getenv("CROSSFORM_SANDBOX_CHILD")
+	if dir == "" {
+		t.Skip("started by TestSandboxReadOnly")
+	}
+	if err := enterIsolation(filepath.Join(dir, "checkout"), IsolationSandbox, Limits{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"checkout/main.jsonnet", "outside"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err == nil {
+			t.Fatalf("%s is writable in the sandbox", name)
+		}
+	}
+}
+
+func TestSandboxReadOnly(t *testing.T) {
+	skipWithoutSandbox(t)
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "checkout"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	attr, err := isolationAttr(IsolationSandbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := exec.Command(os.Args[0], "-test.run=^TestSandboxChild$")
+	c.Env = append(os.Environ(), "CROSSFORM_SANDBOX_CHILD="+dir)
+	c.SysProcAttr = attr
+	if out, err := c.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+}
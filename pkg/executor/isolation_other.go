@@ -0,0 +1,19 @@
+//go:build !linux
+
+package executor
+
+import (
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	"syscall"
+)
+
+func isolationAttr(mode IsolationMode) (*syscall.SysProcAttr, error) {
+	if mode == IsolationSandbox {
+		return nil, errors.New("sandbox isolation is supported on linux only")
+	}
+	return nil, nil
+}
+
+func enterIsolation(path string, mode IsolationMode, limits Limits) error {
+	return nil
+}
@@ -2,36 +2,169 @@ package executor
 
 import (
 	"crossform.io/pkg/logger"
+	_ "embed"
 	"encoding/json"
 	"fmt"
 	"github.com/crossplane/crossplane-runtime/pkg/errors"
 	"github.com/crossplane/function-sdk-go/resource"
 	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/santhosh-tekuri/jsonschema/v5"
 	"golang.org/x/exp/maps"
-	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
+// jsonnetLib is the crossform library of jsonnet modules, they read it by std.extVar('crossform').
+//
+//go:embed lib.jsonnet
+var jsonnetLib string
+
+// declarationsSnippet evaluates metadata of all fields of a file together with crossform objects of requests and inputs,
+// which are required before the evaluation of resources.
+const declarationsSnippet = `
+local m = import '%s';
+[
+  local c = m[f].crossform;
+  { field: f, crossform: if c.metadata.type == 'request' || c.metadata.type == 'input' then c else { metadata: c.metadata } }
+  for f in std.objectFields(m)
+]`
+
+// evaluationSnippet evaluates crossform objects of resources and outputs of a file, and resources which are not deferred.
+const evaluationSnippet = `
+local m = import '%s';
+{
+  [f]: {
+    crossform: m[f].crossform,
+    [if m[f].crossform.metadata.type == 'resource' && !std.get(m[f].crossform, 'deferred', false) then 'resource']: m[f],
+  }
+  for f in std.objectFields(m)
+  if m[f].crossform.metadata.type == 'resource' || m[f].crossform.metadata.type == 'output'
+}`
+
+// probeHeader starts ext codes of probes, raw is the value wrapped by the probe.
+const probeHeader = "local raw = %s;\nlocal touch = std.native('crossform.touch');\n"
+
+// observedProbe wraps observed resources so that every read of a resource status is reported to the executor.
+// Declared resources which are not observed yet get an empty status, so that reads with defaults are detected too.
+// Probes name every field instead of comprehensions, which would dominate evaluations of large modules.
+func observedProbe(raw string, declared []string) (string, error) {
+	var observed map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &observed); err != nil {
+		return "", errors.Wrap(err, "unable to unmarshal observed resources")
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, probeHeader, raw)
+	b.WriteString("raw + {\n")
+	for _, id := range sortedKeys(observed) {
+		if _, ok := observed[id]["status"]; ok {
+			q := jsonnetString(id)
+			fmt.Fprintf(&b, "  %s+: { status: touch('observed', %s, raw[%s].status) },\n", q, q, q)
+		}
+	}
+	for _, id := range declared {
+		if _, ok := observed[id]; !ok {
+			q := jsonnetString(id)
+			fmt.Fprintf(&b, "  %s: { status: touch('observed', %s, {}) },\n", q, q)
+		}
+	}
+	b.WriteString("}")
+	return b.String(), nil
+}
+
+// requestedProbe wraps requested resources so that every read of a request result is reported to the executor.
+func requestedProbe(raw string) (string, error) {
+	var requested map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &requested); err != nil {
+		return "", errors.Wrap(err, "unable to unmarshal requested resources")
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, probeHeader, raw)
+	b.WriteString("{\n")
+	for _, id := range sortedKeys(requested) {
+		q := jsonnetString(id)
+		fmt.Fprintf(&b, "  %s: touch('requested', %s, raw[%s]),\n", q, q, q)
+	}
+	b.WriteString("}")
+	return b.String(), nil
+}
+
+// inputsProbe wraps inputs of the XR so that every read of an input value is reported to the executor.
+func inputsProbe(raw string) (string, error) {
+	var xr map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &xr); err != nil {
+		return "", errors.Wrap(err, "unable to unmarshal xr")
+	}
+	spec, _ := xr["spec"].(map[string]interface{})
+	inputs, _ := spec["inputs"].(map[string]interface{})
+	var b strings.Builder
+	fmt.Fprintf(&b, probeHeader, raw)
+	b.WriteString("raw + { spec+: { inputs+: {\n")
+	for _, name := range sortedKeys(inputs) {
+		q := jsonnetString(name)
+		fmt.Fprintf(&b, "  %s: touch('input', %s, raw.spec.inputs[%s]),\n", q, q, q)
+	}
+	b.WriteString("} } }")
+	return b.String(), nil
+}
+
+// jsonnetString quotes s as a jsonnet string, JSON strings are valid jsonnet.
+func jsonnetString(s string) string {
+	j, _ := json.Marshal(s)
+	return string(j)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := maps.Keys(m)
+	sort.Strings(keys)
+	return keys
+}
+
+// jsonnetField is an evaluated field of a file.
+type jsonnetField struct {
+	Field     string                 `json:"field"`
+	Crossform *Crossform             `json:"crossform"`
+	Resource  map[string]interface{} `json:"resource"`
+}
+
+func init() {
+	Register(&engine{
+		name:   "jsonnet",
+		detect: detectByGlob("*.jsonnet"),
+		new:    newJsonnetExecutor,
+	})
+}
+
 type jsonnetExecutor struct {
-	log      zerolog.Logger
-	path     string
-	vm       *jsonnet.VM
-	metadata map[string]*metadata
+	log     zerolog.Logger
+	path    string
+	vm      *jsonnet.VM
+	probeVM *jsonnet.VM
+	// probed is set when the last probe read a probe, its values are cached by the probe vm then
+	probed   bool
+	extCodes map[string]string
+	importer *jsonnetImporter
+	touched  map[Dependency]bool
+	metadata map[string]*Metadata
 	fields   map[string][]string
+	// cache keeps evaluated fields by field paths, evaluated keeps files which evaluation snippet was run
+	cache     map[string]*jsonnetField
+	evaluated map[string]bool
 }
 
-func newJsonnetExecutor(path, observed, requested, xr, context string) (genericExecutor, error) {
+func newJsonnetExecutor(path, observed, requested, xr, context string, env *Environment) (ModuleExecutor, error) {
 	e := jsonnetExecutor{
 		path: path,
 		log: logger.GetLogger("JsonnetExecutor").With().
 			Str("directory", path).
 			Logger(),
-		metadata: make(map[string]*metadata),
-		fields:   make(map[string][]string),
+		metadata:  make(map[string]*Metadata),
+		fields:    make(map[string][]string),
+		cache:     make(map[string]*jsonnetField),
+		evaluated: make(map[string]bool),
+		importer:  &jsonnetImporter{imports: env.imports},
 	}
 	files, err := filepath.Glob(e.path + "/*.jsonnet")
 	if err != nil {
@@ -41,46 +174,192 @@ func newJsonnetExecutor(path, observed, requested, xr, context string) (genericE
 		return nil, nil
 	}
 
-	vm := jsonnet.MakeVM()
-	vm.ExtCode("observed", observed)
-	vm.ExtCode("requested", requested)
-	vm.ExtCode("xr", xr)
-	vm.ExtCode("context", context)
-	lib, err := os.ReadFile("lib.jsonnet")
+	e.extCodes = map[string]string{
+		"observed":  observed,
+		"requested": requested,
+		"xr":        xr,
+		"context":   context,
+		"crossform": jsonnetLib,
+	}
+	e.vm, err = e.makeVM(e.extCodes)
 	if err != nil {
 		return nil, err
 	}
-	vm.ExtCode("crossform", string(lib))
-	e.vm = vm
 
 	e.log.Debug().Msg("getting fields")
 	for _, file := range files {
+		if e.declare(file) {
+			continue
+		}
 		fields, err := e.getFields(file)
 		if err != nil {
 			return nil, err
 		}
+		e.fields[file] = fields
 		for _, field := range fields {
-			if _, exist := e.fields[file]; !exist {
-				e.fields[file] = make([]string, 0)
-			}
-			e.fields[file] = append(e.fields[file], field)
-		}
-	}
-
-	for file, fields := range e.fields {
-		for _, field := range fields {
-			p := e.getFieldPath(file, field)
 			m, err := e.getMetadata(file, field)
 			if err != nil {
 				return nil, err
 			}
-			e.metadata[p] = m
+			e.metadata[e.getFieldPath(file, field)] = m
 		}
 	}
 
 	return &e, nil
 }
 
+// declare evaluates fields and metadata of a file by one snippet, it reports false if the snippet fails.
+func (e *jsonnetExecutor) declare(file string) bool {
+	jsonStr, err := e.vm.EvaluateAnonymousSnippet("declarations.jsonnet", fmt.Sprintf(declarationsSnippet, file))
+	if err != nil {
+		e.log.Debug().Err(err).Str("file", file).Msg("declarations evaluation failed, evaluating field by field")
+		return false
+	}
+	var declarations []*jsonnetField
+	if err := json.Unmarshal([]byte(jsonStr), &declarations); err != nil {
+		e.log.Debug().Err(err).Str("file", file).Msg("unable to unmarshal declarations, evaluating field by field")
+		return false
+	}
+	fields := make([]string, 0, len(declarations))
+	for _, d := range declarations {
+		p := e.getFieldPath(file, d.Field)
+		fields = append(fields, d.Field)
+		e.metadata[p] = &d.Crossform.Metadata
+		if t := d.Crossform.Metadata.Type; t == "request" || t == "input" {
+			e.cache[p] = d
+		}
+	}
+	e.fields[file] = fields
+	return true
+}
+
+// evaluate evaluates resources and outputs of a file by one snippet once, fields of files failing it are not cached.
+func (e *jsonnetExecutor) evaluate(file string) {
+	if e.evaluated[file] {
+		return
+	}
+	e.evaluated[file] = true
+	jsonStr, err := e.vm.EvaluateAnonymousSnippet("evaluation.jsonnet", fmt.Sprintf(evaluationSnippet, file))
+	if err != nil {
+		e.log.Debug().Err(err).Str("file", file).Msg("file evaluation failed, evaluating field by field")
+		return
+	}
+	var fields map[string]*jsonnetField
+	if err := json.Unmarshal([]byte(jsonStr), &fields); err != nil {
+		e.log.Debug().Err(err).Str("file", file).Msg("unable to unmarshal file evaluation, evaluating field by field")
+		return
+	}
+	for field, f := range fields {
+		f.Field = field
+		e.cache[e.getFieldPath(file, field)] = f
+	}
+}
+
+// makeVM creates a vm with ext codes parsed once, otherwise they are parsed by every evaluated snippet.
+func (e *jsonnetExecutor) makeVM(extCodes map[string]string) (*jsonnet.VM, error) {
+	vm := jsonnet.MakeVM()
+	vm.Importer(e.importer)
+	for k, v := range extCodes {
+		node, err := jsonnet.SnippetToAST("<extvar:"+k+">", v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse ext code %s", k)
+		}
+		vm.ExtNode(k, node)
+	}
+	for _, f := range jsonnetNatives {
+		vm.NativeFunction(f)
+	}
+	vm.NativeFunction(&jsonnet.NativeFunction{
+		Name:   "crossform.touch",
+		Params: ast.Identifiers{"source", "id", "value"},
+		Func: func(args []interface{}) (interface{}, error) {
+			if e.touched != nil {
+				e.touched[Dependency{Source: args[0].(string), Id: args[1].(string)}] = true
+			}
+			return args[2], nil
+		},
+	})
+	return vm, nil
+}
+
+// getProbeVM returns a vm which evaluates modules against observed resources, requested resources and inputs
+// wrapped into probes. Imported files are cached by a vm together with their evaluated values, so reads of values
+// cached by the probe of another field would not be reported. Fields read values shared with other fields,
+// e.g. locals of resources, so every field is probed alone, and values are flushed after probes which read a probe.
+// Binding an ext var flushes the values, while parsed files are kept.
+func (e *jsonnetExecutor) getProbeVM() (*jsonnet.VM, error) {
+	if e.probeVM != nil {
+		if e.probed {
+			e.probeVM.ExtVar("crossform.probe", "")
+		}
+		return e.probeVM, nil
+	}
+	declared := make([]string, 0)
+	for _, m := range e.metadata {
+		if m.Type == "resource" {
+			declared = append(declared, m.Id)
+		}
+	}
+	extCodes := maps.Clone(e.extCodes)
+	var err error
+	extCodes["observed"], err = observedProbe(e.extCodes["observed"], declared)
+	if err != nil {
+		return nil, err
+	}
+	extCodes["requested"], err = requestedProbe(e.extCodes["requested"])
+	if err != nil {
+		return nil, err
+	}
+	extCodes["xr"], err = inputsProbe(e.extCodes["xr"])
+	if err != nil {
+		return nil, err
+	}
+	vm, err := e.makeVM(extCodes)
+	if err != nil {
+		return nil, err
+	}
+	e.probeVM = vm
+	return vm, nil
+}
+
+func (e *jsonnetExecutor) GetDependencies(file, field string) ([]*Dependency, error) {
+	vm, err := e.getProbeVM()
+	if err != nil {
+		return nil, err
+	}
+	m := e.GetMetadataObject(file, field)
+	fileImport := fmt.Sprintf("local m = import '%s';", file)
+	exec := fmt.Sprintf("%s m['%s']", fileImport, field)
+	switch m.Type {
+	case "output":
+		exec = fmt.Sprintf("%s m['%s'].crossform.output", fileImport, field)
+	case "request":
+		exec = fmt.Sprintf("%s m['%s'].crossform.request", fileImport, field)
+	}
+
+	e.touched = make(map[Dependency]bool)
+	defer func() {
+		e.probed = len(e.touched) > 0
+		e.touched = nil
+	}()
+	// evaluation errors are expected here, e.g. reads of fields of not yet created resources,
+	// all reads made before the error are recorded anyway
+	if _, err := vm.EvaluateAnonymousSnippet("probe.jsonnet", exec); err != nil {
+		e.log.Debug().Err(err).Str("field", field).Msg("probe evaluation error")
+	}
+
+	res := make([]*Dependency, 0)
+	for d := range e.touched {
+		if d.Source == dependencySourceObserved && d.Id == m.Id && m.Type == "resource" {
+			continue
+		}
+		d := d
+		res = append(res, &d)
+	}
+	sortDependencies(res)
+	return res, nil
+}
+
 func (e *jsonnetExecutor) getFields(file string) ([]string, error) {
 	fileImport := fmt.Sprintf("local m = import '%s';", file)
 	jsonStr, err := e.vm.EvaluateAnonymousSnippet("example1.jsonnet", fmt.Sprintf("%s std.objectFields(m)", fileImport))
@@ -110,9 +389,9 @@ func (e *jsonnetExecutor) GetFields(fileName string) []string {
 func (e *jsonnetExecutor) getMetadata(
 	file string,
 	field string,
-) (*metadata, error) {
+) (*Metadata, error) {
 	fileImport := fmt.Sprintf("local m = import '%s';", file)
-	var metadata metadata
+	var metadata Metadata
 	getMetadata := fmt.Sprintf("%s m['%s'].crossform.metadata", fileImport, field)
 	jsonStr, err := e.vm.EvaluateAnonymousSnippet("example1.jsonnet", getMetadata)
 	if err != nil {
@@ -127,12 +406,20 @@ func (e *jsonnetExecutor) getMetadata(
 	return &metadata, nil
 }
 
-func (e *jsonnetExecutor) GetMetadataObject(fileName, field string) *metadata {
+func (e *jsonnetExecutor) GetMetadataObject(fileName, field string) *Metadata {
 	return e.metadata[e.getFieldPath(fileName, field)]
 }
 
-func (e *jsonnetExecutor) GetCrossformObject(file, field string) (*crossform, error) {
-	var crossform crossform
+func (e *jsonnetExecutor) GetCrossformObject(file, field string) (*Crossform, error) {
+	p := e.getFieldPath(file, field)
+	if _, ok := e.cache[p]; !ok {
+		e.evaluate(file)
+	}
+	if f, ok := e.cache[p]; ok {
+		return f.Crossform, nil
+	}
+
+	var crossform Crossform
 	fileImport := fmt.Sprintf("local m = import '%s';", file)
 	getCrossform := fmt.Sprintf("%s m['%s'].crossform", fileImport, field)
 	jsonStr, err := e.vm.EvaluateAnonymousSnippet("example1.jsonnet", getCrossform)
@@ -148,39 +435,25 @@ func (e *jsonnetExecutor) GetCrossformObject(file, field string) (*crossform, er
 	return &crossform, nil
 }
 
-func (e *jsonnetExecutor) ValidateInputs(inputs map[string]*crossform, input map[string]interface{}) error {
-	schema := make(map[string]interface{})
-	schema["type"] = "object"
-	schema["required"] = make([]string, 0)
-	schema["properties"] = make(map[string]interface{})
-	properties := schema["properties"].(map[string]interface{})
-
-	for k, v := range inputs {
-		if v.Schema == nil {
-			continue
-		}
-		properties[k] = v.Schema
-		if _, exist := v.Schema["default"]; !exist {
-			schema["required"] = append(schema["required"].([]string), k)
-		}
-	}
-	compiler := jsonschema.NewCompiler()
-	j, err := json.Marshal(schema)
-	if err != nil {
-		return err
-	}
-
-	if err := compiler.AddResource("https://crossform.io/inputs", strings.NewReader(string(j))); err != nil {
-		return err
+// GetOutputSchema evaluates the schema of an output alone, so that outputs reading missing observed resources have schemas too.
+func (e *jsonnetExecutor) GetOutputSchema(file, field string) (map[string]interface{}, error) {
+	if f, ok := e.cache[e.getFieldPath(file, field)]; ok {
+		return f.Crossform.Schema, nil
 	}
-	sch, err := compiler.Compile("https://crossform.io/inputs")
+	snippet := fmt.Sprintf("local m = import '%s'; std.get(m['%s'].crossform, 'schema')", file, field)
+	jsonStr, err := e.vm.EvaluateAnonymousSnippet("schema.jsonnet", snippet)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if err = sch.Validate(input); err != nil {
-		return err
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(jsonStr), &schema); err != nil {
+		return nil, err
 	}
-	return nil
+	return schema, nil
+}
+
+func (e *jsonnetExecutor) ValidateInputs(inputs map[string]*Crossform, input map[string]interface{}) error {
+	return validateInputsSchema(inputs, input)
 }
 
 func (e *jsonnetExecutor) GetResource(file, field string) (map[string]interface{}, bool, resource.Ready, error) {
@@ -191,6 +464,17 @@ func (e *jsonnetExecutor) GetResource(file, field string) (map[string]interface{
 	if crossform.Deferred {
 		return nil, true, resource.ReadyUnspecified, nil
 	}
+	ready := resource.ReadyUnspecified
+	if crossform.Ready.Valid {
+		if crossform.Ready.Bool {
+			ready = resource.ReadyTrue
+		} else {
+			ready = resource.ReadyFalse
+		}
+	}
+	if f, ok := e.cache[e.getFieldPath(file, field)]; ok && f.Resource != nil {
+		return f.Resource, false, ready, nil
+	}
 
 	fileImport := fmt.Sprintf("local m = import '%s';", file)
 	exec := fmt.Sprintf("%s m['%s']", fileImport, field)
@@ -208,16 +492,7 @@ func (e *jsonnetExecutor) GetResource(file, field string) (map[string]interface{
 		return nil, false, resource.ReadyUnspecified, err
 	}
 
-	ready := resource.ReadyUnspecified
-	if crossform.Ready.Valid {
-		if crossform.Ready.Bool {
-			ready = resource.ReadyTrue
-		} else {
-			ready = resource.ReadyFalse
-		}
-	}
-
-	log.Debug().Str("id", crossform.Metadata.Id).Str("json", jsonStr).Msg("resource evaluating success")
+	log.Debug().Str("id", crossform.Metadata.Id).Msg("resource evaluating success")
 
 	return obj, false, ready, nil
 }
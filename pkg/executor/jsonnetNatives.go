@@ -0,0 +1,375 @@
+package executor
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/Masterminds/semver/v3"
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/uuid"
+	yamlv3 "gopkg.in/yaml.v3"
+	"math"
+	"math/big"
+	"net/netip"
+	"regexp"
+	"sigs.k8s.io/yaml"
+)
+
+// jsonnetNatives are helpers implemented in go, modules call them by std.native or by wrappers of lib.jsonnet.
+var jsonnetNatives = []*jsonnet.NativeFunction{
+	{
+		Name:   "crossform.cidrSubnet",
+		Params: ast.Identifiers{"prefix", "newbits", "netnum"},
+		Func: func(args []interface{}) (interface{}, error) {
+			prefix, err := nativeString(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			newbits, err := nativeInt(args[1])
+			if err != nil {
+				return nil, errors.Wrap(err, "newbits")
+			}
+			netnum, err := nativeInt(args[2])
+			if err != nil {
+				return nil, errors.Wrap(err, "netnum")
+			}
+			return cidrSubnet(prefix, newbits, netnum)
+		},
+	},
+	{
+		Name:   "crossform.cidrSubnets",
+		Params: ast.Identifiers{"prefix", "newbits"},
+		Func: func(args []interface{}) (interface{}, error) {
+			prefix, err := nativeString(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			list, ok := args[1].([]interface{})
+			if !ok {
+				return nil, errors.New("newbits has to be an array")
+			}
+			newbits := make([]int, 0, len(list))
+			for _, v := range list {
+				n, err := nativeInt(v)
+				if err != nil {
+					return nil, errors.Wrap(err, "newbits")
+				}
+				newbits = append(newbits, n)
+			}
+			subnets, err := cidrSubnets(prefix, newbits)
+			if err != nil {
+				return nil, err
+			}
+			res := make([]interface{}, 0, len(subnets))
+			for _, s := range subnets {
+				res = append(res, s)
+			}
+			return res, nil
+		},
+	},
+	{
+		Name:   "crossform.cidrHost",
+		Params: ast.Identifiers{"prefix", "hostnum"},
+		Func: func(args []interface{}) (interface{}, error) {
+			prefix, err := nativeString(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			hostnum, err := nativeInt(args[1])
+			if err != nil {
+				return nil, errors.Wrap(err, "hostnum")
+			}
+			return cidrHost(prefix, hostnum)
+		},
+	},
+	{
+		Name:   "crossform.cidrNetmask",
+		Params: ast.Identifiers{"prefix"},
+		Func: stringsNative(func(args []string) (interface{}, error) {
+			p, err := netip.ParsePrefix(args[0])
+			if err != nil {
+				return nil, err
+			}
+			if !p.Addr().Is4() {
+				return nil, errors.Errorf("netmask of %s is not an IPv4 address", args[0])
+			}
+			return netip.PrefixFrom(netip.AddrFrom4([4]byte{255, 255, 255, 255}), p.Bits()).Masked().Addr().String(), nil
+		}),
+	},
+	{
+		Name:   "crossform.base64Encode",
+		Params: ast.Identifiers{"str"},
+		Func: stringsNative(func(args []string) (interface{}, error) {
+			return base64.StdEncoding.EncodeToString([]byte(args[0])), nil
+		}),
+	},
+	{
+		Name:   "crossform.base64Decode",
+		Params: ast.Identifiers{"str"},
+		Func: stringsNative(func(args []string) (interface{}, error) {
+			b, err := base64.StdEncoding.DecodeString(args[0])
+			return string(b), err
+		}),
+	},
+	{
+		Name:   "crossform.sha256",
+		Params: ast.Identifiers{"str"},
+		Func: stringsNative(func(args []string) (interface{}, error) {
+			sum := sha256.Sum256([]byte(args[0]))
+			return hex.EncodeToString(sum[:]), nil
+		}),
+	},
+	{
+		Name:   "crossform.hexEncode",
+		Params: ast.Identifiers{"str"},
+		Func: stringsNative(func(args []string) (interface{}, error) {
+			return hex.EncodeToString([]byte(args[0])), nil
+		}),
+	},
+	{
+		Name:   "crossform.hexDecode",
+		Params: ast.Identifiers{"str"},
+		Func: stringsNative(func(args []string) (interface{}, error) {
+			b, err := hex.DecodeString(args[0])
+			return string(b), err
+		}),
+	},
+	{
+		Name:   "crossform.semverCompare",
+		Params: ast.Identifiers{"v1", "v2"},
+		Func: stringsNative(func(args []string) (interface{}, error) {
+			v1, err := semver.NewVersion(args[0])
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid version %s", args[0])
+			}
+			v2, err := semver.NewVersion(args[1])
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid version %s", args[1])
+			}
+			return float64(v1.Compare(v2)), nil
+		}),
+	},
+	{
+		Name:   "crossform.semverSatisfies",
+		Params: ast.Identifiers{"version", "constraint"},
+		Func: stringsNative(func(args []string) (interface{}, error) {
+			v, err := semver.NewVersion(args[0])
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid version %s", args[0])
+			}
+			c, err := semver.NewConstraint(args[1])
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid constraint %s", args[1])
+			}
+			return c.Check(v), nil
+		}),
+	},
+	{
+		Name:   "crossform.regexMatch",
+		Params: ast.Identifiers{"pattern", "str"},
+		Func: stringsNative(func(args []string) (interface{}, error) {
+			return regexp.MatchString(args[0], args[1])
+		}),
+	},
+	{
+		Name:   "crossform.regexReplace",
+		Params: ast.Identifiers{"pattern", "str", "replacement"},
+		Func: stringsNative(func(args []string) (interface{}, error) {
+			re, err := regexp.Compile(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return re.ReplaceAllString(args[1], args[2]), nil
+		}),
+	},
+	{
+		Name:   "crossform.parseYaml",
+		Params: ast.Identifiers{"str"},
+		Func: stringsNative(func(args []string) (interface{}, error) {
+			// yaml 1.2 of yaml.v3, values are normalized by a json round trip
+			var v interface{}
+			if err := yamlv3.Unmarshal([]byte(args[0]), &v); err != nil {
+				return nil, err
+			}
+			j, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			var res interface{}
+			err = json.Unmarshal(j, &res)
+			return res, err
+		}),
+	},
+	{
+		Name:   "crossform.manifestYaml",
+		Params: ast.Identifiers{"value"},
+		Func: func(args []interface{}) (interface{}, error) {
+			y, err := yaml.Marshal(args[0])
+			return string(y), err
+		},
+	},
+	{
+		Name:   "crossform.jsonPatch",
+		Params: ast.Identifiers{"doc", "patch"},
+		Func: func(args []interface{}) (interface{}, error) {
+			doc, err := json.Marshal(args[0])
+			if err != nil {
+				return nil, err
+			}
+			p, err := json.Marshal(args[1])
+			if err != nil {
+				return nil, err
+			}
+			patch, err := jsonpatch.DecodePatch(p)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid json patch")
+			}
+			patched, err := patch.Apply(doc)
+			if err != nil {
+				return nil, errors.Wrap(err, "unable to apply json patch")
+			}
+			var res interface{}
+			err = json.Unmarshal(patched, &res)
+			return res, err
+		},
+	},
+	{
+		Name:   "crossform.uuidV5",
+		Params: ast.Identifiers{"namespace", "name"},
+		Func: stringsNative(func(args []string) (interface{}, error) {
+			ns, ok := map[string]uuid.UUID{
+				"dns":  uuid.NameSpaceDNS,
+				"url":  uuid.NameSpaceURL,
+				"oid":  uuid.NameSpaceOID,
+				"x500": uuid.NameSpaceX500,
+			}[args[0]]
+			if !ok {
+				var err error
+				if ns, err = uuid.Parse(args[0]); err != nil {
+					return nil, errors.Wrapf(err, "invalid namespace %s", args[0])
+				}
+			}
+			return uuid.NewSHA1(ns, []byte(args[1])).String(), nil
+		}),
+	},
+}
+
+// stringsNative makes a native function of string parameters.
+func stringsNative(fn func(args []string) (interface{}, error)) func(args []interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		strs := make([]string, 0, len(args))
+		for i := range args {
+			s, err := nativeString(args, i)
+			if err != nil {
+				return nil, err
+			}
+			strs = append(strs, s)
+		}
+		return fn(strs)
+	}
+}
+
+func nativeString(args []interface{}, i int) (string, error) {
+	s, ok := args[i].(string)
+	if !ok {
+		return "", errors.Errorf("argument %d has to be a string", i+1)
+	}
+	return s, nil
+}
+
+func nativeInt(v interface{}) (int, error) {
+	f, ok := v.(float64)
+	if !ok {
+		return 0, errors.Errorf("%v is not a number", v)
+	}
+	if f != math.Trunc(f) || math.Abs(f) > 1<<53 {
+		return 0, errors.Errorf("%v is not an integer", f)
+	}
+	return int(f), nil
+}
+
+func addrToInt(a netip.Addr) *big.Int {
+	return new(big.Int).SetBytes(a.AsSlice())
+}
+
+func intToAddr(i *big.Int, bitLen int) netip.Addr {
+	b := make([]byte, bitLen/8)
+	i.FillBytes(b)
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
+
+// cidrSubnet calculates the netnum-th subnet of the prefix extended by newbits, like cidrsubnet of terraform.
+func cidrSubnet(prefix string, newbits, netnum int) (string, error) {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return "", err
+	}
+	p = p.Masked()
+	bitLen := p.Addr().BitLen()
+	bits := p.Bits() + newbits
+	if newbits < 0 || bits > bitLen {
+		return "", errors.Errorf("unable to extend prefix %s by %d bits", prefix, newbits)
+	}
+	if netnum < 0 || big.NewInt(int64(netnum)).BitLen() > newbits {
+		return "", errors.Errorf("netnum %d does not fit in %d bits", netnum, newbits)
+	}
+	addr := addrToInt(p.Addr())
+	addr.Add(addr, new(big.Int).Lsh(big.NewInt(int64(netnum)), uint(bitLen-bits)))
+	return netip.PrefixFrom(intToAddr(addr, bitLen), bits).String(), nil
+}
+
+// cidrSubnets allocates consecutive subnets of the prefix extended by newbits, like cidrsubnets of terraform.
+func cidrSubnets(prefix string, newbits []int) ([]string, error) {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	p = p.Masked()
+	bitLen := p.Addr().BitLen()
+	start := addrToInt(p.Addr())
+	end := new(big.Int).Add(start, new(big.Int).Lsh(big.NewInt(1), uint(bitLen-p.Bits())))
+	current := new(big.Int).Set(start)
+	res := make([]string, 0, len(newbits))
+	for _, n := range newbits {
+		bits := p.Bits() + n
+		if n < 1 || bits > bitLen {
+			return nil, errors.Errorf("unable to extend prefix %s by %d bits", prefix, n)
+		}
+		size := new(big.Int).Lsh(big.NewInt(1), uint(bitLen-bits))
+		// subnets are aligned to their size
+		if rem := new(big.Int).Mod(current, size); rem.Sign() != 0 {
+			current.Add(current, new(big.Int).Sub(size, rem))
+		}
+		next := new(big.Int).Add(current, size)
+		if next.Cmp(end) > 0 {
+			return nil, errors.Errorf("not enough space in %s for a subnet extended by %d bits", prefix, n)
+		}
+		res = append(res, netip.PrefixFrom(intToAddr(current, bitLen), bits).String())
+		current = next
+	}
+	return res, nil
+}
+
+// cidrHost calculates the address of the hostnum-th host of the prefix, negative numbers count from the end.
+func cidrHost(prefix string, hostnum int) (string, error) {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return "", err
+	}
+	p = p.Masked()
+	bitLen := p.Addr().BitLen()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bitLen-p.Bits()))
+	n := big.NewInt(int64(hostnum))
+	if hostnum < 0 {
+		n.Add(n, size)
+	}
+	if n.Sign() < 0 || n.Cmp(size) >= 0 {
+		return "", errors.Errorf("prefix %s has no host %d", prefix, hostnum)
+	}
+	return intToAddr(n.Add(n, addrToInt(p.Addr())), bitLen).String(), nil
+}
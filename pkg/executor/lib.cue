@@ -1,3 +1,10 @@
+import (
+  "math/bits"
+  "net"
+  "strconv"
+  "strings"
+)
+
 //_observed:{}
 //_requested:{}
 //_xr:{}
@@ -31,13 +38,22 @@
 
 #input:{
   _name: string
+  _type: _
+  _description: string | *""
+  _sensitive: bool | *false
   _crossform:{
     metadata:{
         id: _name
         type: "input"
     }
+    sensitive: _sensitive
   }
-  value: _xr.spec.inputs[_name]
+  // the provided value is not unified with _type, so that invalid inputs are reported by the validation
+  // instead of failing the whole module
+  value: [
+    if _xr.spec.inputs[_name] != _|_ {_xr.spec.inputs[_name]},
+    _type,
+  ][0]
 }
 #resource: {
   _id: string
@@ -55,14 +71,89 @@
   ...
 }
 
+// #module declares a child module, #outputs are the outputs of the observed child which it declares and which
+// match their schemas
+#module: #resource & {
+  _id: string
+  _repository: string
+  _revision: string
+  _path: string
+  _inputs: {...} | *null
+  apiVersion: "crossform.io/v1alpha1"
+  kind: "xModule"
+  metadata: name: _xr.metadata.name + "-" + _id
+  spec: {
+    repository: _repository
+    revision: _revision
+    path: _path
+    if _inputs != null {
+      inputs: _inputs
+    }
+  }
+  #outputs: *_observed[_id].status.outputs | {...}
+}
+
+// #output publishes _value in status.outputs, the value is validated against the schema converted from _type
+// and is not unified with it, so that an invalid output is reported instead of failing the whole module.
+// Sensitive values are published in connection details of the XR instead.
 #output: {
   _id: string
   _value: _
+  _type: _
+  _sensitive: bool | *false
   _crossform: {
     metadata: {
-      id: id,
+      id: _id,
       type: "output",
     },
     output: _value,
+    sensitive: _sensitive,
   },
-}
\ No newline at end of file
+}
+
+// #cidrSubnet calculates the _netnum-th subnet of an IPv4 prefix extended by _newbits, like cidrSubnet of lib.jsonnet:
+// (#cidrSubnet & {_prefix: "10.0.0.0/16", _newbits: 8, _netnum: 2}).out is "10.0.2.0/24"
+// The CUE helpers support IPv4 only, out is bottom when the subnet does not fit in 32 bits or _netnum does not fit
+// in _newbits, so that the error fails only the field using it.
+#cidrSubnet: {
+  _prefix: string
+  _newbits: int & >=0
+  _netnum: int & >=0
+  let parts = strings.Split(_prefix, "/")
+  let prefixBits = strconv.Atoi(parts[1])
+  let ip = net.ToIP4(parts[0])
+  let addr = ((ip[0]*256+ip[1])*256+ip[2])*256+ip[3]
+  let network = addr - mod(addr, bits.Lsh(1, 32-prefixBits)) + _netnum*bits.Lsh(1, 32-prefixBits-_newbits)
+  // messages of invalid arguments fail out, every message conflicts with the empty string
+  let invalid = [
+    if !net.IPCIDR(_prefix) || !net.IPv4(parts[0]) {"prefix \(_prefix) is not an IPv4 prefix"},
+    if prefixBits+_newbits > 32 {"unable to extend prefix \(_prefix) by \(_newbits) bits"},
+    if _netnum >= bits.Lsh(1, _newbits) {"netnum \(_netnum) does not fit in \(_newbits) bits"},
+  ]
+  out: [
+    for m in invalid {m & =~"^$"},
+    strings.Join([ for s in [24, 16, 8, 0] {strconv.FormatInt(mod(div(network, bits.Lsh(1, s)), 256), 10)}], ".") + "/" + strconv.FormatInt(prefixBits+_newbits, 10),
+  ][0]
+}
+
+// #cidrHost calculates the address of the _hostnum-th host of an IPv4 prefix, negative numbers count from the end:
+// (#cidrHost & {_prefix: "10.0.2.0/24", _hostnum: 5}).out is "10.0.2.5"
+// It fails when the prefix has no such host.
+#cidrHost: {
+  _prefix: string
+  _hostnum: int
+  let parts = strings.Split(_prefix, "/")
+  let size = bits.Lsh(1, 32-strconv.Atoi(parts[1]))
+  let ip = net.ToIP4(parts[0])
+  let addr = ((ip[0]*256+ip[1])*256+ip[2])*256+ip[3]
+  let host = [ if _hostnum < 0 {size + _hostnum}, _hostnum][0]
+  let address = addr - mod(addr, size) + host
+  let invalid = [
+    if !net.IPCIDR(_prefix) || !net.IPv4(parts[0]) {"prefix \(_prefix) is not an IPv4 prefix"},
+    if _hostnum < -size || _hostnum >= size {"prefix \(_prefix) has no host \(_hostnum)"},
+  ]
+  out: [
+    for m in invalid {m & =~"^$"},
+    strings.Join([ for s in [24, 16, 8, 0] {strconv.FormatInt(mod(div(address, bits.Lsh(1, s)), 256), 10)}], "."),
+  ][0]
+}
@@ -0,0 +1,206 @@
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sync"
+	"time"
+)
+
+// Limits are budgets of one module evaluation, a zero value disables the budget.
+type Limits struct {
+	// Timeout is the wall clock budget of the evaluation. Evaluations in the process of the caller which can not be
+	// interrupted are abandoned at the timeout and finish in the background, see executeWithin.
+	Timeout time.Duration
+	// MaxMemory is the memory budget of the child process of the evaluation in bytes, it is enforced by an rlimit
+	// of the child, so it applies only to isolated evaluations, see Isolation. Evaluations in the process of
+	// the caller share its heap, the budget does not apply to them.
+	MaxMemory int64
+	// MaxSteps limits execution steps of every starlark file and function, other engines do not count steps
+	MaxSteps uint64
+}
+
+// DefaultLimits are budgets of evaluations which XR does not override them by spec.limits.
+var DefaultLimits = Limits{
+	Timeout:  time.Minute,
+	MaxSteps: 10_000_000,
+}
+
+// LimitsSchema is the OpenAPI v3 schema of spec.limits of XRs.
+var LimitsSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"timeout": map[string]interface{}{
+			"type":        "string",
+			"description": "Wall clock budget of one evaluation, like 30s.",
+		},
+		"maxMemory": map[string]interface{}{
+			"x-kubernetes-int-or-string": true,
+			"description":                "Memory budget of the child process of one evaluation, like 512Mi. It applies only when the repository server isolates evaluations.",
+		},
+		"maxSteps": map[string]interface{}{
+			"type":        "integer",
+			"description": "Execution steps of every starlark file and function, other engines do not count steps.",
+		},
+	},
+}
+
+// BudgetExceededError is returned when an evaluation exceeds one of its limits.
+type BudgetExceededError struct {
+	Budget string
+	Limit  string
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("evaluation exceeded the %s budget of %s", e.Budget, e.Limit)
+}
+
+func memoryExceededError(maxMemory int64) error {
+	return &BudgetExceededError{Budget: "memory", Limit: resource.NewQuantity(maxMemory, resource.BinarySI).String()}
+}
+
+type xrLimits struct {
+	Timeout   string             `json:"timeout"`
+	MaxMemory *resource.Quantity `json:"maxMemory"`
+	MaxSteps  *uint64            `json:"maxSteps"`
+}
+
+// limits returns DefaultLimits overridden by spec.limits of the XR.
+func (cmd *ExecCommand) limits() (Limits, error) {
+	res := DefaultLimits
+	if cmd.XR == nil || cmd.XR.Resource == nil {
+		return res, nil
+	}
+	spec, _ := cmd.XR.Resource.Object["spec"].(map[string]interface{})
+	v, ok := spec["limits"]
+	if !ok {
+		return res, nil
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return res, err
+	}
+	var l xrLimits
+	if err := json.Unmarshal(j, &l); err != nil {
+		return res, errors.Wrap(err, "unable to unmarshal spec.limits")
+	}
+	if l.Timeout != "" {
+		res.Timeout, err = time.ParseDuration(l.Timeout)
+		if err != nil {
+			return res, errors.Wrap(err, "invalid spec.limits.timeout")
+		}
+	}
+	if l.MaxMemory != nil {
+		res.MaxMemory = l.MaxMemory.Value()
+	}
+	if l.MaxSteps != nil {
+		res.MaxSteps = *l.MaxSteps
+	}
+	return res, nil
+}
+
+// budget watches limits of one evaluation. Engines able to interrupt a running evaluation
+// register interrupt functions, evaluations of the others run to the end.
+type budget struct {
+	limits     Limits
+	mu         sync.Mutex
+	err        error
+	interrupts []func(err error)
+	// done is closed when a budget is exceeded
+	done chan struct{}
+}
+
+func newBudget(limits Limits) *budget {
+	return &budget{limits: limits, done: make(chan struct{})}
+}
+
+// exceed records the first exceeded budget and interrupts the evaluation.
+func (b *budget) exceed(err error) {
+	b.mu.Lock()
+	if b.err != nil {
+		b.mu.Unlock()
+		return
+	}
+	b.err = err
+	interrupts := b.interrupts
+	close(b.done)
+	b.mu.Unlock()
+	for _, interrupt := range interrupts {
+		interrupt(err)
+	}
+}
+
+func (b *budget) exceeded() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// onExceeded registers an interrupt function, it is called at once when a budget is already exceeded.
+func (b *budget) onExceeded(interrupt func(err error)) {
+	b.mu.Lock()
+	err := b.err
+	if err == nil {
+		b.interrupts = append(b.interrupts, interrupt)
+	}
+	b.mu.Unlock()
+	if err != nil {
+		interrupt(err)
+	}
+}
+
+// watch starts the timer of the budget, the returned function stops it. The memory budget is enforced
+// by child processes, see enterIsolation.
+func (b *budget) watch() func() {
+	if b.limits.Timeout <= 0 {
+		return func() {}
+	}
+	timer := time.AfterFunc(b.limits.Timeout, func() {
+		b.exceed(&BudgetExceededError{Budget: "timeout", Limit: b.limits.Timeout.String()})
+	})
+	return func() {
+		timer.Stop()
+	}
+}
+
+type evaluation struct {
+	result *ExecResult
+	err    error
+}
+
+// executeWithin evaluates the module within the limits in this process. The evaluation runs on its own goroutine
+// and the exceeded budget is returned at once. Starlark evaluations are interrupted, evaluations of engines which
+// can not be interrupted are abandoned and finish in the background.
+func executeWithin(path string, cmd *ExecCommand, limits Limits, imports map[string]*resolvedImport) (*ExecResult, error) {
+	b := newBudget(limits)
+	stop := b.watch()
+	defer stop()
+	done := make(chan evaluation, 1)
+	go func() {
+		var res evaluation
+		defer func() {
+			// a panicking engine fails the evaluation, not the process
+			if r := recover(); r != nil {
+				res.err = errors.Errorf("evaluation panicked: %v", r)
+			}
+			done <- res
+		}()
+		// engines may evaluate the module while the executor is created
+		e, err := newExecutor(cmd, path, &Environment{root: path, budget: b, imports: imports})
+		if err == nil {
+			res.result, err = e.Exec()
+		}
+		res.err = err
+	}()
+	select {
+	case res := <-done:
+		if exceeded := b.exceeded(); exceeded != nil {
+			return nil, exceeded
+		}
+		return res.result, res.err
+	case <-b.done:
+		return nil, b.exceeded()
+	}
+}
@@ -1,13 +1,36 @@
 package executor
 
+// Execute evaluates the module within the limits of the command, see Limits. The module is evaluated
+// in a child process unless Isolation is disabled, git imports are resolved before, by this process.
 func Execute(path string, cmd *ExecCommand) (*ExecResult, error) {
+	limits, err := cmd.limits()
+	if err != nil {
+		return nil, err
+	}
+	imports, err := resolveImports(path+"/"+cmd.Path, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if Isolation.Mode != IsolationNone {
+		return executeIsolated(path, cmd, limits, imports, Isolation.Mode)
+	}
+	return executeWithin(path, cmd, limits, imports)
+}
+
+// InputsSchema returns the OpenAPI v3 schema of XR spec.inputs of the module.
+func InputsSchema(path string, cmd *ExecCommand) (map[string]interface{}, error) {
+	e, err := NewExecutor(cmd, path)
+	if err != nil {
+		return nil, err
+	}
+	return e.InputsSchema()
+}
+
+// OutputsSchema returns the OpenAPI v3 schema of XR status.outputs of the module.
+func OutputsSchema(path string, cmd *ExecCommand) (map[string]interface{}, error) {
 	e, err := NewExecutor(cmd, path)
 	if err != nil {
 		return nil, err
 	}
-	result, err := e.Exec()
-	//if err == nil && len(cmd.Requested) == len(result.Request) {
-	//	_ = e.writeTestData(result, err)
-	//}
-	return result, err
+	return e.OutputsSchema()
 }
@@ -0,0 +1,157 @@
+package executor
+
+import (
+	"fmt"
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	"github.com/crossplane/function-sdk-go/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sort"
+	"strings"
+)
+
+// child modules are XRs of crossform, lib.module of the libraries declares them as resources
+const (
+	moduleApiVersion = "crossform.io/v1alpha1"
+	moduleKind       = "xModule"
+)
+
+// moduleReportSections are sections of the report of a module which keep statuses of its items by ids.
+var moduleReportSections = []struct {
+	section string
+	item    string
+}{
+	{"inputs", "input"},
+	{"requests", "request"},
+	{"resources", "resource"},
+	{"outputs", "output"},
+	{"modules", "module"},
+}
+
+func isModule(obj map[string]interface{}) bool {
+	return obj["apiVersion"] == moduleApiVersion && obj["kind"] == moduleKind
+}
+
+// expandModules completes child modules of the module: a child is evaluated by the repository server of
+// its parent unless it sets its own. Errors of the last evaluations of observed children are errors of the parent.
+func (e *Executor) expandModules(result *ExecResult) {
+	repoServer, hasRepoServer, _ := unstructured.NestedString(e.cmd.XR.Resource.Object, "spec", "repoServer")
+	ids := make([]string, 0)
+	for id, d := range result.Desired {
+		if !isModule(d.Resource.Object) {
+			continue
+		}
+		ids = append(ids, string(id))
+		if _, ok, _ := unstructured.NestedString(d.Resource.Object, "spec", "repoServer"); !ok && hasRepoServer {
+			if err := unstructured.SetNestedField(d.Resource.Object, repoServer, "spec", "repoServer"); err != nil {
+				e.log.Warn().Err(err).Str("id", string(id)).Msg("unable to set repoServer of child module")
+			}
+		}
+	}
+	ids = append(ids, result.Deferred...)
+
+	for _, id := range ids {
+		observed, ok := e.cmd.Observed[resource.Name(id)]
+		if !ok || observed.Resource == nil || !isModule(observed.Resource.Object) {
+			continue
+		}
+		err := moduleError(observed.Resource.Object)
+		if outputsErr, ok := e.modulesOutputsErrors[id]; ok {
+			if err == nil {
+				err = outputsErr
+			} else {
+				err = errors.Errorf("%s; %s", err, outputsErr)
+			}
+		}
+		if err != nil {
+			if result.ModulesErrors == nil {
+				result.ModulesErrors = make(map[string]error)
+			}
+			result.ModulesErrors[id] = err
+		}
+	}
+}
+
+// moduleError collects errors of the report of a child module, it is nil when the report has none.
+// Errors of grandchildren are included by the modules section of the report.
+func moduleError(obj map[string]interface{}) error {
+	report, ok, _ := unstructured.NestedMap(obj, "status", "report")
+	if !ok {
+		return nil
+	}
+	messages := make([]string, 0)
+	if critical, _ := report["criticalError"].(string); critical != "" {
+		messages = append(messages, critical)
+	}
+	if validation, _ := report["inputsValidation"].(string); validation != "" && validation != "OK" {
+		messages = append(messages, "inputs validation: "+validation)
+	}
+	for _, s := range moduleReportSections {
+		items, _ := report[s.section].(map[string]interface{})
+		keys := make([]string, 0, len(items))
+		for k := range items {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			status, _ := items[k].(string)
+			if !strings.HasPrefix(status, "ERROR") {
+				continue
+			}
+			status = strings.TrimSpace(strings.TrimPrefix(status, "ERROR:"))
+			messages = append(messages, fmt.Sprintf("%s %s: %s", s.item, k, status))
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	name, _, _ := unstructured.NestedString(obj, "metadata", "name")
+	return errors.Errorf("module %s has errors: %s", name, strings.Join(messages, "; "))
+}
+
+// typeModulesOutputs keeps outputs of observed child modules which the children declare and which match their
+// schemas, so that lib.module returns the declared outputs only. Other outputs are errors of the child module.
+// Children which do not publish status.outputsSchema keep all of their outputs.
+func (e *Executor) typeModulesOutputs() {
+	for id, observed := range e.cmd.Observed {
+		if observed.Resource == nil || !isModule(observed.Resource.Object) {
+			continue
+		}
+		declared, ok, _ := unstructured.NestedMap(observed.Resource.Object, "status", "outputsSchema", "properties")
+		if !ok {
+			continue
+		}
+		outputs, ok, _ := unstructured.NestedMap(observed.Resource.Object, "status", "outputs")
+		if !ok {
+			continue
+		}
+		keys := make([]string, 0, len(outputs))
+		for k := range outputs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		typed := make(map[string]interface{})
+		messages := make([]string, 0)
+		for _, k := range keys {
+			schema, ok := declared[k].(map[string]interface{})
+			if !ok {
+				messages = append(messages, fmt.Sprintf("output %s is not declared", k))
+				continue
+			}
+			if err := validateOutputSchema(k, schema, outputs[k]); err != nil {
+				messages = append(messages, err.Error())
+				continue
+			}
+			typed[k] = outputs[k]
+		}
+		if err := unstructured.SetNestedMap(observed.Resource.Object, typed, "status", "outputs"); err != nil {
+			e.log.Warn().Err(err).Str("id", string(id)).Msg("unable to set outputs of child module")
+		}
+		if len(messages) > 0 {
+			if e.modulesOutputsErrors == nil {
+				e.modulesOutputsErrors = make(map[string]error)
+			}
+			e.modulesOutputsErrors[string(id)] = errors.Errorf("module %s has invalid outputs: %s",
+				observed.Resource.GetName(), strings.Join(messages, "; "))
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"sort"
+	"strings"
+)
+
+// inputsSchema makes the OpenAPI v3 schema of XR spec.inputs out of the schemas of declared inputs.
+// Inputs without a schema accept any value and are not required.
+func inputsSchema(inputs map[string]*Crossform) map[string]interface{} {
+	required := make([]string, 0)
+	properties := make(map[string]interface{})
+	for k, v := range inputs {
+		if v.Schema == nil {
+			properties[k] = map[string]interface{}{
+				"x-kubernetes-preserve-unknown-fields": true,
+			}
+			continue
+		}
+		properties[k] = v.Schema
+		if _, exist := v.Schema["default"]; !exist {
+			required = append(required, k)
+		}
+	}
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// scalarValidations are keywords of input schemas which validate strings, numbers and arrays only, so they hold
+// for values and let valueFrom objects pass.
+var scalarValidations = []string{
+	"pattern", "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf",
+	"minLength", "maxLength", "minItems", "maxItems", "uniqueItems",
+}
+
+// allowValueFrom makes the schema of an input accept valueFrom in place of the value. A structural schema can not
+// type one field by two types, so the input keeps unknown fields and the validations which do not apply to objects.
+// Types, enums and fields of object inputs are validated by the function after valueFrom is resolved.
+func allowValueFrom(schema map[string]interface{}) map[string]interface{} {
+	res := map[string]interface{}{
+		"x-kubernetes-preserve-unknown-fields": true,
+	}
+	for _, k := range append([]string{"default"}, scalarValidations...) {
+		if v, ok := schema[k]; ok {
+			res[k] = v
+		}
+	}
+	description := "The value"
+	if t, ok := schema["type"].(string); ok {
+		description = "The " + t + " value"
+	}
+	description += " or valueFrom with secretKeyRef or configMapKeyRef of an object labelled crossform.io/input-name and crossform.io/input-namespace."
+	if d, ok := schema["description"].(string); ok && d != "" {
+		description = strings.TrimSuffix(d, ".") + ". " + description
+	}
+	res["description"] = description
+	return res
+}
+
+// InputsSchema evaluates input declarations of the module into the OpenAPI v3 schema of XR spec.inputs.
+// Every input accepts valueFrom in place of the value, see allowValueFrom.
+func (e *Executor) InputsSchema() (map[string]interface{}, error) {
+	inputs := make(map[string]*Crossform)
+	for _, file := range e.executor.GetFileNames() {
+		for _, name := range e.executor.GetFields(file) {
+			m := e.executor.GetMetadataObject(file, name)
+			if m == nil || m.Type != "input" {
+				continue
+			}
+			cf, err := e.executor.GetCrossformObject(file, name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to evaluate input id=%s", m.Id)
+			}
+			if _, exist := inputs[m.Id]; exist {
+				return nil, errors.Errorf("input duplicate id=%s detected", m.Id)
+			}
+			inputs[m.Id] = cf
+		}
+	}
+	schema := inputsSchema(inputs)
+	properties := schema["properties"].(map[string]interface{})
+	for k, v := range properties {
+		properties[k] = allowValueFrom(v.(map[string]interface{}))
+	}
+	return schema, nil
+}
+
+// validateInputsSchema validates inputs of the XR against JSON schemas of declared inputs.
+func validateInputsSchema(inputs map[string]*Crossform, input map[string]interface{}) error {
+	schema := inputsSchema(inputs)
+	compiler := jsonschema.NewCompiler()
+	j, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+
+	if err := compiler.AddResource("https://crossform.io/inputs", strings.NewReader(string(j))); err != nil {
+		return err
+	}
+	sch, err := compiler.Compile("https://crossform.io/inputs")
+	if err != nil {
+		return err
+	}
+	if err = sch.Validate(input); err != nil {
+		return err
+	}
+	return nil
+}
+
+// outputsSchema makes the OpenAPI v3 schema of XR status.outputs out of the schemas of declared outputs.
+// Outputs without a schema accept any value. Outputs are never required, they are absent until evaluated.
+func outputsSchema(outputs map[string]map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for k, v := range outputs {
+		if v == nil {
+			properties[k] = map[string]interface{}{
+				"x-kubernetes-preserve-unknown-fields": true,
+			}
+			continue
+		}
+		properties[k] = v
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+// OutputsSchema collects output declarations of the module into the OpenAPI v3 schema of XR status.outputs.
+// Values of outputs are not evaluated.
+func (e *Executor) OutputsSchema() (map[string]interface{}, error) {
+	outputs := make(map[string]map[string]interface{})
+	for _, file := range e.executor.GetFileNames() {
+		for _, name := range e.executor.GetFields(file) {
+			m := e.executor.GetMetadataObject(file, name)
+			if m == nil || m.Type != "output" {
+				continue
+			}
+			schema, err := e.executor.GetOutputSchema(file, name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to evaluate schema of output id=%s", m.Id)
+			}
+			if _, exist := outputs[m.Id]; exist {
+				return nil, errors.Errorf("output duplicate id=%s detected", m.Id)
+			}
+			outputs[m.Id] = schema
+		}
+	}
+	return outputsSchema(outputs), nil
+}
+
+// declareOutputs publishes the schema of outputs declared by the module. Sensitive outputs are published
+// in connection details, they are not outputs of the status.
+func (e *Executor) declareOutputs(result *ExecResult) {
+	declared := make(map[string]map[string]interface{})
+	for k, v := range e.declaredOutputs {
+		if !e.sensitiveOutputs[k] {
+			declared[k] = v
+		}
+	}
+	if len(declared) > 0 {
+		result.OutputsSchema = outputsSchema(declared)
+	}
+}
+
+// OutputSchemaError is returned when the value of an output does not match the schema of the output.
+type OutputSchemaError struct {
+	Id  string
+	Err error
+}
+
+func (e *OutputSchemaError) Error() string {
+	return fmt.Sprintf("output %s does not match its schema: %v", e.Id, e.Err)
+}
+
+func (e *OutputSchemaError) Unwrap() error {
+	return e.Err
+}
+
+// validateOutputSchema validates the value of an output against the JSON schema of the output.
+func validateOutputSchema(id string, schema map[string]interface{}, value interface{}) error {
+	url := "https://crossform.io/outputs/" + id
+	j, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(url, strings.NewReader(string(j))); err != nil {
+		return errors.Wrapf(err, "invalid schema of output %s", id)
+	}
+	sch, err := compiler.Compile(url)
+	if err != nil {
+		return errors.Wrapf(err, "invalid schema of output %s", id)
+	}
+	// values of engines are validated as JSON, so that all kinds of numbers are accepted
+	j, err = json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return err
+	}
+	if err := sch.Validate(v); err != nil {
+		return &OutputSchemaError{Id: id, Err: err}
+	}
+	return nil
+}
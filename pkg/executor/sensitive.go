@@ -0,0 +1,81 @@
+package executor
+
+import (
+	"encoding/json"
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sort"
+)
+
+// sensitiveRedacted replaces details of schema errors of sensitive outputs, they may quote the value.
+const sensitiveRedacted = "the value is sensitive, details are redacted"
+
+// publishSensitive moves values of sensitive outputs and inputs into connection details of the XR, so that they
+// are not written to the status. A failing sensitive output keeps its previous connection detail, like other outputs
+// keep their previous values. A sensitive output overrides a sensitive input of the same name.
+func (e *Executor) publishSensitive(result *ExecResult) {
+	if len(e.sensitiveInputs) == 0 && len(e.sensitiveOutputs) == 0 {
+		return
+	}
+	details := make(map[string]string)
+	xrInputs, _, _ := unstructured.NestedMap(e.cmd.XR.Resource.Object, "spec", "inputs")
+	for id, cf := range e.sensitiveInputs {
+		result.SensitiveInputs = append(result.SensitiveInputs, id)
+		v, ok := xrInputs[id]
+		if !ok {
+			v, ok = cf.Schema["default"]
+		}
+		if !ok {
+			continue
+		}
+		if err := setConnectionDetail(details, id, v); err != nil {
+			e.log.Warn().Err(err).Str("id", id).Msg("unable to publish sensitive input")
+		}
+	}
+
+	for id := range e.sensitiveOutputs {
+		if v, ok := result.Outputs[id]; ok {
+			delete(result.Outputs, id)
+			if err := setConnectionDetail(details, id, v); err != nil {
+				result.OutputsErrors[id] = err
+				continue
+			}
+			result.SensitiveOutputs = append(result.SensitiveOutputs, id)
+			continue
+		}
+		err, ok := result.OutputsErrors[id]
+		if !ok {
+			continue
+		}
+		var schemaErr *OutputSchemaError
+		isSchemaErr := errors.As(err, &schemaErr)
+		if isSchemaErr {
+			result.OutputsErrors[id] = &OutputSchemaError{Id: id, Err: errors.New(sensitiveRedacted)}
+		}
+		if previous, ok := e.cmd.XR.ConnectionDetails[id]; ok {
+			e.log.Debug().Str("id", id).Err(err).Msg("sensitive output previous state found")
+			details[id] = string(previous)
+			result.SensitiveOutputs = append(result.SensitiveOutputs, id)
+			if !isSchemaErr {
+				delete(result.OutputsErrors, id)
+			}
+		}
+	}
+	sort.Strings(result.SensitiveOutputs)
+	sort.Strings(result.SensitiveInputs)
+	result.ConnectionDetails = details
+}
+
+// setConnectionDetail keeps strings as they are and encodes other values to JSON.
+func setConnectionDetail(details map[string]string, id string, v interface{}) error {
+	if s, ok := v.(string); ok {
+		details[id] = s
+		return nil
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "unable to encode connection detail %s", id)
+	}
+	details[id] = string(j)
+	return nil
+}
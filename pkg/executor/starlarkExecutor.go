@@ -0,0 +1,579 @@
+package executor
+
+import (
+	"bytes"
+	"crossform.io/pkg/logger"
+	"encoding/json"
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/guregu/null/v5"
+	"github.com/rs/zerolog"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+	"k8s.io/apimachinery/pkg/runtime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const starlarkFileKey = "crossform.file"
+
+// starlarkDeclaration is a resource, request, input or output declared by a builtin.
+// Resources and outputs are evaluated lazily, so a failing one does not fail the whole module.
+type starlarkDeclaration struct {
+	crossform *Crossform
+	value     starlark.Value
+	dependOn  []string
+
+	evaluated bool
+	result    interface{}
+	deps      []*Dependency
+	err       error
+}
+
+type starlarkFile struct {
+	docs   map[string]*starlarkDeclaration
+	fields []string
+}
+
+type starlarkExecutor struct {
+	log         zerolog.Logger
+	path        string
+	observed    map[string]interface{}
+	predeclared starlark.StringDict
+	files       map[string]*starlarkFile
+	// reads collects dependencies of the function being evaluated
+	reads  map[Dependency]bool
+	budget *budget
+	// threads are running threads, they are cancelled when the budget is exceeded
+	threadsMu sync.Mutex
+	threads   map[*starlark.Thread]bool
+	cancelled error
+}
+
+func init() {
+	Register(&engine{
+		name:   "starlark",
+		detect: detectByGlob("*.star"),
+		new:    newStarlarkExecutor,
+	})
+}
+
+func newStarlarkExecutor(path, observed, requested, xr, context string, env *Environment) (ModuleExecutor, error) {
+	e := &starlarkExecutor{
+		log: logger.GetLogger("starlarkExecutor").With().
+			Str("directory", path).
+			Logger(),
+		path:    path,
+		files:   make(map[string]*starlarkFile),
+		budget:  env.budget,
+		threads: make(map[*starlark.Thread]bool),
+	}
+	files, err := filepath.Glob(filepath.Join(path, "*.star"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	sort.Strings(files)
+	env.budget.onExceeded(e.cancel)
+
+	if err := e.makePredeclared(observed, requested, xr, context); err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if err := e.execFile(file); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func decodeJsonNumbers(name, data string) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(data)))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.Wrapf(err, "unable to unmarshal %s", name)
+	}
+	return v, nil
+}
+
+func (e *starlarkExecutor) makePredeclared(observed, requested, xr, context string) error {
+	e.predeclared = starlark.StringDict{
+		"resource": starlark.NewBuiltin("resource", e.resourceBuiltin),
+		"request":  starlark.NewBuiltin("request", e.requestBuiltin),
+		"input":    starlark.NewBuiltin("input", e.inputBuiltin),
+		"output":   starlark.NewBuiltin("output", e.outputBuiltin),
+		"is_ready": starlark.NewBuiltin("is_ready", e.isReadyBuiltin),
+		"struct":   starlark.NewBuiltin("struct", starlarkstruct.Make),
+	}
+	for name, data := range map[string]string{"observed": observed, "requested": requested, "xr": xr, "context": context} {
+		v, err := decodeJsonNumbers(name, data)
+		if err != nil {
+			return err
+		}
+		sv, err := toStarlark(v)
+		if err != nil {
+			return errors.Wrapf(err, "unable to convert %s", name)
+		}
+		e.predeclared[name] = sv
+	}
+	// plain values are used for merging and readiness, json numbers are converted by a round trip
+	var obs map[string]interface{}
+	if err := json.Unmarshal([]byte(observed), &obs); err != nil {
+		return errors.Wrap(err, "unable to unmarshal observed")
+	}
+	e.observed = obs
+
+	// reads of the observed status and of requested resources are dependencies
+	e.predeclared["observed"] = &trackingDict{
+		Dict: e.predeclared["observed"].(*starlark.Dict),
+		onGet: func(id string, v starlark.Value, found bool) starlark.Value {
+			if !found {
+				e.read(dependencySourceObserved, id)
+				return v
+			}
+			d, ok := v.(*starlark.Dict)
+			if !ok {
+				return v
+			}
+			return &trackingDict{Dict: d, onGet: func(key string, v starlark.Value, found bool) starlark.Value {
+				if key == "status" {
+					e.read(dependencySourceObserved, id)
+				}
+				return v
+			}}
+		},
+	}
+	e.predeclared["requested"] = &trackingDict{
+		Dict: e.predeclared["requested"].(*starlark.Dict),
+		onGet: func(id string, v starlark.Value, found bool) starlark.Value {
+			e.read(dependencySourceRequested, id)
+			return v
+		},
+	}
+	return nil
+}
+
+func (e *starlarkExecutor) read(source, id string) {
+	if e.reads != nil {
+		e.reads[Dependency{Source: source, Id: id}] = true
+	}
+}
+
+// newThread makes a sandboxed thread, load is not supported and execution steps are limited by the budget.
+// The returned function has to be called with the result of the thread when it finishes.
+func (e *starlarkExecutor) newThread(name string) (*starlark.Thread, func(err error)) {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(thread *starlark.Thread, msg string) {
+			e.log.Debug().Str("thread", thread.Name).Msg(msg)
+		},
+	}
+	maxSteps := e.budget.limits.MaxSteps
+	if maxSteps > 0 {
+		thread.SetMaxExecutionSteps(maxSteps)
+	}
+	e.threadsMu.Lock()
+	if e.cancelled != nil {
+		thread.Cancel(e.cancelled.Error())
+	}
+	e.threads[thread] = true
+	e.threadsMu.Unlock()
+	return thread, func(err error) {
+		e.threadsMu.Lock()
+		delete(e.threads, thread)
+		e.threadsMu.Unlock()
+		if err != nil && maxSteps > 0 && thread.ExecutionSteps() >= maxSteps {
+			e.budget.exceed(&BudgetExceededError{Budget: "steps", Limit: strconv.FormatUint(maxSteps, 10)})
+		}
+	}
+}
+
+// cancel interrupts running threads when the budget of the evaluation is exceeded.
+func (e *starlarkExecutor) cancel(err error) {
+	e.threadsMu.Lock()
+	defer e.threadsMu.Unlock()
+	e.cancelled = err
+	for thread := range e.threads {
+		thread.Cancel(err.Error())
+	}
+}
+
+// starlarkError adds the starlark backtrace to evaluation errors.
+func starlarkError(err error) error {
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return errors.New(evalErr.Backtrace())
+	}
+	return err
+}
+
+func (e *starlarkExecutor) execFile(file string) error {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	f := &starlarkFile{docs: make(map[string]*starlarkDeclaration), fields: make([]string, 0)}
+	thread, done := e.newThread(filepath.Base(file))
+	thread.SetLocal(starlarkFileKey, f)
+	opts := &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true, Recursion: true}
+	_, err = starlark.ExecFileOptions(opts, thread, filepath.Base(file), src, e.predeclared)
+	done(err)
+	if err != nil {
+		e.log.Warn().Err(err).Str("file", file).Msg("unable to execute starlark file")
+		return errors.Wrapf(starlarkError(err), "unable to execute %s", file)
+	}
+	e.files[file] = f
+	return nil
+}
+
+func (e *starlarkExecutor) declare(thread *starlark.Thread, b *starlark.Builtin, d *starlarkDeclaration) error {
+	f, ok := thread.Local(starlarkFileKey).(*starlarkFile)
+	if !ok {
+		return errors.Errorf("%s: declarations are allowed at the top level of a module only", b.Name())
+	}
+	field := d.crossform.Metadata.Type + "/" + d.crossform.Metadata.Id
+	if _, exist := f.docs[field]; exist {
+		return errors.Errorf("%s: duplicate id=%s", b.Name(), d.crossform.Metadata.Id)
+	}
+	f.docs[field] = d
+	f.fields = append(f.fields, field)
+	return nil
+}
+
+// resourceBuiltin implements resource(id, obj, ready=None, dependOn=None). The object is a dict,
+// or a function returning a dict or None to defer the resource.
+func (e *starlarkExecutor) resourceBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var id string
+	var obj starlark.Value
+	var ready starlark.Value = starlark.None
+	var dependOn *starlark.List
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "id", &id, "obj", &obj, "ready?", &ready, "dependOn?", &dependOn); err != nil {
+		return nil, err
+	}
+	d := &starlarkDeclaration{
+		crossform: &Crossform{Metadata: Metadata{Id: id, Type: "resource"}},
+		value:     obj,
+	}
+	switch r := ready.(type) {
+	case starlark.NoneType:
+	case starlark.Bool:
+		d.crossform.Ready = null.BoolFrom(bool(r))
+	default:
+		return nil, errors.Errorf("%s: ready should be a bool, got %s", b.Name(), ready.Type())
+	}
+	if dependOn != nil {
+		for i := 0; i < dependOn.Len(); i++ {
+			s, ok := starlark.AsString(dependOn.Index(i))
+			if !ok {
+				return nil, errors.Errorf("%s: dependOn should be a list of ids", b.Name())
+			}
+			d.dependOn = append(d.dependOn, s)
+		}
+	}
+	if err := e.declare(thread, b, d); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+// requestBuiltin implements request(id, apiVersion, kind, selector), the selector is a name or labels.
+// It returns a struct with the result, a requested resource for names and a list for labels.
+func (e *starlarkExecutor) requestBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var id, apiVersion, kind string
+	var selector starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "id", &id, "apiVersion", &apiVersion, "kind", &kind, "selector", &selector); err != nil {
+		return nil, err
+	}
+	r := &Request{ApiVersion: apiVersion, Kind: kind}
+	var def starlark.Value
+	switch s := selector.(type) {
+	case starlark.String:
+		r.Name = string(s)
+		def = starlark.NewDict(0)
+	case *starlark.Dict:
+		labels, err := fromStarlark(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: invalid labels", b.Name())
+		}
+		r.Labels = make(map[string]string)
+		for k, v := range labels.(map[string]interface{}) {
+			str, ok := v.(string)
+			if !ok {
+				return nil, errors.Errorf("%s: label %s should be a string", b.Name(), k)
+			}
+			r.Labels[k] = str
+		}
+		def = starlark.NewList(nil)
+	default:
+		return nil, errors.Errorf("%s: selector should be a name or labels, got %s", b.Name(), selector.Type())
+	}
+	d := &starlarkDeclaration{crossform: &Crossform{Metadata: Metadata{Id: id, Type: "request"}, Request: r}}
+	if err := e.declare(thread, b, d); err != nil {
+		return nil, err
+	}
+
+	result, found, err := e.predeclared["requested"].(starlark.Mapping).Get(starlark.String(id))
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		result = def
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{"result": result}), nil
+}
+
+// inputBuiltin implements input(name, type=None, description=None, default=None, schema=None, sensitive=False)
+// and returns a struct with the provided value, or the default.
+func (e *starlarkExecutor) inputBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, typ, description string
+	var def starlark.Value = starlark.None
+	var schema *starlark.Dict
+	var sensitive bool
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "type?", &typ, "description?", &description, "default?", &def, "schema?", &schema, "sensitive?", &sensitive); err != nil {
+		return nil, err
+	}
+	if (typ == "object" || typ == "array") && schema == nil {
+		return nil, errors.Errorf("%s: you have to define schema for complex types e.g. object, array", b.Name())
+	}
+	if schema != nil && (typ != "" || description != "") {
+		return nil, errors.Errorf("%s: if you define schema, parameters type and description are not allowed", b.Name())
+	}
+
+	cf := &Crossform{Metadata: Metadata{Id: name, Type: "input"}, Sensitive: sensitive}
+	if schema != nil {
+		s, err := fromStarlark(schema)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: invalid schema", b.Name())
+		}
+		cf.Schema = s.(map[string]interface{})
+	} else if typ != "" {
+		cf.Schema = map[string]interface{}{"type": typ}
+		if description != "" {
+			cf.Schema["description"] = description
+		}
+	}
+	if cf.Schema != nil && def != starlark.None {
+		d, err := fromStarlark(def)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: invalid default", b.Name())
+		}
+		cf.Schema["default"] = d
+	}
+	if err := e.declare(thread, b, &starlarkDeclaration{crossform: cf}); err != nil {
+		return nil, err
+	}
+
+	value := def
+	spec, _, _ := e.predeclared["xr"].(*starlark.Dict).Get(starlark.String("spec"))
+	if spec, ok := spec.(*starlark.Dict); ok {
+		inputs, _, _ := spec.Get(starlark.String("inputs"))
+		if inputs, ok := inputs.(*starlark.Dict); ok {
+			if v, found, _ := inputs.Get(starlark.String(name)); found {
+				value = v
+			}
+		}
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{"value": value}), nil
+}
+
+// outputBuiltin implements output(id, value, schema=None, sensitive=False), the value may be a function evaluated lazily.
+func (e *starlarkExecutor) outputBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var id string
+	var value starlark.Value
+	var schema *starlark.Dict
+	var sensitive bool
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "id", &id, "value", &value, "schema?", &schema, "sensitive?", &sensitive); err != nil {
+		return nil, err
+	}
+	d := &starlarkDeclaration{crossform: &Crossform{Metadata: Metadata{Id: id, Type: "output"}, Sensitive: sensitive}, value: value}
+	if schema != nil {
+		s, err := fromStarlark(schema)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: invalid schema", b.Name())
+		}
+		d.crossform.Schema = s.(map[string]interface{})
+	}
+	if err := e.declare(thread, b, d); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+// isReadyBuiltin implements is_ready(*ids), observed resources are ready when Ready and Synced conditions are true.
+func (e *starlarkExecutor) isReadyBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(kwargs) > 0 {
+		return nil, errors.Errorf("%s: unexpected keyword arguments", b.Name())
+	}
+	for _, arg := range args {
+		id, ok := starlark.AsString(arg)
+		if !ok {
+			return nil, errors.Errorf("%s: ids should be strings, got %s", b.Name(), arg.Type())
+		}
+		e.read(dependencySourceObserved, id)
+		o, ok := e.observed[id].(map[string]interface{})
+		if !ok || !conditionsTrue(o) {
+			return starlark.False, nil
+		}
+	}
+	return starlark.True, nil
+}
+
+// evaluate calls the function of a resource or an output once, collecting the dependencies it reads.
+func (e *starlarkExecutor) evaluate(d *starlarkDeclaration) {
+	if d.evaluated {
+		return
+	}
+	d.evaluated = true
+	value := d.value
+	e.reads = make(map[Dependency]bool)
+	defer func() {
+		for dep := range e.reads {
+			dep := dep
+			if d.crossform.Metadata.Type == "resource" && dep.Source == dependencySourceObserved && dep.Id == d.crossform.Metadata.Id {
+				continue
+			}
+			d.deps = append(d.deps, &dep)
+		}
+		e.reads = nil
+	}()
+	if fn, ok := value.(starlark.Callable); ok {
+		thread, done := e.newThread(d.crossform.Metadata.Id)
+		v, err := starlark.Call(thread, fn, nil, nil)
+		done(err)
+		if err != nil {
+			d.err = starlarkError(err)
+			return
+		}
+		value = v
+	}
+	d.result, d.err = fromStarlark(value)
+}
+
+func (e *starlarkExecutor) getDeclaration(fileName, field string) (*starlarkDeclaration, error) {
+	f, ok := e.files[fileName]
+	if !ok {
+		return nil, errors.Errorf("unable to find file. file=%s", fileName)
+	}
+	d, ok := f.docs[field]
+	if !ok {
+		return nil, errors.Errorf("unable to find field. file=%s field=%s", fileName, field)
+	}
+	return d, nil
+}
+
+func (e *starlarkExecutor) GetFileNames() []string {
+	files := make([]string, 0, len(e.files))
+	for f := range e.files {
+		files = append(files, f)
+	}
+	return files
+}
+
+func (e *starlarkExecutor) GetFields(fileName string) []string {
+	f, ok := e.files[fileName]
+	if !ok {
+		return nil
+	}
+	return f.fields
+}
+
+func (e *starlarkExecutor) GetMetadataObject(fileName, field string) *Metadata {
+	d, err := e.getDeclaration(fileName, field)
+	if err != nil {
+		return nil
+	}
+	return &d.crossform.Metadata
+}
+
+func (e *starlarkExecutor) GetCrossformObject(fileName, field string) (*Crossform, error) {
+	d, err := e.getDeclaration(fileName, field)
+	if err != nil {
+		return nil, err
+	}
+	if d.crossform.Metadata.Type == "output" {
+		e.evaluate(d)
+		if d.err != nil {
+			e.log.Warn().Err(d.err).Str("field", field).Msg("unable to evaluate output")
+			return nil, d.err
+		}
+		d.crossform.Output = d.result
+	}
+	return d.crossform, nil
+}
+
+func (e *starlarkExecutor) GetOutputSchema(fileName, field string) (map[string]interface{}, error) {
+	d, err := e.getDeclaration(fileName, field)
+	if err != nil {
+		return nil, err
+	}
+	return d.crossform.Schema, nil
+}
+
+func (e *starlarkExecutor) ValidateInputs(inputs map[string]*Crossform, input map[string]interface{}) error {
+	return validateInputsSchema(inputs, input)
+}
+
+// GetResource evaluates a resource and merges it into the observed one, as resources of the jsonnet library do.
+// A resource function returning None defers the resource.
+func (e *starlarkExecutor) GetResource(fileName, field string) (map[string]interface{}, bool, resource.Ready, error) {
+	d, err := e.getDeclaration(fileName, field)
+	if err != nil {
+		return nil, false, resource.ReadyUnspecified, err
+	}
+	id := d.crossform.Metadata.Id
+	e.evaluate(d)
+	if d.err != nil {
+		e.log.Warn().Err(d.err).Str("id", id).Msg("error evaluating resource")
+		return nil, false, resource.ReadyUnspecified, d.err
+	}
+	if d.result == nil {
+		return nil, true, resource.ReadyUnspecified, nil
+	}
+	obj, ok := d.result.(map[string]interface{})
+	if !ok {
+		return nil, false, resource.ReadyUnspecified, errors.Errorf("resource %s should be a dict", id)
+	}
+
+	observed, _ := e.observed[id].(map[string]interface{})
+	if observed != nil {
+		obj = mergePatch(runtime.DeepCopyJSON(observed), runtime.DeepCopyJSON(obj)).(map[string]interface{})
+	}
+	ready := resource.ReadyFalse
+	if d.crossform.Ready.Valid && d.crossform.Ready.Bool || !d.crossform.Ready.Valid && observed != nil && conditionsTrue(observed) {
+		ready = resource.ReadyTrue
+	}
+	return obj, false, ready, nil
+}
+
+// GetDependencies returns resources of dependOn and reads of resource and output functions,
+// reads at the top level of a file are not attributed to declarations.
+func (e *starlarkExecutor) GetDependencies(fileName, field string) ([]*Dependency, error) {
+	d, err := e.getDeclaration(fileName, field)
+	if err != nil {
+		return nil, err
+	}
+	e.evaluate(d)
+	found := make(map[Dependency]bool)
+	res := make([]*Dependency, 0)
+	add := func(dep Dependency) {
+		if !found[dep] {
+			found[dep] = true
+			res = append(res, &dep)
+		}
+	}
+	for _, id := range d.dependOn {
+		add(Dependency{Source: dependencySourceObserved, Id: id})
+	}
+	for _, dep := range d.deps {
+		add(*dep)
+	}
+	sortDependencies(res)
+	return res, nil
+}
@@ -0,0 +1,168 @@
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"math/big"
+	"sort"
+)
+
+// toStarlark converts a json value decoded with UseNumber into a frozen starlark value.
+func toStarlark(v interface{}) (starlark.Value, error) {
+	switch t := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(t), nil
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return starlark.MakeInt64(i), nil
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return starlark.Float(f), nil
+	case float64:
+		return starlark.Float(t), nil
+	case string:
+		return starlark.String(t), nil
+	case []interface{}:
+		items := make([]starlark.Value, 0, len(t))
+		for _, item := range t {
+			sv, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, sv)
+		}
+		l := starlark.NewList(items)
+		l.Freeze()
+		return l, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		d := starlark.NewDict(len(t))
+		for _, k := range keys {
+			sv, err := toStarlark(t[k])
+			if err != nil {
+				return nil, err
+			}
+			if err := d.SetKey(starlark.String(k), sv); err != nil {
+				return nil, err
+			}
+		}
+		d.Freeze()
+		return d, nil
+	}
+	return nil, fmt.Errorf("unsupported value type %T", v)
+}
+
+// fromStarlark converts a starlark value into a json value, dict keys have to be strings.
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch t := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(t), nil
+	case starlark.Int:
+		if i, ok := t.Int64(); ok {
+			return float64(i), nil
+		}
+		f, _ := new(big.Float).SetInt(t.BigInt()).Float64()
+		return f, nil
+	case starlark.Float:
+		return float64(t), nil
+	case starlark.String:
+		return string(t), nil
+	case *starlark.List:
+		return fromStarlarkIterable(t)
+	case starlark.Tuple:
+		return fromStarlarkIterable(t)
+	case *trackingDict:
+		return fromStarlark(t.Dict)
+	case *starlark.Dict:
+		res := make(map[string]interface{}, t.Len())
+		for _, item := range t.Items() {
+			k, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("dict key %s is not a string", item[0])
+			}
+			value, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			res[string(k)] = value
+		}
+		return res, nil
+	case *starlarkstruct.Struct:
+		d := make(starlark.StringDict)
+		t.ToStringDict(d)
+		res := make(map[string]interface{}, len(d))
+		for k, item := range d {
+			value, err := fromStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			res[k] = value
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("unable to convert %s to json", v.Type())
+}
+
+func fromStarlarkIterable(v starlark.Iterable) ([]interface{}, error) {
+	res := make([]interface{}, 0)
+	iter := v.Iterate()
+	defer iter.Done()
+	var item starlark.Value
+	for iter.Next(&item) {
+		value, err := fromStarlark(item)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, value)
+	}
+	return res, nil
+}
+
+// trackingDict is a read only dict which reports reads of its keys, it is used to detect dependencies.
+type trackingDict struct {
+	*starlark.Dict
+	onGet func(key string, value starlark.Value, found bool) starlark.Value
+}
+
+var _ starlark.Mapping = (*trackingDict)(nil)
+
+func (d *trackingDict) Get(k starlark.Value) (starlark.Value, bool, error) {
+	v, found, err := d.Dict.Get(k)
+	if err != nil {
+		return nil, false, err
+	}
+	if s, ok := k.(starlark.String); ok {
+		v = d.onGet(string(s), v, found)
+	}
+	return v, found, nil
+}
+
+func (d *trackingDict) Attr(name string) (starlark.Value, error) {
+	if name != "get" {
+		return d.Dict.Attr(name)
+	}
+	return starlark.NewBuiltin("get", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var key, def starlark.Value = nil, starlark.None
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &key, &def); err != nil {
+			return nil, err
+		}
+		v, found, err := d.Get(key)
+		if err != nil || !found {
+			return def, err
+		}
+		return v, nil
+	}), nil
+}
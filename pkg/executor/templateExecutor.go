@@ -0,0 +1,310 @@
+package executor
+
+import (
+	"bytes"
+	"crossform.io/pkg/logger"
+	"encoding/json"
+	"github.com/Masterminds/sprig/v3"
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/guregu/null/v5"
+	"github.com/rs/zerolog"
+	"k8s.io/apimachinery/pkg/runtime"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const templateDependOnAnnotation = "crossform.io/depend-on"
+
+// templateFrontMatter is the crossform key of a rendered document, it declares the metadata of the document.
+type templateFrontMatter struct {
+	Id       string                 `json:"id"`
+	Type     string                 `json:"type"`
+	Ready    null.Bool              `json:"ready"`
+	DependOn []string               `json:"dependOn"`
+	Request  *Request               `json:"request"`
+	Output   interface{}            `json:"output"`
+	Schema   map[string]interface{} `json:"schema"`
+	// Sensitive outputs and inputs are published as connection details of the XR
+	Sensitive bool `json:"sensitive"`
+}
+
+type templateDocument struct {
+	crossform *Crossform
+	resource  map[string]interface{}
+	dependOn  []string
+}
+
+type templateExecutor struct {
+	log      zerolog.Logger
+	path     string
+	observed map[string]interface{}
+	docs     map[string]map[string]*templateDocument
+	fields   map[string][]string
+}
+
+func init() {
+	Register(&engine{
+		name:   "template",
+		detect: detectByGlob("*.tpl", "*.yaml.tmpl"),
+		new:    newTemplateExecutor,
+	})
+}
+
+func newTemplateExecutor(path, observed, requested, xr, context string, env *Environment) (ModuleExecutor, error) {
+	e := &templateExecutor{
+		log: logger.GetLogger("templateExecutor").With().
+			Str("directory", path).
+			Logger(),
+		path:   path,
+		docs:   make(map[string]map[string]*templateDocument),
+		fields: make(map[string][]string),
+	}
+	files := make([]string, 0)
+	for _, p := range []string{"*.tpl", "*.yaml.tmpl"} {
+		f, err := filepath.Glob(filepath.Join(path, p))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f...)
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	sort.Strings(files)
+
+	values := make(map[string]interface{})
+	for name, v := range map[string]string{"observed": observed, "requested": requested, "xr": xr, "context": context} {
+		var value interface{}
+		if err := json.Unmarshal([]byte(v), &value); err != nil {
+			return nil, errors.Wrapf(err, "unable to unmarshal %s", name)
+		}
+		values[name] = value
+	}
+	e.observed, _ = values["observed"].(map[string]interface{})
+	xrSpec, _ := values["xr"].(map[string]interface{})["spec"].(map[string]interface{})
+	values["inputs"], _ = xrSpec["inputs"].(map[string]interface{})
+
+	for _, file := range files {
+		if err := e.renderFile(file, values); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// funcs are sprig functions together with helpers known from helm and the jsonnet library.
+func (e *templateExecutor) funcs() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	funcs["toYaml"] = func(v interface{}) (string, error) {
+		y, err := yaml.Marshal(v)
+		return strings.TrimSuffix(string(y), "\n"), err
+	}
+	funcs["fromYaml"] = func(s string) (map[string]interface{}, error) {
+		res := make(map[string]interface{})
+		err := yaml.Unmarshal([]byte(s), &res)
+		return res, err
+	}
+	// isReady reports whether all the observed resources have Ready and Synced conditions
+	funcs["isReady"] = func(ids ...string) bool {
+		for _, id := range ids {
+			o, ok := e.observed[id].(map[string]interface{})
+			if !ok || !conditionsTrue(o) {
+				return false
+			}
+		}
+		return true
+	}
+	return funcs
+}
+
+func (e *templateExecutor) renderFile(file string, values map[string]interface{}) error {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	tpl, err := template.New(filepath.Base(file)).Funcs(e.funcs()).Parse(string(src))
+	if err != nil {
+		return errors.Wrapf(err, "unable to parse template %s", file)
+	}
+	var out bytes.Buffer
+	if err := tpl.Execute(&out, values); err != nil {
+		e.log.Warn().Err(err).Str("file", file).Msg("unable to render template")
+		return errors.Wrapf(err, "unable to render template %s", file)
+	}
+	objects, err := decodeYamlDocuments(&out, file)
+	if err != nil {
+		return err
+	}
+
+	e.docs[file] = make(map[string]*templateDocument)
+	e.fields[file] = make([]string, 0)
+	for i, obj := range objects {
+		doc, err := newTemplateDocument(obj)
+		if err != nil {
+			return errors.Wrapf(err, "document %d of %s", i, file)
+		}
+		meta := doc.crossform.Metadata
+		field := meta.Type + "/" + meta.Id
+		if _, exist := e.docs[file][field]; exist {
+			return errors.Errorf("%s duplicate id=%s detected in %s", meta.Type, meta.Id, file)
+		}
+		e.docs[file][field] = doc
+		e.fields[file] = append(e.fields[file], field)
+	}
+	return nil
+}
+
+// newTemplateDocument reads metadata of a rendered document from its crossform key,
+// or from crossform.io annotations of a resource.
+func newTemplateDocument(obj map[string]interface{}) (*templateDocument, error) {
+	var fm templateFrontMatter
+	if v, ok := obj["crossform"]; ok {
+		j, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(j, &fm); err != nil {
+			return nil, errors.Wrap(err, "unable to unmarshal crossform key")
+		}
+		delete(obj, "crossform")
+	}
+
+	meta, _ := obj["metadata"].(map[string]interface{})
+	annotations, _ := meta["annotations"].(map[string]interface{})
+	if id, ok := annotations[yamlIdAnnotation].(string); ok && fm.Id == "" {
+		fm.Id = id
+	}
+	if ready, ok := annotations[yamlReadyAnnotation].(string); ok && !fm.Ready.Valid {
+		b, err := strconv.ParseBool(ready)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s annotation of resource %s", yamlReadyAnnotation, fm.Id)
+		}
+		fm.Ready = null.BoolFrom(b)
+	}
+	if dependOn, ok := annotations[templateDependOnAnnotation].(string); ok && len(fm.DependOn) == 0 {
+		for _, id := range strings.Split(dependOn, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				fm.DependOn = append(fm.DependOn, id)
+			}
+		}
+	}
+	for k := range annotations {
+		if strings.HasPrefix(k, "crossform.io/") {
+			delete(annotations, k)
+		}
+	}
+	if annotations != nil && len(annotations) == 0 {
+		delete(meta, "annotations")
+	}
+
+	if fm.Id == "" {
+		return nil, errors.Errorf("document has neither crossform.id nor %s annotation", yamlIdAnnotation)
+	}
+	if fm.Type == "" {
+		fm.Type = "resource"
+	}
+	doc := &templateDocument{
+		crossform: &Crossform{
+			Metadata:  Metadata{Id: fm.Id, Type: fm.Type},
+			Ready:     fm.Ready,
+			Request:   fm.Request,
+			Output:    fm.Output,
+			Schema:    fm.Schema,
+			Sensitive: fm.Sensitive,
+		},
+		dependOn: fm.DependOn,
+	}
+	switch fm.Type {
+	case "resource":
+		doc.resource = obj
+	case "request":
+		if fm.Request == nil {
+			return nil, errors.Errorf("request %s has no crossform.request", fm.Id)
+		}
+	case "output", "input":
+	default:
+		return nil, errors.Errorf("unknown type %s of %s", fm.Type, fm.Id)
+	}
+	return doc, nil
+}
+
+func (e *templateExecutor) GetFileNames() []string {
+	files := make([]string, 0, len(e.fields))
+	for f := range e.fields {
+		files = append(files, f)
+	}
+	return files
+}
+
+func (e *templateExecutor) GetFields(fileName string) []string {
+	return e.fields[fileName]
+}
+
+func (e *templateExecutor) GetMetadataObject(fileName, field string) *Metadata {
+	doc, ok := e.docs[fileName][field]
+	if !ok {
+		return nil
+	}
+	return &doc.crossform.Metadata
+}
+
+func (e *templateExecutor) GetCrossformObject(fileName, field string) (*Crossform, error) {
+	doc, ok := e.docs[fileName][field]
+	if !ok {
+		return nil, errors.Errorf("unable to find field. file=%s field=%s", fileName, field)
+	}
+	return doc.crossform, nil
+}
+
+func (e *templateExecutor) GetOutputSchema(fileName, field string) (map[string]interface{}, error) {
+	doc, ok := e.docs[fileName][field]
+	if !ok {
+		return nil, errors.Errorf("unable to find field. file=%s field=%s", fileName, field)
+	}
+	return doc.crossform.Schema, nil
+}
+
+func (e *templateExecutor) ValidateInputs(inputs map[string]*Crossform, input map[string]interface{}) error {
+	return validateInputsSchema(inputs, input)
+}
+
+// GetResource merges a rendered resource into the observed one, as resources of the jsonnet library do.
+func (e *templateExecutor) GetResource(fileName, field string) (map[string]interface{}, bool, resource.Ready, error) {
+	doc, ok := e.docs[fileName][field]
+	if !ok {
+		return nil, false, resource.ReadyUnspecified, errors.Errorf("unable to find field. file=%s field=%s", fileName, field)
+	}
+	id := doc.crossform.Metadata.Id
+	obj := runtime.DeepCopyJSON(doc.resource)
+	observed, _ := e.observed[id].(map[string]interface{})
+	if observed != nil {
+		obj = mergePatch(runtime.DeepCopyJSON(observed), obj).(map[string]interface{})
+	}
+
+	ready := resource.ReadyFalse
+	if doc.crossform.Ready.Valid && doc.crossform.Ready.Bool || !doc.crossform.Ready.Valid && observed != nil && conditionsTrue(observed) {
+		ready = resource.ReadyTrue
+	}
+	return obj, false, ready, nil
+}
+
+// GetDependencies returns resources declared by dependOn, templates are rendered before the evaluation,
+// so reads of other resources can not be detected.
+func (e *templateExecutor) GetDependencies(fileName, field string) ([]*Dependency, error) {
+	doc, ok := e.docs[fileName][field]
+	if !ok {
+		return nil, errors.Errorf("unable to find field. file=%s field=%s", fileName, field)
+	}
+	res := make([]*Dependency, 0, len(doc.dependOn))
+	for _, id := range doc.dependOn {
+		res = append(res, &Dependency{Source: dependencySourceObserved, Id: id})
+	}
+	sortDependencies(res)
+	return res, nil
+}
@@ -0,0 +1,6 @@
+package tags
+
+#tags: {
+	Environment: string | *"dev"
+	ManagedBy:   "crossform"
+}
@@ -0,0 +1,6 @@
+package labels
+
+common: {
+	team:      "platform"
+	managedBy: "crossform"
+}
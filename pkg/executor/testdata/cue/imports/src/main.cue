@@ -0,0 +1,14 @@
+package test
+
+import "example.com/infra/libs/labels"
+
+bucket: #resource & {
+	_id:        "bucket"
+	apiVersion: "s3.aws.upbound.io/v1beta1"
+	kind:       "Bucket"
+	metadata: {
+		name:     "test-cue-imports-bucket"
+		"labels": labels.common
+	}
+	spec: forProvider: region: "eu-west-1"
+}
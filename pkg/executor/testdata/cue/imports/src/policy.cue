@@ -0,0 +1,15 @@
+package test
+
+import "example.com/tags"
+
+policy: #resource & {
+	_id:        "policy"
+	apiVersion: "s3.aws.upbound.io/v1beta1"
+	kind:       "BucketPolicy"
+	metadata: name: "test-cue-imports-policy"
+	spec: forProvider: {
+		bucket: "test-cue-imports-bucket"
+		region: "eu-west-1"
+		"tags": tags.#tags & {Environment: "prod"}
+	}
+}
@@ -0,0 +1,36 @@
+package test
+
+nameInput: #input & {
+  _name: "name"
+  _type: =~"^[a-z][a-z0-9-]*$"
+}
+
+replicasInput: #input & {
+  _name: "replicas"
+  _type: int & >=1 & <=10 | *3
+}
+
+sizeInput: #input & {
+  _name: "size"
+  _type: "small" | "medium" | "large"
+}
+
+zoneInput: #input & {
+  _name: "zone"
+  _type: string
+}
+
+namespace: #resource & {
+  _id: "namespace"
+  apiVersion: "kubernetes.crossplane.io/v1alpha2"
+  kind: "Object"
+  metadata: name: nameInput.value
+  spec: forProvider: manifest: {
+    apiVersion: "v1"
+    kind: "Namespace"
+    metadata: labels: {
+      replicas: "\(replicasInput.value)"
+      size: sizeInput.value
+    }
+  }
+}
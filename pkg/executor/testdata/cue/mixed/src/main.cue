@@ -0,0 +1,6 @@
+package test
+
+output1: #output & {
+  _id: "output1"
+  _value: "cue"
+}
@@ -0,0 +1,25 @@
+package main
+
+network: #module & {
+	_id:         "network"
+	_repository: "https://github.com/zefir01/crossform.git"
+	_revision:   "main"
+	_path:       "examples/modules/vpc"
+	_inputs: cidr: "10.100.0.0/16"
+}
+
+eks: #module & {
+	_id:         "eks"
+	_repository: "https://github.com/zefir01/crossform.git"
+	_revision:   "main"
+	_path:       "examples/modules/eks"
+	_inputs: {
+		vpcId:          network.#outputs.vpcId
+		privateSubnets: network.#outputs.privateSubnets
+	}
+}
+
+vpcId: #output & {
+	_id:    "vpcId"
+	_value: network.#outputs.vpcId
+}
@@ -0,0 +1,43 @@
+package test
+
+import (
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/hex"
+  "encoding/yaml"
+  "regexp"
+  "uuid"
+)
+
+vpc: #resource & {
+  _id: "vpc"
+  apiVersion: "ec2.aws.upbound.io/v1beta1"
+  kind: "VPC"
+  metadata: name: "test-cue-natives-vpc"
+  spec: forProvider: {
+    cidrBlock: "10.0.0.0/16"
+    region: "eu-west-1"
+  }
+}
+
+subnet: #output & {
+  _id: "subnet"
+  _value: (#cidrSubnet & {_prefix: vpc.spec.forProvider.cidrBlock, _newbits: 8, _netnum: 2}).out
+}
+
+hosts: #output & {
+  _id: "hosts"
+  _value: [(#cidrHost & {_prefix: "10.0.2.0/24", _hostnum: 5}).out, (#cidrHost & {_prefix: "10.0.2.0/24", _hostnum: -2}).out]
+}
+
+encoded: #output & {
+  _id: "encoded"
+  _value: {
+    encoded: base64.Encode(null, "crossform")
+    digest: hex.Encode(sha256.Sum256("crossform"))
+    match: regexp.Match("^vpc-[0-9a-f]+$", vpc.status.atProvider.id)
+    replace: regexp.ReplaceAll("^vpc-(.*)$", vpc.status.atProvider.id, "id-$1")
+    manifest: yaml.Marshal({a: true})
+    id: uuid.SHA1(uuid.ns.DNS, "crossform.io")
+  }
+}
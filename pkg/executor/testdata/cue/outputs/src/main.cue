@@ -0,0 +1,27 @@
+package main
+
+vpcId: #output & {
+	_id:    "vpcId"
+	_value: "vpc-0123456789"
+	_type:  string & =~"^vpc-[0-9a-f]+$"
+}
+
+subnets: #output & {
+	_id: "subnets"
+	_value: ["subnet-0a", "subnet-0b"]
+	_type: [...string]
+}
+
+endpoint: #output & {
+	_id: "endpoint"
+	_value: {host: "db.example.com", port: 5432}
+	_type: {
+		host: string
+		port: int & >=1 & <=65535
+	}
+}
+
+note: #output & {
+	_id:    "note"
+	_value: "untyped"
+}
@@ -0,0 +1,24 @@
+package main
+
+dbPassword: #input & {
+	_name:      "dbPassword"
+	_type:      string
+	_sensitive: true
+}
+
+dbUser: #input & {
+	_name:      "dbUser"
+	_type:      string | *"admin"
+	_sensitive: true
+}
+
+endpoint: #output & {
+	_id:    "endpoint"
+	_value: "db.example.com"
+}
+
+connection: #output & {
+	_id: "connection"
+	_value: {user: dbUser.value, password: dbPassword.value}
+	_sensitive: true
+}
@@ -0,0 +1,21 @@
+crossform:
+  id: cidr
+  type: input
+  schema:
+    type: string
+---
+crossform:
+  id: zones
+  type: input
+  schema:
+    type: integer
+    minimum: 1
+    default: 2
+---
+crossform:
+  id: cluster-config
+  type: request
+  request:
+    apiVersion: v1
+    kind: ConfigMap
+    name: {{ .xr.metadata.name }}-cluster
@@ -0,0 +1,581 @@
+package executor
+
+import (
+	"bytes"
+	"crossform.io/pkg/logger"
+	"encoding/json"
+	"fmt"
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/guregu/null/v5"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+	"io"
+	"k8s.io/apimachinery/pkg/runtime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	yamlApiVersion          = "crossform.io/v1alpha1"
+	yamlIdAnnotation        = "crossform.io/id"
+	yamlReadyAnnotation     = "crossform.io/ready"
+	yamlSensitiveAnnotation = "crossform.io/sensitive"
+)
+
+// yamlExpression matches ${path} references, $${ escapes a literal ${.
+var yamlExpression = regexp.MustCompile(`\$?\$\{\s*([^}]*?)\s*}`)
+
+// unavailableError is returned for references to observed or requested resources which do not exist yet.
+type unavailableError struct {
+	ref string
+}
+
+func (e *unavailableError) Error() string {
+	return fmt.Sprintf("%s is not available yet", e.ref)
+}
+
+type yamlDocument struct {
+	metadata *Metadata
+	// object is the resource for resources, and the spec for inputs, outputs and requests
+	object    map[string]interface{}
+	ready     null.Bool
+	sensitive bool
+}
+
+type yamlExecutor struct {
+	log    zerolog.Logger
+	path   string
+	values map[string]interface{}
+	docs   map[string]map[string]*yamlDocument
+	fields map[string][]string
+	inputs map[string]*yamlDocument
+}
+
+func init() {
+	Register(&engine{
+		name:   "yaml",
+		detect: detectYaml,
+		new:    newYamlExecutor,
+	})
+}
+
+func yamlFiles(path string) ([]string, error) {
+	files := make([]string, 0)
+	for _, p := range []string{"*.yaml", "*.yml"} {
+		f, err := filepath.Glob(filepath.Join(path, p))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range f {
+			if filepath.Base(file) != manifestFile {
+				files = append(files, file)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// detectYaml detects modules by crossform annotations and kinds, plain yaml files are common in other modules.
+func detectYaml(path string) (bool, error) {
+	files, err := yamlFiles(path)
+	if err != nil {
+		return false, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return false, err
+		}
+		if bytes.Contains(data, []byte(yamlIdAnnotation)) || bytes.Contains(data, []byte("apiVersion: "+yamlApiVersion)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func newYamlExecutor(path, observed, requested, xr, context string, env *Environment) (ModuleExecutor, error) {
+	e := &yamlExecutor{
+		log: logger.GetLogger("yamlExecutor").With().
+			Str("directory", path).
+			Logger(),
+		path:   path,
+		values: make(map[string]interface{}),
+		docs:   make(map[string]map[string]*yamlDocument),
+		fields: make(map[string][]string),
+		inputs: make(map[string]*yamlDocument),
+	}
+	files, err := yamlFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	for name, v := range map[string]string{"observed": observed, "requested": requested, "xr": xr, "context": context} {
+		var value interface{}
+		if err := json.Unmarshal([]byte(v), &value); err != nil {
+			return nil, errors.Wrapf(err, "unable to unmarshal %s", name)
+		}
+		e.values[name] = value
+	}
+
+	for _, file := range files {
+		if err := e.loadFile(file); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (e *yamlExecutor) loadFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	objects, err := decodeYamlDocuments(f, file)
+	if err != nil {
+		return err
+	}
+
+	e.docs[file] = make(map[string]*yamlDocument)
+	e.fields[file] = make([]string, 0)
+	for i, obj := range objects {
+		doc, err := newYamlDocument(obj)
+		if err != nil {
+			return errors.Wrapf(err, "document %d of %s", i, file)
+		}
+		field := doc.metadata.Type + "/" + doc.metadata.Id
+		if _, exist := e.docs[file][field]; exist {
+			return errors.Errorf("%s duplicate id=%s detected in %s", doc.metadata.Type, doc.metadata.Id, file)
+		}
+		e.docs[file][field] = doc
+		e.fields[file] = append(e.fields[file], field)
+		if doc.metadata.Type == "input" {
+			e.inputs[doc.metadata.Id] = doc
+		}
+	}
+	return nil
+}
+
+// decodeYamlDocuments decodes all documents of a multi-document yaml, empty documents are skipped.
+// Values are normalized to json ones, as produced by other engines.
+func decodeYamlDocuments(r io.Reader, file string) ([]map[string]interface{}, error) {
+	res := make([]map[string]interface{}, 0)
+	dec := yaml.NewDecoder(r)
+	for i := 0; ; i++ {
+		var raw map[string]interface{}
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to decode document %d of %s", i, file)
+		}
+		if raw == nil {
+			continue
+		}
+		j, err := json.Marshal(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to marshal document %d of %s", i, file)
+		}
+		var obj map[string]interface{}
+		if err := json.Unmarshal(j, &obj); err != nil {
+			return nil, errors.Wrapf(err, "unable to unmarshal document %d of %s", i, file)
+		}
+		res = append(res, obj)
+	}
+}
+
+// newYamlDocument makes a document out of a crossform Input, Output or Request, or out of a resource
+// with the crossform.io/id annotation.
+func newYamlDocument(obj map[string]interface{}) (*yamlDocument, error) {
+	meta, _ := obj["metadata"].(map[string]interface{})
+	spec, _ := obj["spec"].(map[string]interface{})
+	if spec == nil {
+		spec = make(map[string]interface{})
+	}
+	if obj["apiVersion"] == yamlApiVersion {
+		name, _ := meta["name"].(string)
+		if name == "" {
+			return nil, errors.New("metadata.name is required")
+		}
+		annotations, _ := meta["annotations"].(map[string]interface{})
+		sensitive := false
+		if s, ok := annotations[yamlSensitiveAnnotation].(string); ok {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid %s annotation of %s", yamlSensitiveAnnotation, name)
+			}
+			sensitive = b
+		}
+		switch obj["kind"] {
+		case "Input":
+			return &yamlDocument{metadata: &Metadata{Id: name, Type: "input"}, object: spec, sensitive: sensitive}, nil
+		case "Output":
+			return &yamlDocument{metadata: &Metadata{Id: name, Type: "output"}, object: spec, sensitive: sensitive}, nil
+		case "Request":
+			return &yamlDocument{metadata: &Metadata{Id: name, Type: "request"}, object: spec}, nil
+		}
+	}
+
+	annotations, _ := meta["annotations"].(map[string]interface{})
+	id, _ := annotations[yamlIdAnnotation].(string)
+	if id == "" {
+		return nil, errors.Errorf("resource %v/%v has no %s annotation", obj["kind"], meta["name"], yamlIdAnnotation)
+	}
+	doc := &yamlDocument{metadata: &Metadata{Id: id, Type: "resource"}, object: obj}
+	if ready, ok := annotations[yamlReadyAnnotation].(string); ok {
+		b, err := strconv.ParseBool(ready)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s annotation of resource %s", yamlReadyAnnotation, id)
+		}
+		doc.ready = null.BoolFrom(b)
+	}
+	for k := range annotations {
+		if strings.HasPrefix(k, "crossform.io/") {
+			delete(annotations, k)
+		}
+	}
+	if len(annotations) == 0 {
+		delete(meta, "annotations")
+	}
+	return doc, nil
+}
+
+func (e *yamlExecutor) GetFileNames() []string {
+	files := make([]string, 0, len(e.fields))
+	for f := range e.fields {
+		files = append(files, f)
+	}
+	return files
+}
+
+func (e *yamlExecutor) GetFields(fileName string) []string {
+	return e.fields[fileName]
+}
+
+func (e *yamlExecutor) GetMetadataObject(fileName, field string) *Metadata {
+	doc, ok := e.docs[fileName][field]
+	if !ok {
+		return nil
+	}
+	return doc.metadata
+}
+
+func (e *yamlExecutor) GetCrossformObject(fileName, field string) (*Crossform, error) {
+	doc, ok := e.docs[fileName][field]
+	if !ok {
+		return nil, errors.Errorf("unable to find field. file=%s field=%s", fileName, field)
+	}
+	cf := &Crossform{Metadata: *doc.metadata, Sensitive: doc.sensitive}
+	switch doc.metadata.Type {
+	case "resource":
+		cf.Ready = doc.ready
+	case "input":
+		if len(doc.object) > 0 {
+			cf.Schema = doc.object
+		}
+	case "output":
+		v, err := e.substitute(runtime.DeepCopyJSONValue(doc.object["value"]))
+		if err != nil {
+			e.log.Warn().Err(err).Str("field", field).Msg("unable to evaluate output")
+			return nil, errors.Wrapf(err, "unable to evaluate output. file=%s field=%s", fileName, field)
+		}
+		cf.Output = v
+	case "request":
+		v, err := e.substitute(runtime.DeepCopyJSONValue(doc.object))
+		if err != nil {
+			e.log.Warn().Err(err).Str("field", field).Msg("unable to evaluate request")
+			return nil, errors.Wrapf(err, "unable to evaluate request. file=%s field=%s", fileName, field)
+		}
+		j, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		cf.Request = &Request{}
+		if err := json.Unmarshal(j, cf.Request); err != nil {
+			return nil, errors.Wrapf(err, "unable to unmarshal request. file=%s field=%s", fileName, field)
+		}
+	}
+	return cf, nil
+}
+
+// GetOutputSchema returns spec.schema of an Output.
+func (e *yamlExecutor) GetOutputSchema(fileName, field string) (map[string]interface{}, error) {
+	doc, ok := e.docs[fileName][field]
+	if !ok {
+		return nil, errors.Errorf("unable to find field. file=%s field=%s", fileName, field)
+	}
+	schema, _ := doc.object["schema"].(map[string]interface{})
+	return schema, nil
+}
+
+func (e *yamlExecutor) ValidateInputs(inputs map[string]*Crossform, input map[string]interface{}) error {
+	return validateInputsSchema(inputs, input)
+}
+
+// GetResource substitutes references of a resource and merges it into the observed one.
+// A resource referencing observed or requested resources which do not exist yet is deferred.
+func (e *yamlExecutor) GetResource(fileName, field string) (map[string]interface{}, bool, resource.Ready, error) {
+	doc, ok := e.docs[fileName][field]
+	if !ok {
+		return nil, false, resource.ReadyUnspecified, errors.Errorf("unable to find field. file=%s field=%s", fileName, field)
+	}
+	id := doc.metadata.Id
+	v, err := e.substitute(runtime.DeepCopyJSON(doc.object))
+	var unavailable *unavailableError
+	if errors.As(err, &unavailable) {
+		e.log.Debug().Err(err).Str("id", id).Msg("resource deferred")
+		return nil, true, resource.ReadyUnspecified, nil
+	}
+	if err != nil {
+		e.log.Warn().Err(err).Str("id", id).Msg("error evaluating resource")
+		return nil, false, resource.ReadyUnspecified, errors.Wrapf(err, "unable to evaluate resource. file=%s field=%s", fileName, field)
+	}
+
+	observed, _ := e.values["observed"].(map[string]interface{})[id].(map[string]interface{})
+	var obj map[string]interface{}
+	if observed != nil {
+		obj = mergePatch(runtime.DeepCopyJSONValue(observed), v).(map[string]interface{})
+	} else {
+		obj = v.(map[string]interface{})
+	}
+
+	ready := resource.ReadyFalse
+	if doc.ready.Valid && doc.ready.Bool || !doc.ready.Valid && observed != nil && conditionsTrue(observed) {
+		ready = resource.ReadyTrue
+	}
+	return obj, false, ready, nil
+}
+
+// GetDependencies detects dependencies statically by references to the status of observed resources,
+// to requested resources and to inputs.
+func (e *yamlExecutor) GetDependencies(fileName, field string) ([]*Dependency, error) {
+	doc, ok := e.docs[fileName][field]
+	if !ok {
+		return nil, errors.Errorf("unable to find field. file=%s field=%s", fileName, field)
+	}
+	found := make(map[Dependency]bool)
+	walkStrings(doc.object, func(s string) {
+		for _, m := range yamlExpression.FindAllStringSubmatch(s, -1) {
+			if strings.HasPrefix(m[0], "$$") {
+				continue
+			}
+			path, err := parseYamlPath(m[1])
+			if err != nil {
+				continue
+			}
+			switch {
+			case len(path) > 2 && path[0] == "observed" && path[2] == "status":
+				if doc.metadata.Type != "resource" || path[1] != doc.metadata.Id {
+					found[Dependency{Source: dependencySourceObserved, Id: path[1]}] = true
+				}
+			case len(path) > 1 && path[0] == "requested":
+				found[Dependency{Source: dependencySourceRequested, Id: path[1]}] = true
+			case len(path) > 1 && path[0] == "inputs":
+				found[Dependency{Source: dependencySourceInput, Id: path[1]}] = true
+			case len(path) > 3 && path[0] == "xr" && path[1] == "spec" && path[2] == "inputs":
+				found[Dependency{Source: dependencySourceInput, Id: path[3]}] = true
+			}
+		}
+	})
+	res := make([]*Dependency, 0, len(found))
+	for d := range found {
+		d := d
+		res = append(res, &d)
+	}
+	sortDependencies(res)
+	return res, nil
+}
+
+// substitute replaces references in all strings of a value. A string consisting of one reference only
+// is replaced by the referenced value as is, otherwise referenced values are formatted into the string.
+func (e *yamlExecutor) substitute(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, vv := range t {
+			res, err := e.substitute(vv)
+			if err != nil {
+				return nil, err
+			}
+			t[k] = res
+		}
+		return t, nil
+	case []interface{}:
+		for i, vv := range t {
+			res, err := e.substitute(vv)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = res
+		}
+		return t, nil
+	case string:
+		if m := yamlExpression.FindStringSubmatchIndex(t); m != nil && m[0] == 0 && m[1] == len(t) && !strings.HasPrefix(t, "$$") {
+			return e.resolve(t[m[2]:m[3]])
+		}
+		var resolveErr error
+		res := yamlExpression.ReplaceAllStringFunc(t, func(s string) string {
+			if strings.HasPrefix(s, "$$") {
+				return s[1:]
+			}
+			value, err := e.resolve(yamlExpression.FindStringSubmatch(s)[1])
+			if err != nil {
+				resolveErr = err
+				return s
+			}
+			if str, ok := value.(string); ok {
+				return str
+			}
+			j, err := json.Marshal(value)
+			if err != nil {
+				resolveErr = err
+				return s
+			}
+			return string(j)
+		})
+		return res, resolveErr
+	}
+	return v, nil
+}
+
+// resolve evaluates a reference, e.g. observed.vpc.status.atProvider.id, requested.cluster.metadata.name,
+// inputs.replicas or xr.metadata.labels["example.com/team"].
+func (e *yamlExecutor) resolve(ref string) (interface{}, error) {
+	path, err := parseYamlPath(ref)
+	if err != nil {
+		return nil, err
+	}
+	var current interface{}
+	rest := path[1:]
+	switch path[0] {
+	case "inputs":
+		if len(path) < 2 {
+			return nil, errors.Errorf("%s: input name is required", ref)
+		}
+		current, err = e.inputValue(path[1])
+		if err != nil {
+			return nil, err
+		}
+		rest = path[2:]
+	case "observed", "requested", "xr", "context":
+		current = e.values[path[0]]
+	default:
+		return nil, errors.Errorf("%s: unknown reference root %s", ref, path[0])
+	}
+
+	for i, segment := range rest {
+		var ok bool
+		switch t := current.(type) {
+		case map[string]interface{}:
+			current, ok = t[segment]
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if ok = err == nil && index >= 0 && index < len(t); ok {
+				current = t[index]
+			}
+		}
+		if !ok {
+			// observed and requested resources appear eventually, which defers the dependent resource
+			if path[0] == "observed" || path[0] == "requested" {
+				return nil, &unavailableError{ref: ref}
+			}
+			return nil, errors.Errorf("%s: field %s not found", ref, strings.Join(rest[:i+1], "."))
+		}
+	}
+	return current, nil
+}
+
+func (e *yamlExecutor) inputValue(name string) (interface{}, error) {
+	xr, _ := e.values["xr"].(map[string]interface{})
+	spec, _ := xr["spec"].(map[string]interface{})
+	inputs, _ := spec["inputs"].(map[string]interface{})
+	if v, ok := inputs[name]; ok {
+		return v, nil
+	}
+	doc, ok := e.inputs[name]
+	if !ok {
+		return nil, errors.Errorf("input %s is not declared", name)
+	}
+	if v, ok := doc.object["default"]; ok {
+		return v, nil
+	}
+	return nil, errors.Errorf("input %s is not provided", name)
+}
+
+// parseYamlPath splits a reference into segments, both a.b and a["b"] selectors and [0] indexes are supported.
+func parseYamlPath(ref string) ([]string, error) {
+	path := make([]string, 0)
+	s := ref
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, errors.Errorf("%s: unclosed [", ref)
+			}
+			segment := strings.TrimSpace(s[1:end])
+			if unquoted, err := strconv.Unquote(segment); err == nil {
+				segment = unquoted
+			}
+			path = append(path, segment)
+			s = s[end+1:]
+		default:
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			path = append(path, s[:end])
+			s = s[end:]
+		}
+	}
+	if len(path) == 0 {
+		return nil, errors.New("empty reference")
+	}
+	return path, nil
+}
+
+func walkStrings(v interface{}, f func(s string)) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, vv := range t {
+			walkStrings(vv, f)
+		}
+	case []interface{}:
+		for _, vv := range t {
+			walkStrings(vv, f)
+		}
+	case string:
+		f(t)
+	}
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to the target.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
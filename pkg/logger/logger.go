@@ -3,6 +3,7 @@ package logger
 import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/pkgerrors"
+	"io"
 	"os"
 	"time"
 )
@@ -10,8 +11,12 @@ import (
 var Logger zerolog.Logger
 
 func InitLog() {
+	InitLogWriter(os.Stdout)
+}
+
+func InitLogWriter(out io.Writer) {
 	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
-	Logger = zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}).
+	Logger = zerolog.New(zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339}).
 		With().Stack().Caller().Timestamp().Logger()
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 }
@@ -6,7 +6,9 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"github.com/pkg/errors"
+	"github.com/whilp/git-urls"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
@@ -14,21 +16,61 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"os"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"strings"
 	"time"
 )
 
+// DefaultUpdatePeriod is the period of polling repositories for updates.
+var DefaultUpdatePeriod = time.Second * 30
+
+// WebhookUpdatePeriod is the default period of polling when push webhooks are enabled. Webhooks refresh repositories
+// immediately, polling is the fallback for missed deliveries and repositories without webhooks.
+const WebhookUpdatePeriod = time.Minute * 3
+
+// Update policies of modules.
+const (
+	// UpdatePolicyAuto follows the revision.
+	UpdatePolicyAuto = "Auto"
+	// UpdatePolicyPaused freezes the module at the commit of its last evaluation.
+	UpdatePolicyPaused = "Paused"
+)
+
 type Config struct {
 	Url          string
 	Revision     string
 	UpdatePeriod time.Duration
-	Hash         string
-	Path         string
+	// Paused freezes the module at the commit of its last evaluation, updates of the checkout stop when all of its
+	// modules are paused
+	Paused bool
+	// Module is the name of the module using the checkout, it is empty for imports
+	Module string
+	Hash   string
+	Path   string
 }
 
+// SameSettings reports whether the configs use the same checkout with the same update settings.
+func (c *Config) SameSettings(other *Config) bool {
+	return c.Hash == other.Hash && c.UpdatePeriod == other.UpdatePeriod && c.Paused == other.Paused
+}
+
+// KnownHostsConfigMap names the ConfigMap in WATCH_NAMESPACE with known hosts of all repositories in the key
+// ssh_known_hosts, it is optional.
+var KnownHostsConfigMap = "crossform-known-hosts"
+
 type AuthData struct {
 	PrivateKey string
+	// Passphrase decrypts PrivateKey
+	Passphrase string
 	Username   string
 	Password   string
+	// BearerToken authenticates https requests instead of Username and Password
+	BearerToken string
+	// CABundle are PEM certificates trusted by https requests in addition to system ones
+	CABundle string
+	// KnownHosts are known_hosts lines of the repository, they add to the known hosts ConfigMap
+	KnownHosts string
+	// InsecureIgnoreHostKey skips verification of the host key, it is an explicit opt-in of the secret
+	InsecureIgnoreHostKey bool
 }
 
 func (c *Config) hash() string {
@@ -36,11 +78,62 @@ func (c *Config) hash() string {
 	return hex.EncodeToString(hash[:])
 }
 
+// Matches reports whether a push of the ref to one of the urls changes the revision, urls are compared after
+// NormalizeUrl. Commit revisions never change, semver ranges change by pushes of tags in them.
+func (c *Config) Matches(urls []string, ref string) bool {
+	name := strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")
+	if name != c.Revision && !c.inRange(ref) {
+		return false
+	}
+	url := NormalizeUrl(c.Url)
+	for _, u := range urls {
+		if u != "" && NormalizeUrl(u) == url {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) inRange(ref string) bool {
+	if !strings.HasPrefix(ref, "refs/tags/") {
+		return false
+	}
+	r, ok := semverRange(c.Revision)
+	if !ok {
+		return false
+	}
+	return highestTag(r, []string{strings.TrimPrefix(ref, "refs/tags/")}) != ""
+}
+
+// NormalizeUrl reduces https, ssh and scp-like urls of the same repository to one form: the lower cased host and
+// path without the .git suffix, credentials and ports are dropped. Local paths keep their case.
+func NormalizeUrl(url string) string {
+	parsed, err := giturls.Parse(url)
+	if err != nil {
+		return url
+	}
+	path := strings.TrimSuffix(strings.TrimSuffix(parsed.Path, "/"), ".git")
+	if parsed.Host == "" {
+		return path
+	}
+	return strings.ToLower(parsed.Hostname() + "/" + strings.TrimPrefix(path, "/"))
+}
+
+// Secret types of repository credentials.
+const (
+	// SecretTypeRepository is a secret of one repository, its key repository is the url of the repository
+	SecretTypeRepository = "repository"
+	// SecretTypeRepoCreds is a credential template, it applies to repositories whose urls start with its key url
+	SecretTypeRepoCreds = "repo-creds"
+)
+
+// GetSecretData returns credentials of the repository: a repository secret of its url or otherwise the credential
+// template with the longest url prefix of it. It returns nil when no secret applies.
 func (c *Config) GetSecretData() (*AuthData, error) {
 	log := logger.GetLogger("repositoryConfig").With().Str("url", c.Url).Str("revision", c.Revision).Logger()
 
 	clusterClient, _ := kubernetes.NewForConfig(ctrl.GetConfigOrDie())
-	l, _ := labels.NewRequirement("crossform.io/secret-type", selection.Equals, []string{"repository"})
+	l, _ := labels.NewRequirement("crossform.io/secret-type", selection.In, []string{SecretTypeRepository, SecretTypeRepoCreds})
 	selector := labels.NewSelector()
 	selector = selector.Add(*l)
 	o := metav1.ListOptions{
@@ -55,61 +148,140 @@ func (c *Config) GetSecretData() (*AuthData, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to get secrets")
 	}
-	var s *corev1.Secret = nil
-	for _, v := range secrets.Items {
-		if string(v.Data["repository"]) == c.Url {
-			s = &v
-			break
-		}
-	}
+	s := selectSecret(c.Url, secrets.Items)
 	if s == nil {
 		log.Debug().Msg("unable to find secret for repository")
 		return nil, nil
 	}
+	log.Debug().Str("secret", s.Name).Msg("secret for repository found")
+	return newAuthData(c.Url, s)
+}
 
-	tmp, _ := s.Data["sshPrivateKey"]
-	key := string(tmp)
-	tmp, _ = s.Data["username"]
-	username := string(tmp)
-	tmp, _ = s.Data["password"]
-	pass := string(tmp)
-
-	if key != "" && (username != "" || pass != "") {
-		return nil, errors.Errorf("incorrect secret for repository %s, ssh key and username/password specified together", c.Url)
-	}
-	if key == "" && username == "" {
-		return nil, errors.Errorf("incorrect secret for repository %s, username is empty", c.Url)
+// selectSecret picks the repository secret of the url, otherwise the credential template with the longest prefix
+// of the url, see hasPathPrefix.
+func selectSecret(url string, secrets []corev1.Secret) *corev1.Secret {
+	var s *corev1.Secret = nil
+	prefix := 0
+	for i := range secrets {
+		v := &secrets[i]
+		switch v.Labels["crossform.io/secret-type"] {
+		case SecretTypeRepository:
+			if string(v.Data["repository"]) == url {
+				return v
+			}
+		case SecretTypeRepoCreds:
+			p := string(v.Data["url"])
+			if p != "" && hasPathPrefix(url, p) && len(p) > prefix {
+				s = v
+				prefix = len(p)
+			}
+		}
 	}
-	if key == "" && username == "" {
-		return nil, errors.Errorf("incorrect secret for repository %s, password is empty", c.Url)
+	return s
+}
+
+// hasPathPrefix reports whether the prefix ends at a path boundary of the url: it ends with / (or : of scp like
+// ssh urls), or it is followed by /. So the template of https://host/infra does not match https://host/infra-secret.
+func hasPathPrefix(url, prefix string) bool {
+	if !strings.HasPrefix(url, prefix) {
+		return false
 	}
-	if key == "" && username == "" && pass == "" {
-		return nil, errors.Errorf("incorrect secret for repository %s, credentials are empty", c.Url)
+	if strings.HasSuffix(prefix, "/") || strings.HasSuffix(prefix, ":") || len(url) == len(prefix) {
+		return true
 	}
+	return url[len(prefix)] == '/'
+}
 
+func newAuthData(url string, s *corev1.Secret) (*AuthData, error) {
 	get := func(key string) string {
 		v, _ := s.Data[key]
 		return string(v)
 	}
-
 	data := AuthData{
-		PrivateKey: get("sshPrivateKey"),
-		Username:   get("username"),
-		Password:   get("password"),
+		PrivateKey:            get("sshPrivateKey"),
+		Passphrase:            get("sshPrivateKeyPassphrase"),
+		Username:              get("username"),
+		Password:              get("password"),
+		BearerToken:           get("bearerToken"),
+		CABundle:              get("caBundle"),
+		KnownHosts:            get("knownHosts"),
+		InsecureIgnoreHostKey: get("insecureIgnoreHostKey") == "true",
+	}
+
+	if data.PrivateKey != "" && (data.Username != "" || data.Password != "") {
+		return nil, errors.Errorf("incorrect secret for repository %s, ssh key and username/password specified together", url)
+	}
+	if data.BearerToken != "" && (data.PrivateKey != "" || data.Username != "" || data.Password != "") {
+		return nil, errors.Errorf("incorrect secret for repository %s, bearer token and other credentials specified together", url)
+	}
+	if data.PrivateKey == "" && data.BearerToken == "" {
+		if data.Username == "" && data.Password != "" {
+			return nil, errors.Errorf("incorrect secret for repository %s, username is empty", url)
+		}
+		if data.Username != "" && data.Password == "" {
+			return nil, errors.Errorf("incorrect secret for repository %s, password is empty", url)
+		}
+		if data.Username == "" && data.CABundle == "" && data.KnownHosts == "" && !data.InsecureIgnoreHostKey {
+			return nil, errors.Errorf("incorrect secret for repository %s, credentials are empty", url)
+		}
 	}
 	return &data, nil
 }
 
+// GetKnownHosts returns known_hosts lines of the known hosts ConfigMap, it is empty when the ConfigMap is missing.
+func GetKnownHosts() (string, error) {
+	if KnownHostsConfigMap == "" {
+		return "", nil
+	}
+	clusterClient, _ := kubernetes.NewForConfig(ctrl.GetConfigOrDie())
+	ns, found := os.LookupEnv("WATCH_NAMESPACE")
+	if !found {
+		return "", errors.New("environment variable WATCH_NAMESPACE is required")
+	}
+	cm, err := clusterClient.CoreV1().ConfigMaps(ns).Get(context.TODO(), KnownHostsConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "unable to get known hosts")
+	}
+	return cm.Data["ssh_known_hosts"], nil
+}
+
 func NewConfig(module *unstructured.Unstructured) (*Config, error) {
 	m := module.Object
 	spec := m["spec"].(map[string]interface{})
 
+	config := NewRevisionConfig(spec["repository"].(string), spec["revision"].(string))
+	config.Module = module.GetName()
+	if v, ok := spec["updatePeriod"].(string); ok && v != "" {
+		period, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid updatePeriod of module %s", config.Module)
+		}
+		if period <= 0 {
+			return nil, errors.Errorf("invalid updatePeriod of module %s, it must be positive", config.Module)
+		}
+		config.UpdatePeriod = period
+	}
+	switch policy, _ := spec["updatePolicy"].(string); policy {
+	case "", UpdatePolicyAuto:
+	case UpdatePolicyPaused:
+		config.Paused = true
+	default:
+		return nil, errors.Errorf("unknown updatePolicy %s of module %s", policy, config.Module)
+	}
+	return config, nil
+}
+
+// NewRevisionConfig makes a config of the revision of the repository, the checkout path is derived from them.
+func NewRevisionConfig(url, revision string) *Config {
 	config := Config{
-		Url:          spec["repository"].(string),
-		Revision:     spec["revision"].(string),
-		UpdatePeriod: time.Second * 30,
+		Url:          url,
+		Revision:     revision,
+		UpdatePeriod: DefaultUpdatePeriod,
 	}
 	config.Hash = config.hash()
 	config.Path = "repos/" + config.Hash
-	return &config, nil
+	return &config
 }
@@ -0,0 +1,70 @@
+package repo
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func newSecret(name, secretType string, data map[string]string) corev1.Secret {
+	s := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"crossform.io/secret-type": secretType},
+		},
+		Data: map[string][]byte{},
+	}
+	for k, v := range data {
+		s.Data[k] = []byte(v)
+	}
+	return s
+}
+
+func TestSelectSecret(t *testing.T) {
+	secrets := []corev1.Secret{
+		newSecret("host", SecretTypeRepoCreds, map[string]string{"url": "https://gitlab.example.com/", "bearerToken": "t1"}),
+		newSecret("group", SecretTypeRepoCreds, map[string]string{"url": "https://gitlab.example.com/infra/", "bearerToken": "t2"}),
+		newSecret("subgroup", SecretTypeRepoCreds, map[string]string{"url": "https://gitlab.example.com/infra/network/", "bearerToken": "t3"}),
+		newSecret("exact", SecretTypeRepository, map[string]string{"repository": "https://gitlab.example.com/infra/network/vpc.git", "username": "u", "password": "p"}),
+		newSecret("ssh", SecretTypeRepoCreds, map[string]string{"url": "git@gitlab.example.com:infra/", "sshPrivateKey": "key"}),
+		newSecret("apps", SecretTypeRepoCreds, map[string]string{"url": "https://gitlab.example.com/apps", "bearerToken": "t4"}),
+	}
+	cases := map[string]string{
+		"https://gitlab.example.com/infra/network/vpc.git": "exact",
+		"https://gitlab.example.com/infra/network/nat.git": "subgroup",
+		"https://gitlab.example.com/infra/dns.git":         "group",
+		"https://gitlab.example.com/apps/web.git":          "apps",
+		"https://gitlab.example.com/apps-secret/web.git":   "host",
+		"https://gitlab.example.com/infrastructure.git":    "host",
+		"git@gitlab.example.com:infra/dns.git":             "ssh",
+		"https://github.com/example/infra.git":             "",
+	}
+	for url, expected := range cases {
+		name := ""
+		if s := selectSecret(url, secrets); s != nil {
+			name = s.Name
+		}
+		if name != expected {
+			t.Errorf("%s: expected secret %q, got %q", url, expected, name)
+		}
+	}
+
+	invalid := map[string]map[string]string{
+		"ssh key and password": {"sshPrivateKey": "key", "password": "p"},
+		"token and username":   {"bearerToken": "t", "username": "u"},
+		"password only":        {"password": "p"},
+		"username only":        {"username": "u"},
+		"no credentials":       {"url": "https://gitlab.example.com/"},
+	}
+	for name, data := range invalid {
+		s := newSecret(name, SecretTypeRepoCreds, data)
+		if _, err := newAuthData("https://gitlab.example.com/infra/dns.git", &s); err == nil {
+			t.Errorf("%s: secret accepted", name)
+		}
+	}
+	s := newSecret("ca", SecretTypeRepoCreds, map[string]string{"url": "https://git.internal/", "caBundle": "pem"})
+	data, err := newAuthData("https://git.internal/infra.git", &s)
+	if err != nil || data.CABundle != "pem" {
+		t.Errorf("secret with a CA bundle only is rejected: %v", err)
+	}
+}
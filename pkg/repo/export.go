@@ -0,0 +1,74 @@
+package repo
+
+import (
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ResolveRevision resolves a commit sha, tag, local or remote branch to a commit.
+func ResolveRevision(r *git.Repository, revision string) (*object.Commit, error) {
+	candidates := []string{revision, "refs/remotes/origin/" + revision}
+	for _, c := range candidates {
+		hash, err := r.ResolveRevision(plumbing.Revision(c))
+		if err != nil {
+			continue
+		}
+		return r.CommitObject(*hash)
+	}
+	return nil, errors.Errorf("unable to resolve revision %s", revision)
+}
+
+// ExportRevision writes the tree of the given revision into dir and returns the commit sha. Symlinks and executable
+// files are kept like in a checkout, submodules are skipped.
+func ExportRevision(r *git.Repository, revision, dir string) (string, error) {
+	commit, err := ResolveRevision(r, revision)
+	if err != nil {
+		return "", err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to get tree of %s", revision)
+	}
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if f.Mode == filemode.Submodule {
+			return nil
+		}
+		path := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if f.Mode == filemode.Symlink {
+			target, err := f.Contents()
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, path)
+		}
+		mode, err := f.Mode.ToOSFileMode()
+		if err != nil {
+			return err
+		}
+		reader, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		_, err = io.Copy(out, reader)
+		return err
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to export revision %s", revision)
+	}
+	return commit.Hash.String(), nil
+}
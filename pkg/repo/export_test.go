@@ -0,0 +1,72 @@
+package repo
+
+import (
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExportRevision(t *testing.T) {
+	work := t.TempDir()
+	r, err := git.PlainInit(work, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(work, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(work, "lib", "main.jsonnet"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(work, "generate.sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("lib/main.jsonnet", filepath.Join(work, "main.jsonnet")); err != nil {
+		t.Fatal(err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddGlob("."); err != nil {
+		t.Fatal(err)
+	}
+	sha, err := w.Commit("init", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	exported, err := ExportRevision(r, sha.String(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported != sha.String() {
+		t.Fatalf("exported %s instead of %s", exported, sha)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "main.jsonnet")); err != nil || target != "lib/main.jsonnet" {
+		t.Fatalf("symlink is not exported: %q %v", target, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "main.jsonnet")); err != nil || string(data) != "{}" {
+		t.Fatalf("symlink does not resolve: %q %v", data, err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "generate.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0100 == 0 {
+		t.Fatalf("executable mode is dropped: %s", info.Mode())
+	}
+	info, err = os.Stat(filepath.Join(dir, "lib", "main.jsonnet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0100 != 0 {
+		t.Fatalf("regular file is executable: %s", info.Mode())
+	}
+}
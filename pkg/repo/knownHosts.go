@@ -0,0 +1,62 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	ssh2 "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"strings"
+)
+
+// hostKeyCallback verifies host keys of the repository against known hosts of its secret and of the known hosts
+// ConfigMap. Verification is skipped only when the secret opts in by insecureIgnoreHostKey.
+func hostKeyCallback(url string, data *AuthData) (ssh2.HostKeyCallback, error) {
+	if data.InsecureIgnoreHostKey {
+		return ssh2.InsecureIgnoreHostKey(), nil
+	}
+	global, err := GetKnownHosts()
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.TrimSpace(data.KnownHosts + "\n" + global)
+	if lines == "" {
+		return nil, fmt.Errorf("no known hosts for repository %s, add knownHosts to its secret or ssh_known_hosts "+
+			"to the ConfigMap %s", url, KnownHostsConfigMap)
+	}
+	return knownHostsCallback(lines)
+}
+
+// knownHostsCallback makes a callback of known_hosts lines which tells unknown hosts from changed host keys.
+func knownHostsCallback(lines string) (ssh2.HostKeyCallback, error) {
+	f, err := os.CreateTemp("", "known_hosts-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(lines + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	callback, err := knownhosts.New(f.Name())
+	if err != nil {
+		return nil, fmt.Errorf("invalid known hosts: %w", err)
+	}
+	return func(hostname string, remote net.Addr, key ssh2.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) == 0 {
+			return fmt.Errorf("host %s is not in known hosts, its %s key has fingerprint %s",
+				hostname, key.Type(), ssh2.FingerprintSHA256(key))
+		}
+		return fmt.Errorf("host key of %s does not match known hosts, got %s key with fingerprint %s, "+
+			"the host key has changed or the connection is intercepted", hostname, key.Type(), ssh2.FingerprintSHA256(key))
+	}, nil
+}
@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	ssh2 "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"strings"
+	"testing"
+)
+
+func newHostKey(t *testing.T) ssh2.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh2.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKnownHosts(t *testing.T) {
+	github := newHostKey(t)
+	gitlab := newHostKey(t)
+	lines := knownhosts.Line([]string{"github.com"}, github) + "\n" +
+		knownhosts.Line([]string{knownhosts.Normalize("gitlab.example.com:2222")}, gitlab)
+	callback, err := knownHostsCallback(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+
+	if err := callback("github.com:22", addr, github); err != nil {
+		t.Errorf("known host key rejected: %s", err)
+	}
+	if err := callback("gitlab.example.com:2222", addr, gitlab); err != nil {
+		t.Errorf("known host key on a custom port rejected: %s", err)
+	}
+	err = callback("github.com:22", addr, gitlab)
+	if err == nil || !strings.Contains(err.Error(), "does not match known hosts") {
+		t.Errorf("changed host key accepted or unclear: %v", err)
+	}
+	err = callback("bitbucket.org:22", addr, github)
+	if err == nil || !strings.Contains(err.Error(), "is not in known hosts") {
+		t.Errorf("unknown host accepted or unclear: %v", err)
+	}
+
+	if _, err := knownHostsCallback("github.com ssh-ed25519 !!!"); err == nil {
+		t.Error("invalid known hosts accepted")
+	}
+}
+
+func TestSshAuth(t *testing.T) {
+	// known hosts of the secret only, the ConfigMap is read from the cluster
+	defer func(name string) { KnownHostsConfigMap = name }(KnownHostsConfigMap)
+	KnownHostsConfigMap = ""
+	url := "git@github.com:example/infra.git"
+	if _, err := sshAuth(url, &AuthData{KnownHosts: "github.com ssh-ed25519 AAAA"}); err == nil || !strings.Contains(err.Error(), "has no sshPrivateKey") {
+		t.Fatalf("secret without a private key accepted or unclear: %v", err)
+	}
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh2.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := string(pem.EncodeToMemory(block))
+	if _, err := sshAuth(url, &AuthData{PrivateKey: key}); err == nil || !strings.Contains(err.Error(), "no known hosts") {
+		t.Fatalf("secret without known hosts accepted or unclear: %v", err)
+	}
+
+	github := newHostKey(t)
+	auth, err := sshAuth(url, &AuthData{PrivateKey: key, KnownHosts: knownhosts.Line([]string{"github.com"}, github)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+	if err := auth.HostKeyCallback("github.com:22", addr, github); err != nil {
+		t.Errorf("known host key rejected: %s", err)
+	}
+	if err := auth.HostKeyCallback("github.com:22", addr, newHostKey(t)); err == nil {
+		t.Error("changed host key accepted")
+	}
+}
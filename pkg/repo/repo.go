@@ -13,8 +13,8 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/rs/zerolog"
 	"github.com/whilp/git-urls"
-	ssh2 "golang.org/x/crypto/ssh"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -25,6 +25,8 @@ const (
 	Commit RevisionType = iota
 	Branch RevisionType = iota
 	Tag    RevisionType = iota
+	// Semver follows the highest tag in a semver range
+	Semver RevisionType = iota
 )
 
 func (e RevisionType) String() string {
@@ -35,6 +37,8 @@ func (e RevisionType) String() string {
 		return "Branch"
 	case Tag:
 		return "Tag"
+	case Semver:
+		return "Semver"
 	default:
 		return fmt.Sprintf("%d", int(e))
 	}
@@ -44,19 +48,32 @@ type Repo struct {
 	config       *Config
 	repo         *git.Repository
 	revisionType RevisionType
-	Locker       sync.RWMutex
-	stop         chan bool
-	Status       *Status
-	log          zerolog.Logger
+	// resolvedTag is the checked out tag of a semver range
+	resolvedTag string
+	Locker      sync.RWMutex
+	stop        chan bool
+	refresh     chan struct{}
+	Status      *Status
+	log         zerolog.Logger
+	// settingsLocker guards updatePeriod and paused, they change without waiting for the worker
+	settingsLocker sync.Mutex
+	updatePeriod   time.Duration
+	paused         bool
+	// attempted is closed when the first clone of the repository succeeds or fails
+	attempted chan struct{}
 }
 
 func NewRepo(config *Config) *Repo {
 	repo := Repo{
-		config: config,
-		Status: NewStatus(),
-		Locker: sync.RWMutex{},
-		stop:   make(chan bool),
-		log:    logger.GetLogger("repository").With().Str("url", config.Url).Logger(),
+		config:       config,
+		Status:       NewStatus(),
+		Locker:       sync.RWMutex{},
+		stop:         make(chan bool),
+		refresh:      make(chan struct{}, 1),
+		attempted:    make(chan struct{}),
+		updatePeriod: config.UpdatePeriod,
+		paused:       config.Paused,
+		log:          logger.GetLogger("repository").With().Str("url", config.Url).Logger(),
 	}
 	go repo.worker()
 	return &repo
@@ -82,57 +99,74 @@ func (repo *Repo) init() error {
 	return nil
 }
 
-func (repo *Repo) getAuth() (transport.AuthMethod, error) {
+// getAuth returns the auth method of the repository and PEM certificates its https server is trusted by.
+func (repo *Repo) getAuth() (transport.AuthMethod, []byte, error) {
 	parsed, err := giturls.Parse(repo.config.Url)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	if parsed.Scheme == "file" {
+		return nil, nil, nil
+	}
 	data, err := repo.config.GetSecretData()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	var auth transport.AuthMethod
+	var caBundle []byte
 	switch parsed.Scheme {
 	case "ssh":
 		if data == nil {
-			return nil, errors.New(fmt.Sprintf("unable to find secret for repository %s", repo.config.Url))
+			return nil, nil, errors.New(fmt.Sprintf("unable to find secret for repository %s", repo.config.Url))
 		}
-		if data.PrivateKey != "" {
-			a, err := ssh.NewPublicKeys("git", []byte(data.PrivateKey), "")
-			if err != nil {
-				repo.log.Error().Err(err).Msg("Generate public keys failed")
-				return nil, err
-			}
-			a.HostKeyCallback = ssh2.InsecureIgnoreHostKey()
-			if err != nil {
-				repo.log.Error().Err(err).Msg("Generate public keys failed:")
-				return nil, err
-			}
-			auth = a
+		if data.InsecureIgnoreHostKey {
+			repo.log.Warn().Msg("host key verification is disabled by the repository secret")
 		}
-	case "https":
-		if data == nil {
-			return nil, nil
-		}
-		if data.Username != "" {
-			auth = &http.BasicAuth{
-				Username: data.Username,
-				Password: data.Password,
-			}
+		a, err := sshAuth(repo.config.Url, data)
+		if err != nil {
+			repo.log.Error().Err(err).Msg("ssh auth setup failed")
+			return nil, nil, err
 		}
-	case "http":
+		auth = a
+	case "https", "http":
 		if data == nil {
-			return nil, nil
+			return nil, nil, nil
 		}
-		if data.Username != "" {
+		if data.BearerToken != "" {
+			auth = &http.TokenAuth{
+				Token: data.BearerToken,
+			}
+		} else if data.Username != "" {
 			auth = &http.BasicAuth{
 				Username: data.Username,
 				Password: data.Password,
 			}
 		}
+		if data.CABundle != "" {
+			caBundle = []byte(data.CABundle)
+		}
+	}
+	if auth != nil {
+		repo.log.Debug().Str("auth", auth.Name()).Msg("auth detected")
+	}
+	return auth, caBundle, nil
+}
+
+// sshAuth authenticates by the private key of the secret and verifies host keys. A secret without a private key is
+// rejected, otherwise go-git would fall back to the ssh agent and the known_hosts of the user.
+func sshAuth(url string, data *AuthData) (*ssh.PublicKeys, error) {
+	if data.PrivateKey == "" {
+		return nil, fmt.Errorf("secret of repository %s has no sshPrivateKey", url)
+	}
+	auth, err := ssh.NewPublicKeys("git", []byte(data.PrivateKey), data.Passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sshPrivateKey: %w", err)
+	}
+	auth.HostKeyCallback, err = hostKeyCallback(url, data)
+	if err != nil {
+		return nil, err
 	}
-	repo.log.Debug().Str("auth", auth.Name()).Msg("auth detected")
 	return auth, nil
 }
 
@@ -142,13 +176,14 @@ func (repo *Repo) initRepo() (*git.Repository, error) {
 	transport.UnsupportedCapabilities = []capability.Capability{
 		capability.ThinPack,
 	}
-	auth, err := repo.getAuth()
+	auth, caBundle, err := repo.getAuth()
 	if err != nil {
 		return nil, err
 	}
 
 	r, err := git.PlainClone(repo.config.Path, false, &git.CloneOptions{
 		Auth:     auth,
+		CABundle: caBundle,
 		URL:      repo.config.Url,
 		Progress: os.Stdout,
 	})
@@ -180,6 +215,10 @@ func (repo *Repo) detectRevisionType() (RevisionType, error) {
 		return Branch, nil
 	}
 
+	if _, ok := semverRange(repo.config.Revision); ok {
+		return Semver, nil
+	}
+
 	return 0, errors.New("undetected Revision type")
 }
 
@@ -228,24 +267,67 @@ func (repo *Repo) checkout() error {
 			repo.log.Debug().Str("revision", repo.config.Revision).Msg("checkout Tag success")
 		}
 		return err
+	case Semver:
+		tag, hash, err := repo.resolveSemver()
+		if err != nil {
+			return err
+		}
+		err = w.Checkout(&git.CheckoutOptions{
+			Hash: hash,
+		})
+		if err == nil {
+			repo.resolvedTag = tag
+			repo.log.Debug().Str("revision", repo.config.Revision).Str("tag", tag).Msg("checkout Semver success")
+		}
+		return err
 	default:
 		return nil
 	}
 }
 
+// resolveSemver returns the highest fetched tag in the semver range of the revision and its commit.
+func (repo *Repo) resolveSemver() (string, plumbing.Hash, error) {
+	c, ok := semverRange(repo.config.Revision)
+	if !ok {
+		return "", plumbing.ZeroHash, fmt.Errorf("revision %s is not a semver range", repo.config.Revision)
+	}
+	iter, err := repo.repo.Tags()
+	if err != nil {
+		return "", plumbing.ZeroHash, err
+	}
+	var tags []string
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		tags = append(tags, ref.Name().Short())
+		return nil
+	})
+	if err != nil {
+		return "", plumbing.ZeroHash, err
+	}
+	tag := highestTag(c, tags)
+	if tag == "" {
+		return "", plumbing.ZeroHash, fmt.Errorf("no tag matches %s", repo.config.Revision)
+	}
+	hash, err := repo.repo.ResolveRevision(plumbing.Revision(plumbing.NewTagReferenceName(tag)))
+	if err != nil {
+		return "", plumbing.ZeroHash, err
+	}
+	return tag, *hash, nil
+}
+
 func (repo *Repo) checkUpdates() (bool, error) {
 	repo.log.Debug().Msg("Checking for updates")
 	switch repo.revisionType {
 	case Commit:
 		return false, nil
 	case Branch:
-		auth, err := repo.getAuth()
+		auth, caBundle, err := repo.getAuth()
 		if err != nil {
 			return false, err
 		}
 		err = repo.repo.Fetch(&git.FetchOptions{
 			RemoteName: "origin",
 			Auth:       auth,
+			CABundle:   caBundle,
 		})
 		if err != nil {
 			if fmt.Sprint(err) != "already up-to-date" {
@@ -276,7 +358,11 @@ func (repo *Repo) checkUpdates() (bool, error) {
 			Msg("get commit hashes success")
 		return r.Hash().String() != remoteCommit.Hash.String(), nil
 	case Tag:
-		err := repo.repo.Fetch(&git.FetchOptions{RemoteName: "origin"})
+		auth, caBundle, err := repo.getAuth()
+		if err != nil {
+			return false, err
+		}
+		err = repo.repo.Fetch(&git.FetchOptions{RemoteName: "origin", Auth: auth, CABundle: caBundle})
 		if err != nil {
 			if fmt.Sprint(err) != "already up-to-date" {
 				return false, err
@@ -287,6 +373,8 @@ func (repo *Repo) checkUpdates() (bool, error) {
 
 		remote, _ := repo.repo.Remote("origin")
 		refs, err := remote.List(&git.ListOptions{
+			Auth:          auth,
+			CABundle:      caBundle,
 			PeelingOption: git.AppendPeeled,
 		})
 		if err != nil {
@@ -310,6 +398,34 @@ func (repo *Repo) checkUpdates() (bool, error) {
 			Bool("needUpdate", remoteRef.Hash().String() != repo.getCommitSha()).
 			Msg("get commit hashes success")
 		return remoteRef.Hash().String() != repo.getCommitSha(), nil
+	case Semver:
+		auth, caBundle, err := repo.getAuth()
+		if err != nil {
+			return false, err
+		}
+		err = repo.repo.Fetch(&git.FetchOptions{
+			RemoteName: "origin",
+			Auth:       auth,
+			CABundle:   caBundle,
+			Tags:       git.AllTags,
+		})
+		if err != nil {
+			if fmt.Sprint(err) != "already up-to-date" {
+				return false, err
+			}
+			repo.log.Debug().Msg("already up-to-date")
+		}
+		tag, hash, err := repo.resolveSemver()
+		if err != nil {
+			return false, err
+		}
+		repo.log.Debug().
+			Str("tag", tag).
+			Str("remoteCommit", hash.String()).
+			Str("localCommit", repo.getCommitSha()).
+			Bool("needUpdate", hash.String() != repo.getCommitSha()).
+			Msg("get commit hashes success")
+		return hash.String() != repo.getCommitSha(), nil
 	default:
 		return false, errors.New("incorrect Revision type")
 	}
@@ -337,8 +453,10 @@ func (repo *Repo) update() error {
 			return err
 		}
 		return err
+	case Semver:
+		return repo.checkout()
 	case Branch:
-		auth, err := repo.getAuth()
+		auth, caBundle, err := repo.getAuth()
 		if err != nil {
 			return err
 		}
@@ -346,6 +464,7 @@ func (repo *Repo) update() error {
 		err = w.Pull(&git.PullOptions{
 			RemoteName: "origin",
 			Auth:       auth,
+			CABundle:   caBundle,
 		})
 		if err != nil {
 			return err
@@ -359,17 +478,70 @@ func (repo *Repo) update() error {
 func (repo *Repo) worker() {
 	log := repo.log.With().Str("system", "repository worker").Logger()
 	log.Debug().Msg("starting")
+	repo.work()
+	close(repo.attempted)
 	for {
 		select {
 		case <-repo.stop:
 			log.Debug().Msg("Got stop message")
 			return
-		default:
+		case <-repo.refresh:
+			log.Debug().Msg("Got refresh message")
+			repo.work()
+		case <-time.After(repo.settings().updatePeriod):
 			repo.work()
-			time.Sleep(repo.config.UpdatePeriod)
 		}
 	}
 }
+
+// WaitInitialization blocks until the first clone of the repository succeeds or fails, it reports false when
+// the clone is still in progress after the timeout.
+func (repo *Repo) WaitInitialization(timeout time.Duration) bool {
+	select {
+	case <-repo.attempted:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Configure changes the update period and pauses or resumes updates, the worker applies them at once.
+func (repo *Repo) Configure(updatePeriod time.Duration, paused bool) {
+	repo.settingsLocker.Lock()
+	changed := repo.updatePeriod != updatePeriod || repo.paused != paused
+	repo.updatePeriod = updatePeriod
+	repo.paused = paused
+	repo.settingsLocker.Unlock()
+	if changed {
+		repo.log.Info().Dur("updatePeriod", updatePeriod).Bool("paused", paused).Msg("update settings changed")
+		repo.Refresh()
+	}
+}
+
+type settings struct {
+	updatePeriod time.Duration
+	paused       bool
+}
+
+func (repo *Repo) settings() settings {
+	repo.settingsLocker.Lock()
+	defer repo.settingsLocker.Unlock()
+	return settings{updatePeriod: repo.updatePeriod, paused: repo.paused}
+}
+
+// Refresh makes the worker check the remote for updates now instead of waiting for the next poll.
+// Refreshes requested while one is pending are merged into it.
+func (repo *Repo) Refresh() {
+	select {
+	case repo.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Matches reports whether a push of the ref to one of the urls changes the revision of the repository.
+func (repo *Repo) Matches(urls []string, ref string) bool {
+	return repo.config.Matches(urls, ref)
+}
 func (repo *Repo) work() {
 	log := repo.log.With().Str("system", "repository worker").Logger()
 	log.Debug().Msg("do work")
@@ -394,10 +566,22 @@ func (repo *Repo) work() {
 		repo.Status.Message = "Repository initialization success"
 		repo.Status.IsUpdateSuccess = true
 		repo.Status.CommitSha = repo.getCommitSha()
+		repo.Status.ResolvedTag = repo.resolvedTag
 		repo.Status.Revision = repo.config.Revision
+		repo.Status.Paused = repo.settings().paused
 		return
 	}
 
+	if repo.settings().paused {
+		if !repo.Status.Paused {
+			log.Info().Str("commitSha", repo.Status.CommitSha).Msg("updates paused")
+		}
+		repo.Status.Paused = true
+		repo.Status.Message = "Updates paused"
+		return
+	}
+	repo.Status.Paused = false
+
 	res, err := repo.checkUpdates()
 	if err != nil {
 		repo.Status.IsUpdateSuccess = false
@@ -416,6 +600,7 @@ func (repo *Repo) work() {
 			repo.Status.IsUpdateSuccess = true
 			repo.Status.Message = "Update success"
 			repo.Status.CommitSha = repo.getCommitSha()
+			repo.Status.ResolvedTag = repo.resolvedTag
 			log.Info().Str("revision", repo.Status.Revision).
 				Str("commitSha", repo.Status.CommitSha).
 				Msg("update success")
@@ -445,6 +630,13 @@ func (repo *Repo) Destroy() error {
 }
 
 func (repo *Repo) Execute(task *executor.ExecCommand) (*executor.ExecResult, error) {
+	res, _, err := repo.ExecuteCheckout(task)
+	return res, err
+}
+
+// ExecuteCheckout evaluates the task against the checkout and returns the execution result and the evaluated
+// commit sha, which is read under the same lock as the evaluation.
+func (repo *Repo) ExecuteCheckout(task *executor.ExecCommand) (*executor.ExecResult, string, error) {
 	repo.log.Debug().Msg("execute")
 	unlock := func() {
 		repo.Locker.RUnlock()
@@ -456,12 +648,104 @@ func (repo *Repo) Execute(task *executor.ExecCommand) (*executor.ExecResult, err
 	if !repo.Status.IsInitialized {
 		err := errors.New("repository not initialized")
 		repo.log.Warn().Err(err).Msg("execution failed")
-		return nil, err
+		return nil, "", err
 	}
 
+	sha := repo.Status.CommitSha
 	res, err := executor.Execute(repo.config.Path, task)
 	if err != nil {
-		return nil, err
+		return nil, sha, err
 	}
-	return res, err
+	return res, sha, nil
+}
+
+// ExecuteRevision evaluates the task against another revision of the repository without changing the checkout.
+// It returns the execution result and the evaluated commit sha. The repository is locked while the revision is
+// exported only, the evaluation reads the exported tree.
+func (repo *Repo) ExecuteRevision(revision string, task *executor.ExecCommand) (*executor.ExecResult, string, error) {
+	repo.log.Debug().Str("revision", revision).Msg("execute revision")
+	dir, err := os.MkdirTemp("", "crossform-revision-")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(dir)
+
+	sha, err := repo.exportRevision(revision, dir)
+	if err != nil {
+		return nil, "", err
+	}
+	res, err := executor.Execute(dir, task)
+	if err != nil {
+		return nil, sha, err
+	}
+	return res, sha, nil
+}
+
+func (repo *Repo) exportRevision(revision, dir string) (string, error) {
+	repo.Locker.RLock()
+	repo.log.Debug().Msg("read locked")
+	defer func() {
+		repo.Locker.RUnlock()
+		repo.log.Debug().Msg("read unlocked")
+	}()
+	if !repo.Status.IsInitialized {
+		err := errors.New("repository not initialized")
+		repo.log.Warn().Err(err).Msg("execution failed")
+		return "", err
+	}
+	return ExportRevision(repo.repo, revision, dir)
+}
+
+// Export writes the tree of the checked out commit into root/<commit sha> unless it is there already and returns
+// the directory and the sha. Exported trees never change, so they are read without locks of the repository.
+func (repo *Repo) Export(root string) (string, string, error) {
+	repo.Locker.RLock()
+	defer repo.Locker.RUnlock()
+	if !repo.Status.IsInitialized {
+		return "", "", fmt.Errorf("repository not initialized: %s", repo.Status.Message)
+	}
+
+	sha := repo.Status.CommitSha
+	dir, err := repo.exportCommit(root, sha)
+	if err != nil {
+		return "", "", err
+	}
+	return dir, sha, nil
+}
+
+// ExportCommit writes the tree of a commit of the repository into root/<commit sha> unless it is there already and
+// returns the directory, like Export does for the checked out commit.
+func (repo *Repo) ExportCommit(root, sha string) (string, error) {
+	repo.Locker.RLock()
+	defer repo.Locker.RUnlock()
+	if !repo.Status.IsInitialized {
+		return "", fmt.Errorf("repository not initialized: %s", repo.Status.Message)
+	}
+	return repo.exportCommit(root, sha)
+}
+
+func (repo *Repo) exportCommit(root, sha string) (string, error) {
+	dir := filepath.Join(root, sha)
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(root, sha+"-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	if _, err := ExportRevision(repo.repo, sha, tmp); err != nil {
+		return "", err
+	}
+	// a concurrent export of the same commit may have won, its tree is the same
+	if err := os.Rename(tmp, dir); err != nil {
+		if _, statErr := os.Stat(dir); statErr != nil {
+			return "", err
+		}
+	}
+	repo.log.Debug().Str("commitSha", sha).Str("directory", dir).Msg("revision exported")
+	return dir, nil
 }
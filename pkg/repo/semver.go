@@ -0,0 +1,36 @@
+package repo
+
+import (
+	"github.com/Masterminds/semver/v3"
+	"strings"
+)
+
+// semverRange parses revisions like ~1.4 or ">=2.0.0 <3.0.0", branch and tag names are not ranges.
+func semverRange(revision string) (*semver.Constraints, bool) {
+	if revision == "" || strings.ContainsAny(revision, "/") {
+		return nil, false
+	}
+	c, err := semver.NewConstraint(revision)
+	if err != nil {
+		return nil, false
+	}
+	return c, true
+}
+
+// highestTag returns the tag of the highest version in the range, tags which are not versions are skipped.
+// It returns an empty string when no tag matches.
+func highestTag(c *semver.Constraints, tags []string) string {
+	var best *semver.Version
+	tag := ""
+	for _, t := range tags {
+		v, err := semver.NewVersion(t)
+		if err != nil || !c.Check(v) {
+			continue
+		}
+		if best == nil || v.GreaterThan(best) {
+			best = v
+			tag = t
+		}
+	}
+	return tag
+}
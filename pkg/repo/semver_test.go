@@ -0,0 +1,40 @@
+package repo
+
+import "testing"
+
+func TestSemverRange(t *testing.T) {
+	tags := []string{"v1.3.9", "v1.4.0", "v1.4.7", "1.5.0", "v2.1.0", "v2.2.0-rc.1", "latest", "release-3"}
+	cases := map[string]string{
+		"~1.4":            "v1.4.7",
+		"^1":              "1.5.0",
+		">=2.0.0 <3.0.0":  "v2.1.0",
+		">=2.2.0-rc.0":    "v2.2.0-rc.1",
+		">=3":             "",
+		"1.3.x || 1.5.x":  "1.5.0",
+		"v1.4.0 - v1.4.5": "v1.4.0",
+	}
+	for revision, expected := range cases {
+		c, ok := semverRange(revision)
+		if !ok {
+			t.Errorf("%s is not a range", revision)
+			continue
+		}
+		if tag := highestTag(c, tags); tag != expected {
+			t.Errorf("%s resolved to %q, expected %q", revision, tag, expected)
+		}
+	}
+	for _, revision := range []string{"main", "feature/x", ""} {
+		if _, ok := semverRange(revision); ok {
+			t.Errorf("%s is a range", revision)
+		}
+	}
+
+	config := NewRevisionConfig("https://github.com/example/infra.git", "~1.4")
+	urls := []string{"git@github.com:example/infra.git"}
+	if !config.Matches(urls, "refs/tags/v1.4.8") {
+		t.Error("push of a tag in the range does not match")
+	}
+	if config.Matches(urls, "refs/tags/v1.5.0") || config.Matches(urls, "refs/heads/v1.4.8") {
+		t.Error("push of a ref out of the range matches")
+	}
+}
@@ -8,6 +8,10 @@ type Status struct {
 	IsUpdateSuccess bool
 	CommitSha       string
 	Revision        string
+	// ResolvedTag is the checked out tag of a semver range revision
+	ResolvedTag string
+	// Paused is set while updates are paused, the checkout stays at CommitSha
+	Paused bool
 }
 
 func NewStatus() *Status {
@@ -26,5 +30,7 @@ func (s Status) MarshalZerologObject(e *zerolog.Event) {
 		Str("Message", s.Message).
 		Bool("IsUpdateSuccess", s.IsUpdateSuccess).
 		Str("CommitSha", s.CommitSha).
-		Str("Revision", s.Revision)
+		Str("Revision", s.Revision).
+		Str("ResolvedTag", s.ResolvedTag).
+		Bool("Paused", s.Paused)
 }